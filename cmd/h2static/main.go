@@ -2,9 +2,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/albertodonato/h2static/server"
 	"github.com/albertodonato/h2static/version"
@@ -20,20 +25,82 @@ Usage of {{.Name}}:
 // NewStaticServerFromCmdline returns a a StaticServer parsing cmdline args.
 func NewStaticServerFromCmdline(fs *flag.FlagSet, args []string) (*server.StaticServer, error) {
 	var versionFlag bool
+	var configFile string
+	var testURL string
 	var conf server.StaticServerConfig
-	fs.StringVar(&conf.Addr, "addr", ":8080", "address and port to listen on")
+	fs.StringVar(&configFile, "config", "", "configuration file (TOML, YAML or JSON)")
+	conf.Addr = server.AddrList{":8080"}
+	fs.Var(
+		(*stringList)(&conf.Addr), "addr",
+		`comma-separated list of addresses and ports to listen on, "unix:<path>" for a Unix socket, or "systemd:[<name>]" for socket activation`)
+	fs.StringVar(
+		&conf.ACME.CACert, "acme-ca-cert", "",
+		"CA certificates file to trust when connecting to the ACME directory")
+	fs.StringVar(
+		&conf.ACME.CacheDir, "acme-cache-dir", "",
+		"directory to store ACME certificates and account keys in")
+	fs.StringVar(
+		&conf.ACME.DirectoryURL, "acme-directory-url", "",
+		"ACME directory URL (default Let's Encrypt)")
+	fs.Var(
+		(*stringList)(&conf.ACME.Domains), "acme-domains",
+		"comma-separated list of domains to get TLS certificates for via ACME")
+	fs.StringVar(&conf.ACME.Email, "acme-email", "", "contact email for the ACME account")
+	fs.StringVar(
+		&conf.ACME.HTTPAddr, "acme-http-addr", "",
+		"address and port to listen on for ACME HTTP-01 challenges")
 	fs.StringVar(&conf.CSS, "css", "", "file to override builtin CSS for listing")
+	fs.BoolVar(
+		&conf.Compress, "compress", false,
+		"compress responses on the fly for compressible content types")
+	fs.Int64Var(
+		&conf.CompressCacheSize, "compress-cache-size", 64<<20,
+		"maximum size in bytes of compressed content cached in memory")
+	fs.Int64Var(
+		&conf.CompressMinSize, "compress-min-size", 1024,
+		"minimum size in bytes for content to be compressed")
 	fs.BoolVar(
 		&conf.AllowOutsideSymlinks, "allow-outside-symlinks", false,
 		"allow symlinks with target outside of directory")
-	fs.StringVar(&conf.Dir, "dir", ".", "directory to serve")
+	fs.BoolVar(
+		&conf.BrowseArchives, "browse-archives", false,
+		"allow browsing the content of archives (.zip, .tar, .tar.gz) in directories")
+	fs.StringVar(&conf.Dir, "dir", ".", "directory or archive (.zip, .tar, .tar.gz) to serve")
 	fs.StringVar(&conf.DebugAddr, "debug-addr", "", "address and port to serve /debug URLs on")
 	fs.BoolVar(&conf.DisableH2, "disable-h2", false, "disable HTTP/2 support")
 	fs.BoolVar(&conf.DisableIndex, "disable-index", false, "disable directory index")
 	fs.BoolVar(
 		&conf.DisableLookupWithSuffix, "disable-lookup-with-suffix", false,
 		"disable matching files with .htm(l) suffix for paths without suffix")
+	fs.BoolVar(
+		&conf.EnableH2C, "enable-h2c", false,
+		"enable HTTP/2 support over cleartext connections (h2c), e.g. behind a TLS-terminating proxy")
+	fs.BoolVar(&conf.EnableH3, "enable-h3", false, "enable HTTP/3 support (via UDP on the same address)")
+	fs.Var(
+		(*stringList)(&conf.FallbackDirs), "fallback-dirs",
+		"comma-separated list of directories or archives to serve files not found in -dir from, in order of priority")
+	fs.BoolVar(
+		&conf.HidePrecompressed, "hide-precompressed", false,
+		"hide precompressed files (.br, .gz, .zst) from listings if the original file is present")
+	fs.BoolVar(
+		&conf.HSTSIncludeSubDomains, "hsts-include-subdomains", false,
+		"apply the Strict-Transport-Security policy to subdomains")
+	fs.IntVar(
+		&conf.HSTSMaxAge, "hsts-max-age", 0,
+		"max age in seconds for the Strict-Transport-Security header (disabled if 0)")
+	fs.BoolVar(
+		&conf.HSTSPreload, "hsts-preload", false,
+		"allow preloading of the Strict-Transport-Security policy")
+	fs.StringVar(
+		&conf.HTTPRedirectAddr, "http-redirect-addr", "",
+		"address and port to listen on for plain HTTP, redirecting to HTTPS")
+	fs.IntVar(
+		&conf.HTTPRedirectCode, "http-redirect-code", http.StatusMovedPermanently,
+		"status code for HTTP to HTTPS redirects, 301 or 308")
 	fs.BoolVar(&conf.Log, "log", false, "log requests")
+	fs.StringVar(
+		&conf.NetlifyFiles, "netlify-files", "",
+		`read Netlify-style _headers and _redirects files from the root ("root") or any directory ("dirs")`)
 	fs.StringVar(
 		&conf.PasswordFile, "basic-auth", "",
 		`password file for Basic Auth (each line should be in the form "user:SHA512-hash")`)
@@ -41,9 +108,38 @@ func NewStaticServerFromCmdline(fs *flag.FlagSet, args []string) (*server.Static
 		&conf.RequestPathPrefix, "request-path-prefix", "",
 		"prefix to strip from request path (e.g. when behind a reverse proxy)")
 	fs.BoolVar(&conf.ShowDotFiles, "show-dotfiles", false, "show files whose name starts with a dot")
+	fs.DurationVar(
+		(*time.Duration)(&conf.ShutdownTimeout), "shutdown-timeout", 5*time.Second,
+		"maximum time to wait for active connections to complete on shutdown")
+	fs.StringVar(&conf.SocketMode, "socket-mode", "", `file mode for Unix sockets, in octal (e.g. "0660")`)
+	fs.StringVar(&conf.SocketOwner, "socket-owner", "", `owner for Unix sockets, as "user[:group]"`)
+	fs.StringVar(&testURL, "test-url", "", "print the rewrite rule matching a URL (path or full URL) and exit")
 	fs.StringVar(&conf.TLSCert, "tls-cert", "", "certificate file for TLS connections")
+	fs.StringVar(
+		&conf.TLSCertDir, "tls-cert-dir", "",
+		"directory with additional certificate (.crt/.pem) and key (.key) files, selected via SNI")
+	fs.Var(
+		(*stringList)(&conf.TLSCipherSuites), "tls-cipher-suites",
+		"comma-separated list of TLS cipher suites (overrides the profile)")
+	fs.StringVar(
+		&conf.TLSClientAuth, "tls-client-auth", "",
+		`TLS client certificates verification mode, "request" or "require" (default "require" if a CA is set)`)
+	fs.StringVar(
+		&conf.TLSClientCA, "tls-client-ca", "",
+		"CA certificates file to verify TLS client certificates")
+	fs.Var(
+		(*stringList)(&conf.TLSCurves), "tls-curves",
+		"comma-separated list of elliptic curves for TLS key exchange (overrides the profile)")
 	fs.StringVar(&conf.TLSKey, "tls-key", "", "key file for TLS connections")
+	fs.StringVar(
+		&conf.TLSMinVersion, "tls-min-version", "",
+		"minimum TLS version, 1.0, 1.1, 1.2 or 1.3 (overrides the profile)")
+	fs.StringVar(
+		&conf.TLSProfile, "tls-profile", "",
+		`TLS parameters profile, "modern", "intermediate" or "legacy"`)
 	fs.BoolVar(&versionFlag, "version", false, "print program version and exit")
+	// keep a copy of default values, to load a config file on top of them
+	defaults := conf
 	fs.Usage = func() {
 		printHeader(fs)
 		fs.PrintDefaults()
@@ -56,9 +152,79 @@ func NewStaticServerFromCmdline(fs *flag.FlagSet, args []string) (*server.Static
 		fs.Output().Write([]byte(version.App.String() + "\n"))
 		os.Exit(0)
 	}
+	if configFile != "" {
+		if err := loadConfigFile(fs, &conf, defaults, configFile); err != nil {
+			return nil, err
+		}
+	}
+	if testURL != "" {
+		if err := printRewriteRuleMatch(fs.Output(), conf, testURL); err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
 	return server.NewStaticServer(conf)
 }
 
+// printRewriteRuleMatch prints the rewrite rule matching a URL, without
+// serving requests.
+func printRewriteRuleMatch(w io.Writer, conf server.StaticServerConfig, rawURL string) error {
+	match, err := conf.MatchRewriteRules(rawURL)
+	if err != nil {
+		return err
+	}
+	if match == nil {
+		_, err = fmt.Fprintf(w, "%s: no rule matches\n", rawURL)
+		return err
+	}
+	_, err = fmt.Fprintf(
+		w, "%s: rewrite-rules[%d] matches, %s to %s\n",
+		rawURL, match.Index, match.Action, match.Target)
+	return err
+}
+
+// loadConfigFile loads the configuration file on top of default values, then
+// applies options that were explicitly passed on the command line, so that
+// they take precedence.
+func loadConfigFile(fs *flag.FlagSet, conf *server.StaticServerConfig, defaults server.StaticServerConfig, path string) error {
+	cmdlineValues := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		cmdlineValues[f.Name] = f.Value.String()
+	})
+	fileConf := defaults
+	if err := fileConf.LoadFile(path); err != nil {
+		return err
+	}
+	// flag values point to the conf fields, so they're updated by this
+	*conf = fileConf
+	for name, value := range cmdlineValues {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stringList is a flag.Value for a comma-separated list of strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
 func printHeader(fs *flag.FlagSet) {
 	tpl := template.Must(template.New("helpHeader").Parse(helpHeaderTemplate))
 	if err := tpl.Execute(fs.Output(), version.App); err != nil {
@@ -2,8 +2,10 @@ package main_test
 
 import (
 	"flag"
+	"fmt"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 
@@ -56,19 +58,172 @@ func (s *H2StaticTestSuite) TestNewStaticServerFromCmdline() {
 		s.flagSet,
 		[]string{
 			"-addr", ":9090", "-allow-outside-symlinks", "-basic-auth", passwdPath,
-			"-dir", dirPath, "-disable-lookup-with-suffix", "-disable-h2",
-			"-show-dotfiles", "-log", "-tls-cert", certPath, "-tls-key", keyPath})
+			"-browse-archives", "-dir", dirPath, "-disable-lookup-with-suffix", "-disable-h2", "-enable-h3",
+			"-hide-precompressed", "-show-dotfiles", "-log", "-tls-cert", certPath, "-tls-key", keyPath,
+			"-tls-cert-dir", dirPath})
 	s.Nil(err)
-	s.Equal(":9090", server.Config.Addr)
+	s.EqualValues([]string{":9090"}, server.Config.Addr)
 	s.True(server.Config.AllowOutsideSymlinks)
 	s.Equal(passwdPath, server.Config.PasswordFile)
+	s.True(server.Config.BrowseArchives)
 	s.Equal(dirPath, server.Config.Dir)
 	s.True(server.Config.DisableH2)
 	s.True(server.Config.DisableLookupWithSuffix)
+	s.True(server.Config.EnableH3)
+	s.True(server.Config.HidePrecompressed)
 	s.True(server.Config.ShowDotFiles)
 	s.True(server.Config.Log)
 	s.Equal(certPath, server.Config.TLSCert)
 	s.Equal(keyPath, server.Config.TLSKey)
+	s.Equal(dirPath, server.Config.TLSCertDir)
+}
+
+// Fallback directories can be passed on the command line.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineFallbackDirs() {
+	overrides := s.Mkdir("overrides")
+	base := s.Mkdir("base")
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet,
+		[]string{"-dir", overrides, "-fallback-dirs", base + "," + s.TempDir})
+	s.Nil(err)
+	s.Equal([]string{base, s.TempDir}, server.Config.FallbackDirs)
+}
+
+// Compression options can be passed on the command line.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineCompress() {
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet,
+		[]string{
+			"-dir", s.TempDir, "-compress",
+			"-compress-cache-size", "1000000", "-compress-min-size", "512"})
+	s.Nil(err)
+	s.True(server.Config.Compress)
+	s.Equal(int64(1000000), server.Config.CompressCacheSize)
+	s.Equal(int64(512), server.Config.CompressMinSize)
+}
+
+// The mode for Netlify-style files can be passed on the command line.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineNetlifyFiles() {
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet,
+		[]string{"-dir", s.TempDir, "-netlify-files", "dirs"})
+	s.Nil(err)
+	s.Equal("dirs", server.Config.NetlifyFiles)
+}
+
+// ACME options can be passed on the command line.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineACME() {
+	cacheDir := s.Mkdir("acme")
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet,
+		[]string{
+			"-dir", s.TempDir,
+			"-acme-domains", "example.com, www.example.com",
+			"-acme-cache-dir", cacheDir,
+			"-acme-directory-url", "https://localhost:14000/dir",
+			"-acme-email", "admin@example.com",
+			"-acme-http-addr", ":80"})
+	s.Nil(err)
+	s.Equal([]string{"example.com", "www.example.com"}, server.Config.ACME.Domains)
+	s.Equal(cacheDir, server.Config.ACME.CacheDir)
+	s.Equal("https://localhost:14000/dir", server.Config.ACME.DirectoryURL)
+	s.Equal("admin@example.com", server.Config.ACME.Email)
+	s.Equal(":80", server.Config.ACME.HTTPAddr)
+	s.True(server.Config.IsHTTPS())
+}
+
+// HTTP to HTTPS redirect and HSTS options can be passed on the command line.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineHTTPSRedirect() {
+	certPath := s.WriteFile("crt.pem", "cert")
+	keyPath := s.WriteFile("key.pem", "key")
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet,
+		[]string{
+			"-dir", s.TempDir, "-tls-cert", certPath, "-tls-key", keyPath,
+			"-http-redirect-addr", ":80", "-http-redirect-code", "308",
+			"-hsts-max-age", "31536000", "-hsts-include-subdomains", "-hsts-preload"})
+	s.Nil(err)
+	s.Equal(":80", server.Config.HTTPRedirectAddr)
+	s.Equal(308, server.Config.HTTPRedirectCode)
+	s.Equal(31536000, server.Config.HSTSMaxAge)
+	s.True(server.Config.HSTSIncludeSubDomains)
+	s.True(server.Config.HSTSPreload)
+}
+
+// HTTP/2 over cleartext connections can be enabled on the command line.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineH2C() {
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet, []string{"-dir", s.TempDir, "-enable-h2c"})
+	s.Nil(err)
+	s.True(server.Config.EnableH2C)
+}
+
+// The shutdown timeout can be passed on the command line.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineShutdownTimeout() {
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet, []string{"-dir", s.TempDir, "-shutdown-timeout", "1m"})
+	s.Nil(err)
+	s.EqualValues(time.Minute, server.Config.ShutdownTimeout)
+}
+
+// Multiple addresses can be passed on the command line.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineMultipleAddrs() {
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet, []string{"-dir", s.TempDir, "-addr", "0.0.0.0:8080, [::]:8080"})
+	s.Nil(err)
+	s.EqualValues([]string{"0.0.0.0:8080", "[::]:8080"}, server.Config.Addr)
+}
+
+// Unix socket options can be passed on the command line.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineUnixSocket() {
+	socketPath := filepath.Join(s.TempDir, "h2static.sock")
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet,
+		[]string{
+			"-dir", s.TempDir, "-addr", "unix:" + socketPath, "-socket-mode", "0660"})
+	s.Nil(err)
+	s.EqualValues([]string{"unix:" + socketPath}, server.Config.Addr)
+	s.Equal("0660", server.Config.SocketMode)
+	s.Equal(uint16(0), server.Config.Port())
+}
+
+// Options can be loaded from a config file.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineConfigFile() {
+	dirPath := s.Mkdir("dir")
+	configPath := s.WriteFile(
+		"config.yaml",
+		fmt.Sprintf("dir: %s\nlog: true\nshow-dotfiles: true\n", dirPath))
+
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet, []string{"-config", configPath})
+	s.Nil(err)
+	s.EqualValues([]string{":8080"}, server.Config.Addr)
+	s.Equal(dirPath, server.Config.Dir)
+	s.True(server.Config.Log)
+	s.True(server.Config.ShowDotFiles)
+}
+
+// Options from the command line override those from the config file.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineConfigFileOverride() {
+	dirPath := s.Mkdir("dir")
+	configPath := s.WriteFile(
+		"config.yaml",
+		fmt.Sprintf("addr: :9090\ndir: %s\n", filepath.Join("not", "here")))
+
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet, []string{"-dir", dirPath, "-config", configPath})
+	s.Nil(err)
+	s.EqualValues([]string{":9090"}, server.Config.Addr)
+	s.Equal(dirPath, server.Config.Dir)
+}
+
+// An error is returned if the config file is invalid.
+func (s *H2StaticTestSuite) TestNewStaticServerFromCmdlineConfigFileInvalid() {
+	configPath := s.WriteFile("config.toml", "unknown = true")
+	server, err := main.NewStaticServerFromCmdline(
+		s.flagSet, []string{"-config", configPath})
+	s.Nil(server)
+	s.Contains(err.Error(), `unknown field "unknown"`)
 }
 
 // Config options are validated and error returned on invalid paths.
@@ -1,11 +1,26 @@
 module github.com/albertodonato/h2static
 
-go 1.19
+go 1.23.0
 
-require github.com/stretchr/testify v1.8.4
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.17.11
+	github.com/quic-go/quic-go v0.54.0
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/crypto v0.36.0
+	golang.org/x/net v0.28.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/quic-go/qpack v0.5.1 // indirect
+	go.uber.org/mock v0.5.0 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/sync v0.12.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
 )
@@ -0,0 +1,636 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveSuffixes lists suffixes of supported archive files.
+var archiveSuffixes = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+// IsArchive returns whether the path is for a supported archive file, based
+// on its suffix.
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveFS is a read-only fs.FS with the content of a zip or tar archive.
+//
+// Only regular files and directories are included, other entries (such as
+// symlinks) are ignored. Parent directories are added for all entries, even
+// if they're not present in the archive.
+type ArchiveFS struct {
+	entries map[string]*archiveEntry
+	closer  io.Closer
+
+	mutex sync.Mutex
+	// references to the archive, which is closed when the last one is
+	// released
+	refs int
+}
+
+// OpenArchive returns an ArchiveFS for a zip, tar or gzip-compressed tar
+// archive, based on the file suffix.
+//
+// Since compressed tar archives don't support random access, files in them
+// are read by decompressing the archive from the start.
+func OpenArchive(name string) (*ArchiveFS, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	lowerName := strings.ToLower(name)
+	var archive *ArchiveFS
+	switch {
+	case strings.HasSuffix(lowerName, ".zip"):
+		archive, err = newZipArchiveFS(file)
+	case strings.HasSuffix(lowerName, ".tar"):
+		archive, err = readTarArchiveFS(file)
+	case strings.HasSuffix(lowerName, ".tar.gz"), strings.HasSuffix(lowerName, ".tgz"):
+		archive, err = newTarGzArchiveFS(file)
+	default:
+		err = errors.New("unsupported archive format")
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("invalid archive %s: %w", name, err)
+	}
+	archive.closer = file
+	return archive, nil
+}
+
+func newZipArchiveFS(file *os.File) (*ArchiveFS, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	archive := newArchiveFS()
+	for _, f := range reader.File {
+		mode := f.Mode()
+		entry := &archiveEntry{
+			mode:    mode.Perm(),
+			modTime: f.Modified,
+		}
+		switch {
+		case mode.IsDir():
+			entry.mode |= fs.ModeDir
+		case mode.IsRegular():
+			entry.size = int64(f.UncompressedSize64)
+			if f.Method == zip.Store {
+				// uncompressed content can be read directly from the file
+				offset, err := f.DataOffset()
+				if err != nil {
+					return nil, err
+				}
+				entry.content = io.NewSectionReader(file, offset, entry.size)
+			} else {
+				entry.open = f.Open
+			}
+		default:
+			continue
+		}
+		archive.add(f.Name, entry)
+	}
+	return archive, nil
+}
+
+// newTarGzArchiveFS returns an ArchiveFS for a gzip-compressed tar archive.
+//
+// The archive is indexed in a single pass over the decompressed content,
+// which is not kept in memory. Files are read by decompressing the archive
+// from the start, up to their content.
+func newTarGzArchiveFS(file *os.File) (*ArchiveFS, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	decompress := func() (*gzip.Reader, error) {
+		// each reader has its own offset in the file
+		return gzip.NewReader(io.NewSectionReader(file, 0, info.Size()))
+	}
+	stream, err := decompress()
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return indexTarArchive(
+		stream,
+		func(entry *archiveEntry, offset int64) {
+			size := entry.size
+			entry.open = func() (io.ReadCloser, error) {
+				reader, err := decompress()
+				if err != nil {
+					return nil, err
+				}
+				if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+					reader.Close()
+					return nil, err
+				}
+				return readCloser{Reader: io.LimitReader(reader, size), Closer: reader}, nil
+			}
+		})
+}
+
+// readTarArchiveFS returns an ArchiveFS for a tar archive, whose entries'
+// content is read from the archive itself.
+func readTarArchiveFS(content io.ReaderAt) (*ArchiveFS, error) {
+	return indexTarArchive(
+		io.NewSectionReader(content, 0, 1<<63-1),
+		func(entry *archiveEntry, offset int64) {
+			entry.content = io.NewSectionReader(content, offset, entry.size)
+		})
+}
+
+// indexTarArchive returns an ArchiveFS with entries from a tar archive. For
+// regular files, setContent is called with the offset of their content in
+// the archive, to set up how it's read.
+func indexTarArchive(r io.Reader, setContent func(entry *archiveEntry, offset int64)) (*ArchiveFS, error) {
+	// track the offset in the archive, so that the content of each entry
+	// can be read directly
+	counter := &countingReader{reader: r}
+	reader := tar.NewReader(counter)
+	archive := newArchiveFS()
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := &archiveEntry{
+			mode:    fs.FileMode(header.Mode).Perm(),
+			modTime: header.ModTime,
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entry.mode |= fs.ModeDir
+		case tar.TypeReg:
+			entry.size = header.Size
+			setContent(entry, counter.count)
+		case tar.TypeLink:
+			// hard links share the content of the target
+			target, ok := archive.entries[cleanArchivePath(header.Linkname)]
+			if !ok || target.IsDir() {
+				continue
+			}
+			entry.size = target.size
+			entry.content = target.content
+			entry.open = target.open
+		default:
+			continue
+		}
+		archive.add(header.Name, entry)
+	}
+	return archive, nil
+}
+
+func newArchiveFS() *ArchiveFS {
+	return &ArchiveFS{
+		entries: map[string]*archiveEntry{
+			".": {name: ".", mode: fs.ModeDir | 0555},
+		},
+	}
+}
+
+// add adds an entry to the archive, along with parent directories.
+//
+// Entries with invalid names are ignored.
+func (a *ArchiveFS) add(name string, entry *archiveEntry) {
+	name = cleanArchivePath(name)
+	if name == "." || !fs.ValidPath(name) {
+		return
+	}
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		dir = "."
+	}
+	parent := a.entries[dir]
+	if parent == nil {
+		parent = &archiveEntry{mode: fs.ModeDir | 0555}
+		a.add(dir, parent)
+	}
+	if !parent.IsDir() {
+		// a file is replaced by a directory with the same name
+		parent.mode = fs.ModeDir | 0555
+		parent.size = 0
+		parent.content = nil
+		parent.open = nil
+	}
+	entry.name = base
+	if existing := a.entries[name]; existing != nil {
+		// later entries override previous ones, but directories are
+		// never replaced by files, and retain the entries under them.
+		if existing.IsDir() {
+			if !entry.IsDir() {
+				return
+			}
+			entry.children = existing.children
+		}
+		*existing = *entry
+		return
+	}
+	a.entries[name] = entry
+	parent.children = append(parent.children, base)
+}
+
+// Open opens the named file.
+func (a *ArchiveFS) Open(name string) (fs.File, error) {
+	entry, err := a.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDir() {
+		return &archiveDir{archive: a, entry: entry, name: name}, nil
+	}
+	file := &archiveFile{entry: entry}
+	if entry.content != nil {
+		file.reader = io.NewSectionReader(entry.content, 0, entry.size)
+	} else {
+		file.reader = &reopeningReader{open: entry.open, size: entry.size}
+	}
+	return file, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (a *ArchiveFS) Stat(name string) (fs.FileInfo, error) {
+	return a.lookup("stat", name)
+}
+
+// ReadDir returns the entries of the named directory, sorted by name.
+func (a *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := a.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return a.dirEntries(name, entry), nil
+}
+
+// Close closes the archive file.
+func (a *ArchiveFS) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// acquire adds a reference to the archive.
+func (a *ArchiveFS) acquire() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.refs++
+}
+
+// release removes a reference to the archive, closing it if it was the last
+// one.
+func (a *ArchiveFS) release() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.refs--
+	if a.refs == 0 {
+		a.Close()
+	}
+}
+
+// indexSize returns the approximate memory size of the archive index.
+func (a *ArchiveFS) indexSize() int64 {
+	size := int64(0)
+	for name, entry := range a.entries {
+		size += archiveEntrySize + int64(len(name)+len(entry.name))
+		for _, child := range entry.children {
+			size += int64(len(child)) + 16
+		}
+	}
+	return size
+}
+
+func (a *ArchiveFS) lookup(op, name string) (*archiveEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := a.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+func (a *ArchiveFS) dirEntries(name string, entry *archiveEntry) []fs.DirEntry {
+	names := append([]string(nil), entry.children...)
+	sort.Strings(names)
+	entries := make([]fs.DirEntry, len(names))
+	for i, child := range names {
+		entries[i] = fs.FileInfoToDirEntry(a.entries[path.Join(name, child)])
+	}
+	return entries
+}
+
+// cleanArchivePath returns a clean relative path for an archive entry.
+func cleanArchivePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// archiveEntry is a file or directory in an archive.
+type archiveEntry struct {
+	name    string
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+	// names of entries in a directory
+	children []string
+	// content of the file, if it can be read directly from the archive
+	content io.ReaderAt
+	// opens the file content, if it can only be read sequentially
+	open func() (io.ReadCloser, error)
+}
+
+func (e *archiveEntry) Name() string       { return e.name }
+func (e *archiveEntry) Size() int64        { return e.size }
+func (e *archiveEntry) Mode() fs.FileMode  { return e.mode }
+func (e *archiveEntry) ModTime() time.Time { return e.modTime }
+func (e *archiveEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *archiveEntry) Sys() any           { return nil }
+
+// archiveFile is an open file in an archive. It supports seeking, to allow
+// serving ranges.
+type archiveFile struct {
+	entry  *archiveEntry
+	reader io.ReadSeeker
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) {
+	return f.entry, nil
+}
+
+func (f *archiveFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+func (f *archiveFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *archiveFile) Close() error {
+	if closer, ok := f.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// archiveDir is an open directory in an archive.
+type archiveDir struct {
+	archive *ArchiveFS
+	entry   *archiveEntry
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) {
+	return d.entry, nil
+}
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *archiveDir) Close() error {
+	return nil
+}
+
+func (d *archiveDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		d.entries = d.archive.dirEntries(d.name, d.entry)
+	}
+	remaining := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
+
+// reopeningReader provides seeking over content that can only be read
+// sequentially. Seeking backwards reopens the content and skips data up to
+// the offset.
+type reopeningReader struct {
+	open   func() (io.ReadCloser, error)
+	size   int64
+	reader io.ReadCloser
+	// offset of the underlying reader
+	readOffset int64
+	// offset requested via Seek
+	offset int64
+}
+
+func (r *reopeningReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.reader == nil || r.offset < r.readOffset {
+		if err := r.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	if r.offset > r.readOffset {
+		if _, err := io.CopyN(io.Discard, r.reader, r.offset-r.readOffset); err != nil {
+			return 0, err
+		}
+		r.readOffset = r.offset
+	}
+	n, err := r.reader.Read(p)
+	r.readOffset += int64(n)
+	r.offset = r.readOffset
+	return n, err
+}
+
+func (r *reopeningReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *reopeningReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
+
+func (r *reopeningReader) reopen() error {
+	if r.reader != nil {
+		r.reader.Close()
+	}
+	reader, err := r.open()
+	if err != nil {
+		r.reader = nil
+		return err
+	}
+	r.reader = reader
+	r.readOffset = 0
+	return nil
+}
+
+// readCloser combines a Reader with the Closer of another one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// countingReader tracks the offset in a reader. It supports seeking if the
+// reader does, so that tar.Reader can skip content of entries without
+// reading it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+func (r *countingReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.reader.(io.Seeker)
+	if !ok {
+		return -1, errors.New("seek not supported")
+	}
+	position, err := seeker.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	r.count = position
+	return position, nil
+}
+
+// archiveEntrySize is the approximate memory size of an entry in an archive
+// index, excluding names.
+const archiveEntrySize = 200
+
+// archiveCacheSize is the maximum size in bytes of archive indexes kept in
+// memory for browsing.
+const archiveCacheSize = 64 << 20
+
+// defaultArchiveCache is used for browsing archives from FileSystems without
+// their own cache.
+var defaultArchiveCache = newArchiveCache(archiveCacheSize)
+
+// archiveCache keeps archives open, to avoid reading their index on each
+// request.
+//
+// The cache holds a reference to each archive, which is released when it's
+// evicted. Archives used by requests are only closed once they're served.
+type archiveCache struct {
+	// serializes lookups and additions, so that archives are not evicted
+	// between being looked up and acquired
+	mutex    sync.Mutex
+	archives *lruCache[string, *cachedArchive]
+}
+
+type cachedArchive struct {
+	size    int64
+	modTime time.Time
+	archive *ArchiveFS
+}
+
+func newArchiveCache(maxSize int64) *archiveCache {
+	archives := newSizedLRUCache[string](
+		maxSize,
+		func(c *cachedArchive) int64 { return c.archive.indexSize() })
+	archives.onEvict = func(c *cachedArchive) { c.archive.release() }
+	return &archiveCache{archives: archives}
+}
+
+// Open returns the ArchiveFS for an archive file. The archive is reopened
+// if the file has changed since it was cached.
+//
+// If refs is not nil, a reference to the archive is added to it, so that the
+// archive is kept open until they're released. Otherwise, archives too large
+// to be cached are returned closed, and only their index can be used.
+func (c *archiveCache) Open(path string, info fs.FileInfo, refs *archiveRefs) (*ArchiveFS, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, ok := c.archives.Get(path)
+	if !ok || cached.size != info.Size() || !cached.modTime.Equal(info.ModTime()) {
+		archive, err := OpenArchive(path)
+		if err != nil {
+			return nil, err
+		}
+		cached = &cachedArchive{size: info.Size(), modTime: info.ModTime(), archive: archive}
+		// the reference for the cache, released when the archive is evicted
+		archive.acquire()
+		if !c.archives.Add(path, cached) {
+			// too large to be cached, only kept open while referenced
+			defer archive.release()
+		}
+	}
+	if refs != nil {
+		cached.archive.acquire()
+		refs.archives = append(refs.archives, cached.archive)
+	}
+	return cached.archive, nil
+}
+
+// Purge releases all cached archives.
+func (c *archiveCache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.archives.Purge()
+}
+
+// archiveRefs holds references to archives used while serving a request.
+type archiveRefs struct {
+	archives []*ArchiveFS
+}
+
+// release releases all references.
+func (r *archiveRefs) release() {
+	for _, archive := range r.archives {
+		archive.release()
+	}
+	r.archives = nil
+}
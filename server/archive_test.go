@@ -0,0 +1,526 @@
+package server_test
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+var archiveFiles = map[string]string{
+	"index.html":       "index content",
+	"docs/":            "",
+	"docs/guide.html":  "guide content",
+	"docs/.hidden":     "hidden content",
+	"assets/css/a.css": "body {}",
+	"data.txt":         "0123456789",
+}
+
+func TestIsArchive(t *testing.T) {
+	suite.Run(t, new(IsArchiveTestSuite))
+}
+
+type IsArchiveTestSuite struct {
+	suite.Suite
+}
+
+// Archives are detected by suffix.
+func (s *IsArchiveTestSuite) TestIsArchive() {
+	s.True(server.IsArchive("build.zip"))
+	s.True(server.IsArchive("build.tar"))
+	s.True(server.IsArchive("build.tar.gz"))
+	s.True(server.IsArchive("BUILD.TGZ"))
+	s.False(server.IsArchive("build"))
+	s.False(server.IsArchive("build.gz"))
+}
+
+func TestArchiveFS(t *testing.T) {
+	suite.Run(t, new(ArchiveFSTestSuite))
+}
+
+type ArchiveFSTestSuite struct {
+	testhelpers.TempDirTestSuite
+}
+
+func (s *ArchiveFSTestSuite) openArchives() map[string]*server.ArchiveFS {
+	paths := map[string]string{
+		"zip-deflate": s.WriteZip("deflate.zip", archiveFiles, zip.Deflate),
+		"zip-store":   s.WriteZip("store.zip", archiveFiles, zip.Store),
+		"tar":         s.WriteTar("archive.tar", archiveFiles),
+		"tar.gz":      s.WriteTar("archive.tar.gz", archiveFiles),
+		"tgz":         s.WriteTar("archive.tgz", archiveFiles),
+	}
+	archives := make(map[string]*server.ArchiveFS)
+	for kind, path := range paths {
+		archive, err := server.OpenArchive(path)
+		s.Require().Nil(err, kind)
+		s.T().Cleanup(func() { archive.Close() })
+		archives[kind] = archive
+	}
+	return archives
+}
+
+// Archives are valid fs.FS, with implicit parent directories.
+func (s *ArchiveFSTestSuite) TestFS() {
+	for kind, archive := range s.openArchives() {
+		err := fstest.TestFS(
+			archive, "index.html", "docs/guide.html", "docs/.hidden",
+			"assets/css/a.css", "data.txt")
+		s.Nil(err, kind)
+	}
+}
+
+// File details are from the archive.
+func (s *ArchiveFSTestSuite) TestStat() {
+	for kind, archive := range s.openArchives() {
+		info, err := fs.Stat(archive, "docs/guide.html")
+		s.Nil(err, kind)
+		s.Equal("guide.html", info.Name(), kind)
+		s.Equal(int64(13), info.Size(), kind)
+		s.False(info.IsDir(), kind)
+		s.True(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Equal(info.ModTime()), kind)
+
+		info, err = fs.Stat(archive, "assets/css")
+		s.Nil(err, kind)
+		s.True(info.IsDir(), kind)
+	}
+}
+
+// Files content can be read from any offset.
+func (s *ArchiveFSTestSuite) TestSeek() {
+	for kind, archive := range s.openArchives() {
+		file, err := archive.Open("data.txt")
+		s.Nil(err, kind)
+		seeker := file.(io.ReadSeeker)
+		size, err := seeker.Seek(0, io.SeekEnd)
+		s.Nil(err, kind)
+		s.Equal(int64(10), size, kind)
+		_, err = seeker.Seek(5, io.SeekStart)
+		s.Nil(err, kind)
+		content, err := io.ReadAll(seeker)
+		s.Nil(err, kind)
+		s.Equal("56789", string(content), kind)
+		// seek backwards
+		_, err = seeker.Seek(2, io.SeekStart)
+		s.Nil(err, kind)
+		buf := make([]byte, 3)
+		_, err = io.ReadFull(seeker, buf)
+		s.Nil(err, kind)
+		s.Equal("234", string(buf), kind)
+		s.Nil(file.Close(), kind)
+	}
+}
+
+// An error is returned for files not in the archive.
+func (s *ArchiveFSTestSuite) TestOpenNotFound() {
+	for kind, archive := range s.openArchives() {
+		_, err := archive.Open("not-here")
+		s.ErrorIs(err, fs.ErrNotExist, kind)
+	}
+}
+
+// Names with leading slashes or dots are cleaned up, so that all entries are
+// under the archive root.
+func (s *ArchiveFSTestSuite) TestCleanNames() {
+	path := s.WriteTar("archive.tar", map[string]string{
+		"./foo":      "foo",
+		"/bar":       "bar",
+		"../outside": "outside",
+	})
+	archive, err := server.OpenArchive(path)
+	s.Nil(err)
+	defer archive.Close()
+	s.Nil(fstest.TestFS(archive, "foo", "bar", "outside"))
+	entries, err := archive.ReadDir(".")
+	s.Nil(err)
+	s.Len(entries, 3)
+}
+
+// Content of tar entries is skipped when reading the archive index.
+func (s *ArchiveFSTestSuite) TestTarIndexSkipsContent() {
+	content := strings.Repeat("x", 1<<20)
+	path := s.WriteTar("archive.tar", map[string]string{"a.bin": content, "b.bin": content})
+	file, err := os.Open(path)
+	s.Nil(err)
+	defer file.Close()
+	reader := &countingReaderAt{reader: file}
+	archive, err := server.ReadTarArchiveFS(reader)
+	s.Nil(err)
+	s.Less(reader.count, int64(len(content)))
+	data, err := fs.ReadFile(archive, "b.bin")
+	s.Nil(err)
+	s.Equal(content, string(data))
+}
+
+// Files in compressed tar archives can be read at the same time, each
+// decompressing the archive independently.
+func (s *ArchiveFSTestSuite) TestTarGzConcurrentReads() {
+	archive := s.openArchives()["tar.gz"]
+	first, err := archive.Open("index.html")
+	s.Nil(err)
+	defer first.Close()
+	second, err := archive.Open("data.txt")
+	s.Nil(err)
+	defer second.Close()
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(first, buf)
+	s.Nil(err)
+	s.Equal("index", string(buf))
+	_, err = io.ReadFull(second, buf)
+	s.Nil(err)
+	s.Equal("01234", string(buf))
+	content, err := io.ReadAll(first)
+	s.Nil(err)
+	s.Equal(" content", string(content))
+	content, err = io.ReadAll(second)
+	s.Nil(err)
+	s.Equal("56789", string(content))
+}
+
+// An error is returned for invalid archives.
+func (s *ArchiveFSTestSuite) TestInvalidArchive() {
+	path := s.WriteFile("archive.zip", "not a zip")
+	archive, err := server.OpenArchive(path)
+	s.Nil(archive)
+	s.ErrorContains(err, "invalid archive "+path)
+}
+
+// countingReaderAt counts bytes read from a ReaderAt.
+type countingReaderAt struct {
+	reader io.ReaderAt
+	count  int64
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	n, err := r.reader.ReadAt(p, offset)
+	r.count += int64(n)
+	return n, err
+}
+
+func TestArchiveCache(t *testing.T) {
+	suite.Run(t, new(ArchiveCacheTestSuite))
+}
+
+type ArchiveCacheTestSuite struct {
+	testhelpers.TempDirTestSuite
+}
+
+// readable returns whether file content can be read from the archive.
+func (s *ArchiveCacheTestSuite) readable(archive *server.ArchiveFS) bool {
+	_, err := fs.ReadFile(archive, "data.txt")
+	return err == nil
+}
+
+// indexSize returns the index size of an archive.
+func (s *ArchiveCacheTestSuite) indexSize(path string) int64 {
+	archive, err := server.OpenArchive(path)
+	s.Require().Nil(err)
+	defer archive.Close()
+	return server.ArchiveIndexSize(archive)
+}
+
+// Archives are cached until they change.
+func (s *ArchiveCacheTestSuite) TestCached() {
+	path := s.WriteTar("archive.tar", archiveFiles)
+	cache := server.NewArchiveCache(1 << 20)
+	archive, release, err := server.OpenCachedArchive(cache, path)
+	s.Nil(err)
+	release()
+	s.True(s.readable(archive))
+	cached, release, err := server.OpenCachedArchive(cache, path)
+	s.Nil(err)
+	release()
+	s.Same(archive, cached)
+
+	s.WriteTar("archive.tar", map[string]string{"data.txt": "changed"})
+	changed, release, err := server.OpenCachedArchive(cache, path)
+	s.Nil(err)
+	defer release()
+	s.NotSame(archive, changed)
+	s.False(s.readable(archive))
+}
+
+// Archives evicted from the cache are closed once they're released.
+func (s *ArchiveCacheTestSuite) TestEvictedClosedWhenReleased() {
+	first := s.WriteTar("first.tar", archiveFiles)
+	second := s.WriteTar("second.tar", archiveFiles)
+	cache := server.NewArchiveCache(s.indexSize(first))
+	archive, release, err := server.OpenCachedArchive(cache, first)
+	s.Nil(err)
+	_, releaseSecond, err := server.OpenCachedArchive(cache, second)
+	s.Nil(err)
+	releaseSecond()
+	s.True(s.readable(archive))
+	release()
+	s.False(s.readable(archive))
+}
+
+// Archives too large for the cache are closed once they're released.
+func (s *ArchiveCacheTestSuite) TestTooLarge() {
+	path := s.WriteTar("archive.tar", archiveFiles)
+	cache := server.NewArchiveCache(s.indexSize(path) - 1)
+	archive, release, err := server.OpenCachedArchive(cache, path)
+	s.Nil(err)
+	s.True(s.readable(archive))
+	release()
+	s.False(s.readable(archive))
+}
+
+// Archives too large for the cache are closed right away if no reference is
+// taken for them.
+func (s *ArchiveCacheTestSuite) TestTooLargeNoRefs() {
+	path := s.WriteTar("archive.tar", archiveFiles)
+	cache := server.NewArchiveCache(s.indexSize(path) - 1)
+	archive, err := server.OpenCachedArchiveNoRefs(cache, path)
+	s.Nil(err)
+	s.False(s.readable(archive))
+	_, err = fs.Stat(archive, "data.txt")
+	s.Nil(err)
+}
+
+// Purging the cache releases all archives.
+func (s *ArchiveCacheTestSuite) TestPurge() {
+	path := s.WriteTar("archive.tar", archiveFiles)
+	cache := server.NewArchiveCache(1 << 20)
+	archive, release, err := server.OpenCachedArchive(cache, path)
+	s.Nil(err)
+	release()
+	server.PurgeArchiveCache(cache)
+	s.False(s.readable(archive))
+}
+
+func TestArchiveFileHandler(t *testing.T) {
+	suite.Run(t, new(ArchiveFileHandlerTestSuite))
+}
+
+type ArchiveFileHandlerTestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	handler *server.FileHandler
+}
+
+func (s *ArchiveFileHandlerTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	archive, err := server.OpenArchive(s.WriteZip("site.zip", archiveFiles, zip.Deflate))
+	s.Require().Nil(err)
+	s.T().Cleanup(func() { archive.Close() })
+	fileSystem := server.FileSystem{
+		FS:           archive,
+		ResolveHTML:  true,
+		HideDotFiles: true,
+	}
+	s.handler = server.NewFileHandler(fileSystem, true, "")
+}
+
+func (s *ArchiveFileHandlerTestSuite) get(path string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+// The index file is served for the archive root.
+func (s *ArchiveFileHandlerTestSuite) TestIndex() {
+	w := s.get("/", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("index content", w.Body.String())
+}
+
+// Files are resolved with the .html suffix.
+func (s *ArchiveFileHandlerTestSuite) TestResolveHTML() {
+	w := s.get("/docs/guide", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal("guide content", w.Body.String())
+}
+
+// Directories in the archive are listed.
+func (s *ArchiveFileHandlerTestSuite) TestListing() {
+	w := s.get("/docs/", map[string]string{"Accept": "application/json"})
+	s.Equal(http.StatusOK, w.Code)
+	s.JSONEq(`{"Name": "/docs", "IsRoot": false, "Entries": [{"Name": "guide.html", "IsDir": false, "Size": 13}]}`, w.Body.String())
+}
+
+// Ranges of compressed files are served.
+func (s *ArchiveFileHandlerTestSuite) TestRange() {
+	w := s.get("/data.txt", map[string]string{"Range": "bytes=4-6"})
+	s.Equal(http.StatusPartialContent, w.Code)
+	s.Equal("bytes 4-6/10", w.Header().Get("Content-Range"))
+	s.Equal("456", w.Body.String())
+}
+
+// Last-Modified is set from the archive entry.
+func (s *ArchiveFileHandlerTestSuite) TestLastModified() {
+	w := s.get("/data.txt", nil)
+	s.Equal("Thu, 02 Jan 2020 03:04:05 GMT", w.Header().Get("Last-Modified"))
+}
+
+func TestBrowseArchives(t *testing.T) {
+	suite.Run(t, new(BrowseArchivesTestSuite))
+}
+
+type BrowseArchivesTestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	fs      server.FileSystem
+	handler *server.FileHandler
+}
+
+func (s *BrowseArchivesTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	s.Mkdir("releases")
+	s.WriteZip("releases/build.zip", archiveFiles, zip.Deflate)
+	s.WriteTar("releases/build.tar.gz", archiveFiles)
+	s.Mkdir("releases/dir.zip")
+	s.fs = server.FileSystem{
+		Root:           s.TempDir,
+		ResolveHTML:    true,
+		HideDotFiles:   true,
+		BrowseArchives: true,
+	}
+	s.handler = server.NewFileHandler(s.fs, true, "")
+}
+
+func (s *BrowseArchivesTestSuite) get(path string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+// Files in archives can be opened.
+func (s *BrowseArchivesTestSuite) TestOpen() {
+	for _, name := range []string{"build.zip", "build.tar.gz"} {
+		file, err := s.fs.Open("/releases/" + name + "/docs/guide")
+		s.Nil(err, name)
+		s.Equal("guide content", readFile(&s.Suite, file), name)
+	}
+}
+
+// The archive root is opened if the path ends with a slash.
+func (s *BrowseArchivesTestSuite) TestOpenArchiveRoot() {
+	file, err := s.fs.Open("/releases/build.zip/")
+	s.Nil(err)
+	s.True(file.Info.IsDir())
+	s.Equal([]string{"assets", "data.txt", "docs", "index.html"}, fileList(&s.Suite, file))
+}
+
+// The archive file is opened if the path doesn't end with a slash.
+func (s *BrowseArchivesTestSuite) TestOpenArchiveFile() {
+	file, err := s.fs.Open("/releases/build.zip")
+	s.Nil(err)
+	s.False(file.Info.IsDir())
+	s.True(file.IsArchive())
+}
+
+// Archives can't be browsed if not enabled.
+func (s *BrowseArchivesTestSuite) TestOpenNotEnabled() {
+	s.fs.BrowseArchives = false
+	_, err := s.fs.Open("/releases/build.zip/index.html")
+	s.NotNil(err)
+	file, err := s.fs.Open("/releases/build.zip")
+	s.Nil(err)
+	s.False(file.IsArchive())
+}
+
+// Directories with an archive suffix are not treated as archives.
+func (s *BrowseArchivesTestSuite) TestOpenDirectoryWithArchiveSuffix() {
+	s.WriteFile("releases/dir.zip/foo", "foo")
+	file, err := s.fs.Open("/releases/dir.zip/foo")
+	s.Nil(err)
+	s.Equal("foo", readFile(&s.Suite, file))
+}
+
+// Dotfiles in archives are hidden.
+func (s *BrowseArchivesTestSuite) TestOpenHideDotFiles() {
+	_, err := s.fs.Open("/releases/build.zip/docs/.hidden")
+	s.ErrorIs(err, os.ErrNotExist)
+}
+
+// Archives are reported in listing.
+func (s *BrowseArchivesTestSuite) TestListing() {
+	w := s.get("/releases/", map[string]string{"Accept": "application/json"})
+	s.Equal(http.StatusOK, w.Code)
+	var content server.DirInfo
+	s.Nil(json.NewDecoder(w.Body).Decode(&content))
+	s.Len(content.Entries, 3)
+	for _, entry := range content.Entries {
+		s.Equal(!entry.IsDir, entry.IsArchive, entry.Name)
+	}
+
+	w = s.get("/releases/", nil)
+	s.Contains(w.Body.String(), `<a title="Browse build.zip" href="build.zip/" class="col type-archive">browse</a>`)
+}
+
+// Directories in archives are listed.
+func (s *BrowseArchivesTestSuite) TestListingArchive() {
+	w := s.get("/releases/build.zip/docs/", map[string]string{"Accept": "application/json"})
+	s.Equal(http.StatusOK, w.Code)
+	var content server.DirInfo
+	s.Nil(json.NewDecoder(w.Body).Decode(&content))
+	s.Equal("/releases/build.zip/docs", content.Name)
+	s.Equal([]server.DirEntryInfo{{Name: "guide.html", Size: 13}}, content.Entries)
+}
+
+// Files in archives are served.
+func (s *BrowseArchivesTestSuite) TestServeFile() {
+	w := s.get("/releases/build.zip/docs/guide.html", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal("guide content", w.Body.String())
+}
+
+// Ranges of files in archives are served.
+func (s *BrowseArchivesTestSuite) TestServeFileRange() {
+	w := s.get("/releases/build.tar.gz/data.txt", map[string]string{"Range": "bytes=1-2"})
+	s.Equal(http.StatusPartialContent, w.Code)
+	s.Equal("12", w.Body.String())
+}
+
+// The archive file itself is served without trailing slash.
+func (s *BrowseArchivesTestSuite) TestServeArchiveFile() {
+	w := s.get("/releases/build.zip", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("application/zip", w.Header().Get("Content-Type"))
+}
+
+// The index file of archive directories is served.
+func (s *BrowseArchivesTestSuite) TestServeIndex() {
+	w := s.get("/releases/build.zip/", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("index content", w.Body.String())
+}
+
+// Directories in archives are redirected to the URL with trailing slash.
+func (s *BrowseArchivesTestSuite) TestRedirectDirectory() {
+	w := s.get("/releases/build.zip/docs", nil)
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("/releases/build.zip/docs/", w.Header().Get("Location"))
+}
+
+// Archives are not reopened if not changed.
+func (s *BrowseArchivesTestSuite) TestArchiveCached() {
+	s.Equal(http.StatusOK, s.get("/releases/build.zip/data.txt", nil).Code)
+	// replace the archive with different content
+	s.WriteZip("releases/build.zip", map[string]string{"new.txt": "new"}, zip.Store)
+	s.Equal(http.StatusNotFound, s.get("/releases/build.zip/data.txt", nil).Code)
+	s.Equal(http.StatusOK, s.get("/releases/build.zip/new.txt", nil).Code)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxCompressedFileSize is the maximum size for files to be compressed on
+// the fly, since their content is compressed in memory.
+const maxCompressedFileSize = 32 << 20
+
+// compressionEncodings lists content encodings for on-the-fly compression,
+// along with a function returning a compressing writer, in order of
+// preference.
+var compressionEncodings = []struct {
+	encoding  string
+	newWriter func(io.Writer) io.WriteCloser
+}{
+	{
+		encoding: "br",
+		newWriter: func(w io.Writer) io.WriteCloser {
+			return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+		},
+	},
+	{
+		encoding: "zstd",
+		newWriter: func(w io.Writer) io.WriteCloser {
+			// an error is only returned for invalid options
+			encoder, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+			return encoder
+		},
+	},
+	{
+		encoding: "gzip",
+		newWriter: func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		},
+	},
+}
+
+// compressibleTypes lists content types which are compressed on the fly,
+// besides text/* ones.
+var compressibleTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/wasm":          true,
+	"application/xhtml+xml":     true,
+	"application/xml":           true,
+	"font/otf":                  true,
+	"font/ttf":                  true,
+	"image/bmp":                 true,
+	"image/svg+xml":             true,
+	"image/x-icon":              true,
+}
+
+// isCompressible returns whether content of the specified type benefits
+// from compression.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+// Compressor compresses responses on the fly for clients accepting it.
+//
+// Compressed content for files is cached in memory, up to a maximum size,
+// evicting the least recently used entries.
+type Compressor struct {
+	// Minimum size in bytes for content to be compressed
+	MinSize int64
+
+	cache *lruCache[compressionCacheKey, []byte]
+}
+
+// NewCompressor returns a Compressor, caching up to cacheSize bytes of
+// compressed content.
+func NewCompressor(minSize, cacheSize int64) *Compressor {
+	return &Compressor{
+		MinSize: minSize,
+		cache: newSizedLRUCache[compressionCacheKey](
+			cacheSize, func(data []byte) int64 { return int64(len(data)) }),
+	}
+}
+
+// encoding returns the encoding to compress content of the specified type
+// and size with for a request, or an empty string if it shouldn't be
+// compressed.
+//
+// If content could be compressed, the response is marked as varying based
+// on the Accept-Encoding header.
+func (c *Compressor) encoding(w http.ResponseWriter, r *http.Request, contentType string, size int64) string {
+	if size < c.MinSize || size > maxCompressedFileSize || !isCompressible(contentType) {
+		return ""
+	}
+	addVaryAcceptEncoding(w.Header())
+	encodings := make([]string, len(compressionEncodings))
+	for i, e := range compressionEncodings {
+		encodings[i] = e.encoding
+	}
+	return preferredEncoding(acceptedEncodings(r.Header.Get("Accept-Encoding")), encodings)
+}
+
+// serveFile serves the content of a File compressed, if the request accepts
+// it and its type and size allow. It returns false if the content is not
+// compressed, in which case nothing is written to the response.
+//
+// Compressed content is cached by handler and path of the file. If the
+// response has an ETag for the file, it's updated for the encoding.
+func (c *Compressor) serveFile(w http.ResponseWriter, r *http.Request, file *File, handlerID uint64, filePath string) (bool, error) {
+	ctype, err := contentType(file)
+	if err != nil {
+		return false, err
+	}
+	encoding := c.encoding(w, r, ctype, file.Info.Size())
+	if encoding == "" {
+		return false, nil
+	}
+	key := compressionCacheKey{
+		handler:  handlerID,
+		path:     filePath,
+		encoding: encoding,
+		inode:    fileInode(file.Info),
+		size:     file.Info.Size(),
+		modTime:  file.Info.ModTime().UnixNano(),
+	}
+	data, ok := c.cache.Get(key)
+	if !ok {
+		content, err := file.Open()
+		if err != nil {
+			return false, err
+		}
+		defer content.Close()
+		if data, err = compress(encoding, content); err != nil {
+			return false, err
+		}
+		c.cache.Add(key, data)
+	}
+	header := w.Header()
+	header.Set("Content-Encoding", encoding)
+	header.Set("Content-Type", ctype)
+	if etag := header.Get("ETag"); etag != "" {
+		header.Set("ETag", encodedETag(etag, encoding))
+	}
+	http.ServeContent(w, r, file.Info.Name(), file.Info.ModTime(), bytes.NewReader(data))
+	return true, nil
+}
+
+// compressContent compresses generated content for the response, if the
+// request accepts it and its type and size allow. It returns the content to
+// write along with its encoding, which is empty if the content is not
+// compressed.
+func (c *Compressor) compressContent(w http.ResponseWriter, r *http.Request, data []byte) ([]byte, string, error) {
+	header := w.Header()
+	encoding := c.encoding(w, r, header.Get("Content-Type"), int64(len(data)))
+	if encoding == "" {
+		return data, "", nil
+	}
+	compressed, err := compress(encoding, bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	header.Set("Content-Encoding", encoding)
+	return compressed, encoding, nil
+}
+
+// compress returns content compressed with the specified encoding.
+func compress(encoding string, content io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, e := range compressionEncodings {
+		if e.encoding != encoding {
+			continue
+		}
+		writer := e.newWriter(&buf)
+		if _, err := io.Copy(writer, content); err != nil {
+			writer.Close()
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		break
+	}
+	return buf.Bytes(), nil
+}
+
+// handlerIDs provides identifiers for FileHandlers, to tell apart cached
+// content for files with the same path served by different handlers.
+var handlerIDs atomic.Uint64
+
+// compressionCacheKey identifies compressed content for a file. Content is
+// only reused as long as the file inode, size and modification time don't
+// change.
+type compressionCacheKey struct {
+	handler  uint64
+	path     string
+	encoding string
+	inode    uint64
+	size     int64
+	modTime  int64
+}
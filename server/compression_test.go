@@ -0,0 +1,229 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestCompression(t *testing.T) {
+	suite.Run(t, new(CompressionTestSuite))
+}
+
+type CompressionTestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	compressor *server.Compressor
+	handler    *server.FileHandler
+	content    string
+}
+
+func (s *CompressionTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	s.compressor = server.NewCompressor(100, 1<<20)
+	s.handler = server.NewFileHandler(
+		server.FileSystem{Root: s.TempDir, ResolveHTML: true, HideDotFiles: true},
+		true, "")
+	s.handler.Compressor = s.compressor
+	s.content = strings.Repeat("some compressible content\n", 20)
+	s.WriteFile("page.html", s.content)
+}
+
+func (s *CompressionTestSuite) get(path, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+// decode returns the decoded body of a response.
+func (s *CompressionTestSuite) decode(w *httptest.ResponseRecorder) string {
+	var reader io.Reader
+	switch encoding := w.Header().Get("Content-Encoding"); encoding {
+	case "br":
+		reader = brotli.NewReader(w.Body)
+	case "zstd":
+		decoder, err := zstd.NewReader(w.Body)
+		s.Require().Nil(err)
+		defer decoder.Close()
+		reader = decoder
+	case "gzip":
+		gzipReader, err := gzip.NewReader(w.Body)
+		s.Require().Nil(err)
+		reader = gzipReader
+	default:
+		s.Require().Equal("", encoding)
+		reader = w.Body
+	}
+	data, err := io.ReadAll(reader)
+	s.Require().Nil(err)
+	return string(data)
+}
+
+// Files are compressed with the preferred encoding accepted by the client.
+func (s *CompressionTestSuite) TestCompressFile() {
+	for acceptEncoding, encoding := range map[string]string{
+		"gzip, deflate, br, zstd": "br",
+		"gzip, zstd":              "zstd",
+		"gzip":                    "gzip",
+		"br;q=0.5, gzip":          "gzip",
+	} {
+		w := s.get("/page.html", acceptEncoding)
+		s.Equal(http.StatusOK, w.Code, acceptEncoding)
+		s.Equal(encoding, w.Header().Get("Content-Encoding"), acceptEncoding)
+		s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		s.Equal("Accept-Encoding", w.Header().Get("Vary"))
+		s.Less(w.Body.Len(), len(s.content))
+		s.Equal(s.content, s.decode(w), acceptEncoding)
+	}
+}
+
+// Files are not compressed if the client doesn't accept it.
+func (s *CompressionTestSuite) TestNoAcceptEncoding() {
+	w := s.get("/page.html", "")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("", w.Header().Get("Content-Encoding"))
+	s.Equal("Accept-Encoding", w.Header().Get("Vary"))
+	s.Equal(s.content, w.Body.String())
+}
+
+// Files smaller than the minimum size are not compressed.
+func (s *CompressionTestSuite) TestSmallFile() {
+	s.WriteFile("small.html", "small")
+	w := s.get("/small.html", "gzip")
+	s.Equal("", w.Header().Get("Content-Encoding"))
+	s.Equal("", w.Header().Get("Vary"))
+	s.Equal("small", w.Body.String())
+}
+
+// Files with content types that don't benefit from compression are not
+// compressed.
+func (s *CompressionTestSuite) TestNotCompressible() {
+	s.WriteFile("image.png", s.content)
+	w := s.get("/image.png", "gzip")
+	s.Equal("", w.Header().Get("Content-Encoding"))
+	s.Equal("", w.Header().Get("Vary"))
+	s.Equal(s.content, w.Body.String())
+}
+
+// Content types are detected for files with unknown extensions.
+func (s *CompressionTestSuite) TestDetectContentType() {
+	s.WriteFile("README", s.content)
+	w := s.get("/README", "gzip")
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal("text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal(s.content, s.decode(w))
+}
+
+// Precompressed variants are preferred to compression on the fly.
+func (s *CompressionTestSuite) TestPrecompressedVariant() {
+	s.WriteFile("page.html.gz", "precompressed")
+	w := s.get("/page.html", "gzip, br")
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal("precompressed", w.Body.String())
+	s.Equal([]string{"Accept-Encoding"}, w.Header().Values("Vary"))
+}
+
+// Compressed content is cached, and updated when the file changes.
+func (s *CompressionTestSuite) TestCache() {
+	s.Equal(int64(0), server.CompressorCacheSize(s.compressor))
+	w := s.get("/page.html", "gzip")
+	size := server.CompressorCacheSize(s.compressor)
+	s.Equal(int64(w.Body.Len()), size)
+	s.get("/page.html", "gzip")
+	s.Equal(size, server.CompressorCacheSize(s.compressor))
+
+	content := strings.Repeat("some other content\n", 20)
+	path := s.WriteFile("page.html", content)
+	modTime := time.Now().Add(time.Minute)
+	s.Nil(os.Chtimes(path, modTime, modTime))
+	w = s.get("/page.html", "gzip")
+	s.Equal(content, s.decode(w))
+}
+
+// The least recently used content is evicted when the cache is full.
+func (s *CompressionTestSuite) TestCacheEviction() {
+	w := s.get("/page.html", "gzip")
+	size := int64(w.Body.Len())
+	s.compressor = server.NewCompressor(100, size+size/2)
+	s.handler.Compressor = s.compressor
+	s.WriteFile("other.html", s.content)
+	s.get("/page.html", "gzip")
+	s.get("/other.html", "gzip")
+	s.Equal(size, server.CompressorCacheSize(s.compressor))
+}
+
+// Content larger than the cache is compressed but not cached.
+func (s *CompressionTestSuite) TestCacheTooLarge() {
+	s.compressor = server.NewCompressor(100, 10)
+	s.handler.Compressor = s.compressor
+	w := s.get("/page.html", "gzip")
+	s.Equal(s.content, s.decode(w))
+	s.Equal(int64(0), server.CompressorCacheSize(s.compressor))
+}
+
+// Ranges apply to the compressed content.
+func (s *CompressionTestSuite) TestRange() {
+	full := s.get("/page.html", "gzip").Body.Bytes()
+	r := httptest.NewRequest("GET", "/page.html", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=0-9")
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	s.Equal(http.StatusPartialContent, w.Code)
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal(full[:10], w.Body.Bytes())
+}
+
+// HTML directory listings are compressed.
+func (s *CompressionTestSuite) TestListingHTML() {
+	for i := range 5 {
+		s.WriteFile(strings.Repeat("x", i+1)+".txt", "")
+	}
+	w := s.get("/", "gzip")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Contains(s.decode(w), `href="page.html"`)
+}
+
+// JSON directory listings are compressed.
+func (s *CompressionTestSuite) TestListingJSON() {
+	s.compressor.MinSize = 10
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("Accept-Encoding", "zstd")
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("zstd", w.Header().Get("Content-Encoding"))
+	var content server.DirInfo
+	s.Nil(json.NewDecoder(bytes.NewBufferString(s.decode(w))).Decode(&content))
+	s.Equal([]server.DirEntryInfo{{Name: "page.html", Size: int64(len(s.content))}}, content.Entries)
+}
+
+// Small directory listings are not compressed.
+func (s *CompressionTestSuite) TestListingSmall() {
+	s.compressor.MinSize = 1 << 20
+	w := s.get("/", "gzip")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("", w.Header().Get("Content-Encoding"))
+	s.Contains(w.Body.String(), `href="page.html"`)
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin
+
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Cached content is not reused for a file replaced by one with the same size
+// and modification time.
+func (s *CompressionTestSuite) TestCacheFileReplaced() {
+	etag := s.get("/page.html", "gzip").Header().Get("ETag")
+	info := s.Stat("page.html")
+	content := strings.Repeat("other compressible content\n", 20)[:len(s.content)]
+	path := s.WriteFile("new.html", content)
+	s.Nil(os.Chtimes(path, info.ModTime(), info.ModTime()))
+	s.Nil(os.Rename(path, filepath.Join(s.TempDir, "page.html")))
+	w := s.get("/page.html", "gzip")
+	s.Equal(content, s.decode(w))
+	s.NotEqual(etag, w.Header().Get("ETag"))
+}
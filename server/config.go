@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is returned when a configuration option has an invalid value.
+type ConfigError struct {
+	// The configuration key (matching the command line option name)
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// prefixConfigError returns a copy of a *ConfigError with the key prefixed,
+// for options in nested sections.
+func prefixConfigError(prefix string, err error) error {
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		return &ConfigError{Key: prefix + "." + configErr.Key, Err: configErr.Err}
+	}
+	return &ConfigError{Key: prefix, Err: err}
+}
+
+// Duration is a time.Duration set in configuration files as a string (e.g.
+// "30s" or "1m30s").
+type Duration time.Duration
+
+// UnmarshalJSON decodes the duration from a string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid duration: %s", data)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %s", value)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// LoadFile updates the config with values from a file.
+//
+// The file format is detected from its extension, and can be TOML (.toml),
+// YAML (.yaml, .yml) or JSON (.json). Keys match the command line option
+// names. Options not present in the file are left unchanged.
+func (c *StaticServerConfig) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// decode the file content to a generic map, then re-encode it as JSON
+	// and decode it into the config, so that the same field names and
+	// checks apply to all formats.
+	values := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	case ".json":
+		err = json.Unmarshal(content, &values)
+	default:
+		return fmt.Errorf("unsupported config file format: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	content, err = json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
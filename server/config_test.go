@@ -0,0 +1,295 @@
+package server_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestConfigFile(t *testing.T) {
+	suite.Run(t, new(ConfigFileTestSuite))
+}
+
+type ConfigFileTestSuite struct {
+	testhelpers.TempDirTestSuite
+}
+
+// Config is loaded from a TOML file.
+func (s *ConfigFileTestSuite) TestLoadFileTOML() {
+	path := s.WriteFile(
+		"config.toml",
+		`
+addr = ":9090"
+dir = "/srv/www"
+disable-h2 = true
+basic-auth = "/etc/passwords"
+`)
+	var config server.StaticServerConfig
+	s.Nil(config.LoadFile(path))
+	s.Equal(
+		server.StaticServerConfig{
+			Addr:         server.AddrList{":9090"},
+			Dir:          "/srv/www",
+			DisableH2:    true,
+			PasswordFile: "/etc/passwords",
+		},
+		config)
+}
+
+// Config is loaded from a YAML file.
+func (s *ConfigFileTestSuite) TestLoadFileYAML() {
+	path := s.WriteFile(
+		"config.yaml",
+		`
+addr: ":9090"
+dir: /srv/www
+show-dotfiles: true
+`)
+	var config server.StaticServerConfig
+	s.Nil(config.LoadFile(path))
+	s.Equal(
+		server.StaticServerConfig{
+			Addr:         server.AddrList{":9090"},
+			Dir:          "/srv/www",
+			ShowDotFiles: true,
+		},
+		config)
+}
+
+// Config is loaded from a JSON file.
+func (s *ConfigFileTestSuite) TestLoadFileJSON() {
+	path := s.WriteFile(
+		"config.json",
+		`{"addr": ":9090", "tls-cert": "cert.pem", "tls-key": "key.pem"}`)
+	var config server.StaticServerConfig
+	s.Nil(config.LoadFile(path))
+	s.Equal(
+		server.StaticServerConfig{
+			Addr:    server.AddrList{":9090"},
+			TLSCert: "cert.pem",
+			TLSKey:  "key.pem",
+		},
+		config)
+}
+
+// Multiple addresses can be specified as a list.
+func (s *ConfigFileTestSuite) TestLoadFileAddrList() {
+	path := s.WriteFile(
+		"config.toml",
+		`addr = ["0.0.0.0:8080", "[::]:8080", "unix:/run/h2static.sock"]`)
+	var config server.StaticServerConfig
+	s.Nil(config.LoadFile(path))
+	s.Equal(
+		server.AddrList{"0.0.0.0:8080", "[::]:8080", "unix:/run/h2static.sock"},
+		config.Addr)
+}
+
+// An error is returned if addresses are not strings.
+func (s *ConfigFileTestSuite) TestLoadFileAddrListInvalid() {
+	path := s.WriteFile("config.yaml", "addr: [8080]")
+	var config server.StaticServerConfig
+	err := config.LoadFile(path)
+	s.NotNil(err)
+	s.Contains(err.Error(), "invalid address list: [8080]")
+}
+
+// Durations are loaded from strings.
+func (s *ConfigFileTestSuite) TestLoadFileDuration() {
+	path := s.WriteFile("config.yaml", "shutdown-timeout: 1m30s")
+	var config server.StaticServerConfig
+	s.Nil(config.LoadFile(path))
+	s.Equal(server.Duration(90*time.Second), config.ShutdownTimeout)
+}
+
+// An error is returned if a duration is invalid.
+func (s *ConfigFileTestSuite) TestLoadFileDurationInvalid() {
+	path := s.WriteFile("config.json", `{"shutdown-timeout": "soon"}`)
+	var config server.StaticServerConfig
+	err := config.LoadFile(path)
+	s.NotNil(err)
+	s.Contains(err.Error(), "invalid duration: soon")
+}
+
+// Virtual hosts are loaded from the file.
+func (s *ConfigFileTestSuite) TestLoadFileHosts() {
+	path := s.WriteFile(
+		"config.toml",
+		`
+dir = "/srv/default"
+
+[[hosts]]
+names = ["example.com", "www.example.com"]
+dir = "/srv/example"
+disable-index = true
+
+[[hosts]]
+names = ["example.org"]
+dir = "/srv/other"
+basic-auth = "/etc/passwords"
+`)
+	var config server.StaticServerConfig
+	s.Nil(config.LoadFile(path))
+	s.Equal(
+		server.StaticServerConfig{
+			Dir: "/srv/default",
+			Hosts: []server.HostConfig{
+				{
+					Names:        []string{"example.com", "www.example.com"},
+					Dir:          "/srv/example",
+					DisableIndex: true,
+				},
+				{
+					Names:        []string{"example.org"},
+					Dir:          "/srv/other",
+					PasswordFile: "/etc/passwords",
+				},
+			},
+		},
+		config)
+}
+
+// Header rules are loaded from the config file.
+func (s *ConfigFileTestSuite) TestLoadFileHeaderRules() {
+	path := s.WriteFile(
+		"config.toml",
+		`
+[[header-rules]]
+path = "/assets/**"
+set = { Cache-Control = "public, max-age=31536000, immutable" }
+
+[[header-rules]]
+content-type = "text/html"
+remove = ["Server"]
+[header-rules.set]
+Cache-Control = "no-cache"
+Content-Security-Policy = "default-src 'self'"
+`)
+	var config server.StaticServerConfig
+	s.Nil(config.LoadFile(path))
+	s.Equal(
+		[]server.HeaderRuleConfig{
+			{
+				Path: "/assets/**",
+				Set:  map[string]string{"Cache-Control": "public, max-age=31536000, immutable"},
+			},
+			{
+				ContentType: "text/html",
+				Remove:      []string{"Server"},
+				Set: map[string]string{
+					"Cache-Control":           "no-cache",
+					"Content-Security-Policy": "default-src 'self'",
+				},
+			},
+		},
+		config.HeaderRules)
+}
+
+// Rewrite rules are loaded from the file, with conditions as tables.
+func (s *ConfigFileTestSuite) TestLoadFileRewriteRules() {
+	path := s.WriteFile(
+		"config.toml",
+		`
+[[rewrite-rules]]
+action = "permanent"
+path = { prefix = "/old-docs/" }
+target = "/docs/$1"
+
+[[rewrite-rules]]
+action = "rewrite"
+host = { exact = "app.example.com" }
+path = { regexp = '^/[^.]*$' }
+target = "/app/index.html"
+`)
+	var config server.StaticServerConfig
+	s.Nil(config.LoadFile(path))
+	s.Equal(
+		[]server.RewriteRuleConfig{
+			{
+				Action: "permanent",
+				Path:   server.MatchConfig{Prefix: "/old-docs/"},
+				Target: "/docs/$1",
+			},
+			{
+				Action: "rewrite",
+				Host:   server.MatchConfig{Exact: "app.example.com"},
+				Path:   server.MatchConfig{Regexp: "^/[^.]*$"},
+				Target: "/app/index.html",
+			},
+		},
+		config.RewriteRules)
+}
+
+// Values not in the file are left unchanged.
+func (s *ConfigFileTestSuite) TestLoadFileKeepUnsetValues() {
+	path := s.WriteFile("config.yaml", "log: true")
+	config := server.StaticServerConfig{Addr: server.AddrList{":8080"}, Dir: "."}
+	s.Nil(config.LoadFile(path))
+	s.Equal(
+		server.StaticServerConfig{
+			Addr: server.AddrList{":8080"},
+			Dir:  ".",
+			Log:  true,
+		},
+		config)
+}
+
+// An error is returned if the file contains unknown keys.
+func (s *ConfigFileTestSuite) TestLoadFileUnknownKey() {
+	path := s.WriteFile("config.toml", `unknown = "foo"`)
+	var config server.StaticServerConfig
+	err := config.LoadFile(path)
+	s.NotNil(err)
+	s.Contains(err.Error(), `unknown field "unknown"`)
+}
+
+// An error is returned if a key has a value of the wrong type.
+func (s *ConfigFileTestSuite) TestLoadFileInvalidType() {
+	path := s.WriteFile("config.yaml", `disable-h2: "foo"`)
+	var config server.StaticServerConfig
+	err := config.LoadFile(path)
+	s.NotNil(err)
+	s.Contains(err.Error(), "disable-h2")
+}
+
+// An error is returned if the file is not valid.
+func (s *ConfigFileTestSuite) TestLoadFileInvalidContent() {
+	path := s.WriteFile("config.json", `{"addr": `)
+	var config server.StaticServerConfig
+	err := config.LoadFile(path)
+	s.NotNil(err)
+	s.Contains(err.Error(), path)
+}
+
+// An error is returned if the file format is not supported.
+func (s *ConfigFileTestSuite) TestLoadFileUnsupportedFormat() {
+	path := s.WriteFile("config.ini", "")
+	var config server.StaticServerConfig
+	err := config.LoadFile(path)
+	s.NotNil(err)
+	s.Equal("unsupported config file format: "+path, err.Error())
+}
+
+// An error is returned if the file doesn't exist.
+func (s *ConfigFileTestSuite) TestLoadFileNotExists() {
+	var config server.StaticServerConfig
+	err := config.LoadFile(nonExistentPath + ".toml")
+	s.NotNil(err)
+	s.Contains(err.Error(), nonExistentPath)
+}
+
+// Validation errors report the invalid config key.
+func (s *ConfigFileTestSuite) TestValidateErrorKey() {
+	config := server.StaticServerConfig{
+		Dir:          s.TempDir,
+		PasswordFile: nonExistentPath,
+	}
+	err := config.Validate()
+	var configErr *server.ConfigError
+	s.True(errors.As(err, &configErr))
+	s.Equal("basic-auth", configErr.Key)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// etagHashSize is the number of bytes of the SHA-256 content hash used for
+// ETags.
+const etagHashSize = 16
+
+// maxHashedFileSize is the maximum size for files to get an ETag from the
+// hash of their content. Larger files get a weak ETag from their inode, size
+// and modification time, to avoid reading them in full.
+var maxHashedFileSize int64 = 64 << 20
+
+// etagCacheSize is the maximum number of ETags cached for files.
+const etagCacheSize = 4096
+
+// fileETags caches ETags for files, to avoid hashing their content on each
+// request.
+var fileETags = newLRUCache[etagCacheKey, string](etagCacheSize)
+
+// contentETag returns a strong ETag for content read from a reader.
+func contentETag(content io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:etagHashSize]) + `"`, nil
+}
+
+// encodedETag returns the ETag for content encoded with the specified
+// content encoding, since each encoding is a different representation.
+func encodedETag(etag, encoding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// fileETag returns the ETag for a File, based on the hash of its content.
+// Files larger than maxHashedFileSize get a weak ETag based on their details,
+// since it can change even if the content doesn't.
+//
+// ETags are cached by path, inode, size and modification time of the file,
+// so the content is only hashed again if any of them changes.
+func (f FileHandler) fileETag(file *File, filePath string) (string, error) {
+	if file.Info.Size() > maxHashedFileSize {
+		return fmt.Sprintf(
+			`W/"%x-%x-%x"`,
+			fileInode(file.Info), file.Info.Size(), file.Info.ModTime().UnixNano()), nil
+	}
+	key := etagCacheKey{
+		handler: f.id,
+		path:    filePath,
+		inode:   fileInode(file.Info),
+		size:    file.Info.Size(),
+		modTime: file.Info.ModTime().UnixNano(),
+	}
+	if etag, ok := fileETags.Get(key); ok {
+		return etag, nil
+	}
+	content, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+	etag, err := contentETag(content)
+	if err != nil {
+		return "", err
+	}
+	fileETags.Add(key, etag)
+	return etag, nil
+}
+
+// etagCacheKey identifies a file for cached ETags.
+type etagCacheKey struct {
+	handler uint64
+	path    string
+	inode   uint64
+	size    int64
+	modTime int64
+}
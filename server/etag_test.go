@@ -0,0 +1,190 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestETag(t *testing.T) {
+	suite.Run(t, new(ETagTestSuite))
+}
+
+type ETagTestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	handler *server.FileHandler
+}
+
+func (s *ETagTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	s.handler = server.NewFileHandler(
+		server.FileSystem{Root: s.TempDir, ResolveHTML: true, HideDotFiles: true},
+		true, "")
+	s.WriteFile("foo.txt", "foofoofoo")
+	s.Mkdir("dir")
+}
+
+func (s *ETagTestSuite) request(path string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func (s *ETagTestSuite) etag(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// Files are served with a strong ETag from the hash of their content.
+func (s *ETagTestSuite) TestFileETag() {
+	w := s.request("/foo.txt", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal(s.etag("foofoofoo"), w.Header().Get("ETag"))
+}
+
+// Files from an fs.FS are served with ETags.
+func (s *ETagTestSuite) TestFileETagFromFS() {
+	s.handler = server.NewFileHandler(
+		server.FileSystem{FS: fstest.MapFS{"foo.txt": {Data: []byte("from fs")}}},
+		true, "")
+	w := s.request("/foo.txt", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal(s.etag("from fs"), w.Header().Get("ETag"))
+}
+
+// The ETag only depends on the content, not on the modification time.
+func (s *ETagTestSuite) TestFileETagModTime() {
+	etag := s.request("/foo.txt", nil).Header().Get("ETag")
+	modTime := time.Now().Add(time.Hour)
+	s.Nil(os.Chtimes(s.TempDir+"/foo.txt", modTime, modTime))
+	s.Equal(etag, s.request("/foo.txt", nil).Header().Get("ETag"))
+}
+
+// The ETag changes when the content changes.
+func (s *ETagTestSuite) TestFileETagContentChanged() {
+	s.request("/foo.txt", nil)
+	s.WriteFile("foo.txt", "barbarbar")
+	modTime := time.Now().Add(time.Hour)
+	s.Nil(os.Chtimes(s.TempDir+"/foo.txt", modTime, modTime))
+	s.Equal(s.etag("barbarbar"), s.request("/foo.txt", nil).Header().Get("ETag"))
+}
+
+// Files larger than the maximum size for hashing get a weak ETag from their
+// details, which changes with the modification time.
+func (s *ETagTestSuite) TestFileETagLargeFile() {
+	defer server.SetMaxHashedFileSize(5)()
+	etag := s.request("/foo.txt", nil).Header().Get("ETag")
+	s.NotEqual(s.etag("foofoofoo"), etag)
+	s.Regexp(`^W/"[0-9a-f]+-9-[0-9a-f]+"$`, etag)
+	s.Equal(etag, s.request("/foo.txt", nil).Header().Get("ETag"))
+	w := s.request("/foo.txt", map[string]string{"If-None-Match": etag})
+	s.Equal(http.StatusNotModified, w.Code)
+	modTime := time.Now().Add(time.Hour)
+	s.Nil(os.Chtimes(s.TempDir+"/foo.txt", modTime, modTime))
+	s.NotEqual(etag, s.request("/foo.txt", nil).Header().Get("ETag"))
+}
+
+// Requests with a matching If-None-Match get a Not Modified response.
+func (s *ETagTestSuite) TestIfNoneMatch() {
+	etag := s.etag("foofoofoo")
+	w := s.request("/foo.txt", map[string]string{"If-None-Match": etag})
+	s.Equal(http.StatusNotModified, w.Code)
+	s.Equal("", w.Body.String())
+
+	w = s.request("/foo.txt", map[string]string{"If-None-Match": `"other", ` + etag})
+	s.Equal(http.StatusNotModified, w.Code)
+
+	w = s.request("/foo.txt", map[string]string{"If-None-Match": `"other"`})
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("foofoofoo", w.Body.String())
+}
+
+// Requests with a non-matching If-Match get a Precondition Failed response.
+func (s *ETagTestSuite) TestIfMatch() {
+	w := s.request("/foo.txt", map[string]string{"If-Match": s.etag("foofoofoo")})
+	s.Equal(http.StatusOK, w.Code)
+	w = s.request("/foo.txt", map[string]string{"If-Match": `"other"`})
+	s.Equal(http.StatusPreconditionFailed, w.Code)
+}
+
+// Ranges are only served if If-Range matches the ETag.
+func (s *ETagTestSuite) TestIfRange() {
+	w := s.request(
+		"/foo.txt",
+		map[string]string{"Range": "bytes=0-2", "If-Range": s.etag("foofoofoo")})
+	s.Equal(http.StatusPartialContent, w.Code)
+	s.Equal("foo", w.Body.String())
+
+	w = s.request("/foo.txt", map[string]string{"Range": "bytes=0-2", "If-Range": `"other"`})
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("foofoofoo", w.Body.String())
+}
+
+// Precompressed variants have the ETag for their own content.
+func (s *ETagTestSuite) TestPrecompressedETag() {
+	s.WriteFile("foo.txt.gz", "compressed")
+	w := s.request("/foo.txt", map[string]string{"Accept-Encoding": "gzip"})
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal(s.etag("compressed"), w.Header().Get("ETag"))
+	w = s.request("/foo.txt", nil)
+	s.Equal(s.etag("foofoofoo"), w.Header().Get("ETag"))
+}
+
+// Content compressed on the fly has the ETag of the original content,
+// tagged with the encoding.
+func (s *ETagTestSuite) TestCompressedETag() {
+	content := strings.Repeat("compressible content\n", 100)
+	s.WriteFile("page.html", content)
+	s.handler.Compressor = server.NewCompressor(100, 1<<20)
+	w := s.request("/page.html", map[string]string{"Accept-Encoding": "gzip"})
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	etag := strings.TrimSuffix(s.etag(content), `"`) + `-gzip"`
+	s.Equal(etag, w.Header().Get("ETag"))
+
+	w = s.request(
+		"/page.html", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": etag})
+	s.Equal(http.StatusNotModified, w.Code)
+	w = s.request("/page.html", map[string]string{"If-None-Match": etag})
+	s.Equal(http.StatusOK, w.Code)
+}
+
+// Directory listings have an ETag, which changes with their entries.
+func (s *ETagTestSuite) TestListingETag() {
+	etag := s.request("/", nil).Header().Get("ETag")
+	s.NotEqual("", etag)
+	s.Equal(etag, s.request("/", nil).Header().Get("ETag"))
+
+	w := s.request("/", map[string]string{"If-None-Match": etag})
+	s.Equal(http.StatusNotModified, w.Code)
+
+	s.WriteFile("bar.txt", "bar")
+	w = s.request("/", map[string]string{"If-None-Match": etag})
+	s.Equal(http.StatusOK, w.Code)
+	s.NotEqual(etag, w.Header().Get("ETag"))
+	s.Contains(w.Body.String(), `href="bar.txt"`)
+}
+
+// HTML and JSON listings have different ETags.
+func (s *ETagTestSuite) TestListingETagJSON() {
+	w := s.request("/", map[string]string{"Accept": "application/json"})
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("application/json", w.Header().Get("Content-Type"))
+	s.NotEqual("", w.Header().Get("ETag"))
+	s.NotEqual(s.request("/", nil).Header().Get("ETag"), w.Header().Get("ETag"))
+}
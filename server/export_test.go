@@ -1,7 +1,12 @@
 package server
 
 import (
+	"net"
 	"net/http"
+	"os"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
 )
 
 // Export StaticServer.getServer.
@@ -16,3 +21,101 @@ var GetHumanByteSize = getHumanByteSize
 func NewDebugMux() *http.ServeMux {
 	return newDebugMux()
 }
+
+// Export newACMEManager.
+var NewACMEManager = newACMEManager
+
+// SetKeyPairCheckInterval sets the interval for checking certificate
+// changes, returning a function to restore the previous value.
+func SetKeyPairCheckInterval(interval time.Duration) func() {
+	previous := keyPairCheckInterval
+	keyPairCheckInterval = interval
+	return func() { keyPairCheckInterval = previous }
+}
+
+// Export StaticServerConfig.tlsParameters.
+func GetTLSParameters(c StaticServerConfig) (TLSParameters, error) {
+	return c.tlsParameters()
+}
+
+// Export StaticServer.getRedirectHandler.
+func GetRedirectHandler(s *StaticServer) http.Handler {
+	return s.getRedirectHandler()
+}
+
+// Export StaticServer.h3Server.
+func GetH3Server(s *StaticServer) *http3.Server {
+	return s.h3Server
+}
+
+// Export StaticServer.listen.
+func Listen(s *StaticServer, addr string) (net.Listener, error) {
+	return s.listen(addr)
+}
+
+// SetSystemdFDStart sets the first file descriptor for systemd socket
+// activation, returning a function to restore the previous value.
+func SetSystemdFDStart(fd int) func() {
+	previous := systemdFDStart
+	systemdFDStart = fd
+	return func() { systemdFDStart = previous }
+}
+
+// Export acceptedEncodings.
+var AcceptedEncodings = acceptedEncodings
+
+// CompressorCacheSize returns the total size of content cached by a
+// Compressor.
+func CompressorCacheSize(c *Compressor) int64 {
+	return c.cache.Size()
+}
+
+// Export readTarArchiveFS.
+var ReadTarArchiveFS = readTarArchiveFS
+
+// Export newArchiveCache.
+var NewArchiveCache = newArchiveCache
+
+// OpenCachedArchive opens an archive through an archive cache, returning a
+// function to release the reference to it.
+func OpenCachedArchive(c *archiveCache, path string) (*ArchiveFS, func(), error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	refs := &archiveRefs{}
+	archive, err := c.Open(path, info, refs)
+	return archive, refs.release, err
+}
+
+// OpenCachedArchiveNoRefs opens an archive through an archive cache, without
+// adding a reference to it.
+func OpenCachedArchiveNoRefs(c *archiveCache, path string) (*ArchiveFS, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.Open(path, info, nil)
+}
+
+// PurgeArchiveCache releases all archives in an archive cache.
+func PurgeArchiveCache(c *archiveCache) {
+	c.Purge()
+}
+
+// Export ArchiveFS.indexSize.
+func ArchiveIndexSize(a *ArchiveFS) int64 {
+	return a.indexSize()
+}
+
+// SetMaxHashedFileSize sets the maximum size for files to get an ETag from
+// the hash of their content, returning a function to restore the previous
+// value.
+func SetMaxHashedFileSize(size int64) func() {
+	previous := maxHashedFileSize
+	maxHashedFileSize = size
+	return func() { maxHashedFileSize = previous }
+}
+
+// Export isRewritten.
+var IsRewritten = isRewritten
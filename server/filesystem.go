@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	iofs "io/fs"
 	"log"
 	"os"
 	"path"
@@ -15,23 +16,76 @@ import (
 //   - serve .htm(l) files for the corresponding path without suffix, if the
 //     original path is not found
 //   - hide dotfiles
+//   - hide precompressed variants of files (.br, .gz, .zst) from listings,
+//     when the original file is also present
 //   - allow access to file/directories outside the filesystem root via symlinks
+//   - browse the content of archive files, as directories under the archive
+//     path followed by a slash (e.g. "/releases/build.zip/docs/index.html")
+//   - hide Netlify-style _headers and _redirects files, either in the root
+//     or in any directory
+//   - merge content from fallback roots, which serve files not found in the
+//     main root. Directories are merged across roots, and whiteout files
+//     hide content from lower roots: a ".wh.<name>" file hides <name>, and a
+//     ".wh..wh..opq" file hides all content of its directory
+//
+// Files are served from the OS filesystem under Root, unless FS is set. In
+// that case any fs.FS can be used (e.g. an embed.FS or a zip.Reader). Since
+// paths in an fs.FS can't refer to files outside of it, symlink confinement
+// only applies to the OS filesystem. Archives can only be browsed from the
+// OS filesystem as well.
 type FileSystem struct {
 	ResolveHTML          bool
 	HideDotFiles         bool
 	AllowOutsideSymlinks bool
-	Root                 string
+	BrowseArchives       bool
+	HidePrecompressed    bool
+	// Mode for Netlify-style _headers and _redirects files, which are
+	// hidden if set
+	NetlifyFiles string
+	Root         string
+	FS           iofs.FS
+	// Additional roots, in order of decreasing priority
+	Fallbacks []FileSystemRoot
+
+	// cache for browsed archives, a shared one is used if not set
+	archives *archiveCache
+	// if set, references to browsed archives are added to it
+	archiveRefs *archiveRefs
+}
+
+// FileSystemRoot is a root for files in a FileSystem, either a directory in
+// the OS filesystem or an fs.FS.
+type FileSystemRoot struct {
+	Dir string
+	FS  iofs.FS
+}
+
+// fsys returns the fs.FS files are served from.
+func (r FileSystemRoot) fsys() iofs.FS {
+	if r.FS != nil {
+		return r.FS
+	}
+	dir := r.Dir
+	if dir == "" {
+		dir = "."
+	}
+	return os.DirFS(dir)
 }
 
 // Open returns a File object for the specified path under the FileSystem
 // directory.
 func (fs FileSystem) Open(name string) (*File, error) {
-	if fs.HideDotFiles && containsDotFile(name) {
+	if fs.HideDotFiles && containsDotFile(name) || fs.isControlFile(name) {
 		// Even if the file exists, return 404
 		return nil, os.ErrNotExist
 	}
+	if archiveFS, member, err := fs.archiveFileSystem(name); err != nil {
+		return nil, err
+	} else if archiveFS != nil {
+		return archiveFS.Open(member)
+	}
 
-	_, err := fs.open(name)
+	roots, fsName, info, err := fs.lookup(name)
 	if os.IsNotExist(err) && fs.ResolveHTML && !(strings.HasSuffix(name, ".html") || strings.HasSuffix(name, ".htm")) {
 		for _, suffix := range []string{".html", ".htm"} {
 			newName := name + suffix
@@ -43,43 +97,182 @@ func (fs FileSystem) Open(name string) (*File, error) {
 	if err != nil {
 		return nil, err
 	}
-	return fs.newFile(name)
+	return fs.newFile(roots, fsName, info)
 }
 
 // OpenFile returns a File object for the specified path under the FileSystem
 // directory if it esists and it's not a directory.
 func (fs FileSystem) OpenFile(name string) (*File, error) {
-	if file, err := fs.open(name); err == nil {
-		if fileInfo, err := file.Stat(); err == nil && !fileInfo.IsDir() {
-			return fs.newFile(name)
-		}
+	if fs.isControlFile(name) {
+		return nil, os.ErrNotExist
+	}
+	if archiveFS, member, err := fs.archiveFileSystem(name); err != nil {
+		return nil, err
+	} else if archiveFS != nil {
+		return archiveFS.OpenFile(member)
+	}
+	if roots, fsName, info, err := fs.lookup(name); err == nil && !info.IsDir() {
+		return fs.newFile(roots, fsName, info)
 	}
 	return nil, os.ErrNotExist
 }
 
-func (fs FileSystem) open(name string) (*os.File, error) {
-	if filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator) {
-		return nil, fmt.Errorf("invalid character in file path: %s", name)
+// archiveFileSystem returns a FileSystem for the content of the archive
+// containing the specified path, along with the path in the archive. If
+// archives can't be browsed or the path is not in an archive, a nil
+// FileSystem is returned.
+//
+// A path ending with a slash after the archive name refers to the root of
+// the archive, otherwise to the archive file itself.
+func (fs FileSystem) archiveFileSystem(name string) (*FileSystem, string, error) {
+	if !fs.BrowseArchives {
+		return nil, "", nil
+	}
+	trailingSlash := strings.HasSuffix(name, "/")
+	parts := strings.Split(strings.TrimPrefix(path.Clean("/"+name), "/"), "/")
+	for i, part := range parts {
+		if !IsArchive(part) {
+			continue
+		}
+		member := strings.Join(parts[i+1:], "/")
+		if member == "" && !trailingSlash {
+			break
+		}
+		roots, archiveName, info, err := fs.lookup(strings.Join(parts[:i+1], "/"))
+		if err != nil || !info.Mode().IsRegular() {
+			// not an archive file (e.g. a directory with an archive suffix)
+			continue
+		}
+		// the archive is subject to symlinks confinement as other files
+		file, err := fs.newFile(roots, archiveName, info)
+		if err != nil {
+			return nil, "", err
+		}
+		if file.AbsPath() == "" {
+			// not from the OS filesystem
+			continue
+		}
+		archives := fs.archives
+		if archives == nil {
+			archives = defaultArchiveCache
+		}
+		archive, err := archives.Open(file.AbsPath(), file.Info, fs.archiveRefs)
+		if err != nil {
+			return nil, "", err
+		}
+		archiveFS := &FileSystem{
+			ResolveHTML:       fs.ResolveHTML,
+			HideDotFiles:      fs.HideDotFiles,
+			HidePrecompressed: fs.HidePrecompressed,
+			FS:                archive,
+		}
+		return archiveFS, "/" + member, nil
+	}
+	return nil, "", nil
+}
+
+// isControlFile returns whether a path refers to a Netlify-style control
+// file, which is not served.
+func (fs FileSystem) isControlFile(name string) bool {
+	return isControlFile(fs.NetlifyFiles, name)
+}
+
+// isControlFile returns whether a path refers to a Netlify-style control
+// file for the specified mode.
+func isControlFile(mode, name string) bool {
+	if mode == "" {
+		return false
 	}
-	fullName := filepath.Join(fs.Root, filepath.FromSlash(path.Clean("/"+name)))
-	return os.Open(fullName)
+	name = path.Clean("/" + name)
+	if base := path.Base(name); base != headersFile && base != redirectsFile {
+		return false
+	}
+	return mode == NetlifyFilesDirs || path.Dir(name) == "/"
 }
 
-func (fs FileSystem) newFile(name string) (*File, error) {
-	path, err := fs.resolvePath(filepath.Join(fs.Root, name))
+// openControlFile returns a File for a Netlify-style control file.
+func (fs FileSystem) openControlFile(name string) (*File, error) {
+	roots, fsName, info, err := fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, os.ErrNotExist
+	}
+	return fs.newFile(roots, fsName, info)
+}
+
+// roots returns all roots for the FileSystem, in order of priority.
+func (fs FileSystem) roots() []FileSystemRoot {
+	return append([]FileSystemRoot{{Dir: fs.Root, FS: fs.FS}}, fs.Fallbacks...)
+}
+
+// fsName returns the name in the fs.FS for a slash-separated path.
+func (fs FileSystem) fsName(name string) (string, error) {
+	if fs.FS == nil && filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator) {
+		return "", fmt.Errorf("invalid character in file path: %s", name)
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, nil
+}
+
+// lookup returns the roots containing the specified path, starting from the
+// one the file is served from, along with the name in the roots and the file
+// info.
+//
+// The file is opened to check that it's accessible.
+func (fs FileSystem) lookup(name string) ([]FileSystemRoot, string, iofs.FileInfo, error) {
+	fsName, err := fs.fsName(name)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	roots, info, err := resolveRoots(fs.roots(), fsName)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	file, err := roots[0].fsys().Open(fsName)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	file.Close()
+	return roots, fsName, info, nil
+}
+
+// newFile returns a File for a name in the specified roots, checking that
+// it's not a symlink outside of the root, unless allowed.
+func (fs FileSystem) newFile(roots []FileSystemRoot, fsName string, info iofs.FileInfo) (*File, error) {
+	file := &File{
+		Info:              info,
+		roots:             roots,
+		name:              fsName,
+		hideDotFiles:      fs.HideDotFiles,
+		hidePrecompressed: fs.HidePrecompressed,
+		netlifyFiles:      fs.NetlifyFiles,
+		browseArchives:    fs.BrowseArchives,
+	}
+	root := roots[0]
+	if root.FS != nil {
+		return file, nil
+	}
+
+	path, err := fs.resolvePath(filepath.Join(root.Dir, filepath.FromSlash(fsName)))
 	if err != nil {
 		return nil, err
 	}
 	if !fs.AllowOutsideSymlinks {
-		root, err := fs.resolvePath(fs.Root)
+		rootPath, err := fs.resolvePath(root.Dir)
 		if err != nil {
 			return nil, err
 		}
-		if !strings.HasPrefix(path, root) {
+		if !strings.HasPrefix(path, rootPath) {
 			return nil, os.ErrPermission
 		}
 	}
-	return NewFile(path, fs.HideDotFiles)
+	file.absPath = path
+	return file, nil
 }
 
 func (fs FileSystem) resolvePath(path string) (string, error) {
@@ -97,12 +290,19 @@ func (fs FileSystem) resolvePath(path string) (string, error) {
 // File is an entry of a  FileSystem entry.
 //
 // If the entry is a directory and the filesystem is configured to hide
-// dotfiles, the directory will also not list dotfiles under it.
+// dotfiles or precompressed files, the directory will also not list them.
 type File struct {
 	Info os.FileInfo
 
-	absPath      string
-	hideDotFiles bool
+	// roots containing the file, starting from the one it's served from.
+	// For directories, content from all roots is listed.
+	roots             []FileSystemRoot
+	name              string
+	absPath           string
+	hideDotFiles      bool
+	hidePrecompressed bool
+	netlifyFiles      string
+	browseArchives    bool
 }
 
 // NewFile returns a File for an absolute path.
@@ -113,58 +313,91 @@ func NewFile(absPath string, hideDotFiles bool) (*File, error) {
 	}
 	return &File{
 		Info:         info,
+		roots:        []FileSystemRoot{{Dir: absPath}},
+		name:         ".",
 		absPath:      absPath,
 		hideDotFiles: hideDotFiles,
 	}, nil
 }
 
-// AbsPath returns the absolute path of the File.
+// AbsPath returns the absolute path of the File, or an empty string if the
+// File is not from the OS filesystem.
 func (f File) AbsPath() string {
 	return f.absPath
 }
 
+// IsArchive returns whether the File is an archive whose content can be
+// browsed.
+func (f File) IsArchive() bool {
+	return f.browseArchives && f.absPath != "" && f.Info.Mode().IsRegular() && IsArchive(f.Info.Name())
+}
+
+// Open opens the File for reading.
+func (f File) Open() (iofs.File, error) {
+	return f.roots[0].fsys().Open(f.name)
+}
+
 // Readdir files in the directory, excluding special files and optionally
-// hidden files (that start with a dot).
+// hidden files (that start with a dot) and precompressed variants of other
+// files.
 func (f File) Readdir() ([]*File, error) {
-	file, err := os.Open(f.absPath)
+	names, err := readDirNames(f.roots, f.name)
 	if err != nil {
 		return nil, err
 	}
-	// don't use Readdir to get the FileInfo since it doesn't resolve
-	// symlinks. We want the FileInfo to be the one of the symlink target
-	// (hence the use of os.Stat below).
-	names, err := file.Readdirnames(-1)
-	if err != nil {
-		return nil, err
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[name] = true
 	}
 	files := make([]*File, 0, len(names))
 	for _, name := range names {
-		info, err := os.Stat(filepath.Join(f.absPath, name))
-		if err != nil {
-			log.Printf("%v", err)
+		if f.hideDotFiles && strings.HasPrefix(name, ".") {
 			continue
 		}
-		name := info.Name()
-		if f.hideDotFiles && strings.HasPrefix(name, ".") {
+		if f.hidePrecompressed && present[precompressedOriginal(name)] {
 			continue
 		}
-		mode := info.Mode()
-		if !(mode.IsDir() || mode.IsRegular()) {
+		if isControlFile(f.netlifyFiles, path.Join(f.name, name)) {
 			continue
 		}
+		// don't use the FileInfo from the entry since it doesn't resolve
+		// symlinks. We want the FileInfo to be the one of the symlink
+		// target.
 		file, err := f.newFile(name)
 		if err != nil {
 			log.Printf("%v", err)
 			continue
 		}
+		mode := file.Info.Mode()
+		if !(mode.IsDir() || mode.IsRegular()) {
+			continue
+		}
 		files = append(files, file)
 	}
 	return files, nil
 }
 
 func (f File) newFile(name string) (*File, error) {
-	absPath := filepath.Join(f.AbsPath(), name)
-	return NewFile(absPath, f.hideDotFiles)
+	roots, info, err := resolveChild(f.roots, f.name, name)
+	if err != nil {
+		return nil, err
+	}
+	fsName := path.Join(f.name, name)
+	file := &File{
+		Info:              info,
+		roots:             roots,
+		name:              fsName,
+		hideDotFiles:      f.hideDotFiles,
+		hidePrecompressed: f.hidePrecompressed,
+		netlifyFiles:      f.netlifyFiles,
+		browseArchives:    f.browseArchives,
+	}
+	if root := roots[0]; root.FS == nil {
+		if file.absPath, err = filepath.Abs(filepath.Join(root.Dir, filepath.FromSlash(fsName))); err != nil {
+			return nil, err
+		}
+	}
+	return file, nil
 }
 
 // containsDotFile reports whether name contains a path element starting with a
@@ -3,8 +3,10 @@ package server_test
 import (
 	"io"
 	"os"
+	"path/filepath"
 	"sort"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/suite"
 
@@ -32,14 +34,23 @@ func (s *FileSystemTestSuite) SetupTest() {
 }
 
 func (s *FileSystemTestSuite) readFile(file *server.File) string {
-	f, err := os.Open(file.AbsPath())
+	return readFile(&s.Suite, file)
+}
+
+func (s *FileSystemTestSuite) fileList(file *server.File) []string {
+	return fileList(&s.Suite, file)
+}
+
+func readFile(s *suite.Suite, file *server.File) string {
+	f, err := file.Open()
 	s.Nil(err)
+	defer f.Close()
 	content, err := io.ReadAll(f)
 	s.Nil(err)
 	return string(content)
 }
 
-func (s *FileSystemTestSuite) fileList(file *server.File) (names []string) {
+func fileList(s *suite.Suite, file *server.File) (names []string) {
 	files, err := file.Readdir()
 	s.Nil(err)
 	for _, file := range files {
@@ -219,6 +230,118 @@ func (s *FileSystemTestSuite) TestOpenFileForDirectory() {
 	s.IsType(os.ErrNotExist, err)
 }
 
+// The AbsPath of files from the OS filesystem is set.
+func (s *FileSystemTestSuite) TestAbsPath() {
+	s.WriteFile("foo", "bar")
+	file, err := s.fs.Open("/foo")
+	s.Nil(err)
+	s.Equal(filepath.Join(s.TempDir, "foo"), file.AbsPath())
+}
+
+func TestFSFileSystem(t *testing.T) {
+	suite.Run(t, new(FSFileSystemTestSuite))
+}
+
+type FSFileSystemTestSuite struct {
+	suite.Suite
+
+	fs server.FileSystem
+}
+
+func (s *FSFileSystemTestSuite) SetupTest() {
+	s.fs = server.FileSystem{
+		FS: fstest.MapFS{
+			"foo":          {Data: []byte("foo content")},
+			"page.html":    {Data: []byte("page content")},
+			".hidden":      {Data: []byte("hidden content")},
+			"dir/bar":      {Data: []byte("bar content")},
+			"dir/.baz":     {Data: []byte("baz content")},
+			"dir/sub/file": {Data: []byte("file content")},
+		},
+		ResolveHTML:  true,
+		HideDotFiles: true,
+	}
+}
+
+// Files are read from the fs.FS.
+func (s *FSFileSystemTestSuite) TestOpen() {
+	file, err := s.fs.Open("/dir/bar")
+	s.Nil(err)
+	s.Equal("bar", file.Info.Name())
+	s.Equal("bar content", readFile(&s.Suite, file))
+	// not an OS file
+	s.Equal("", file.AbsPath())
+}
+
+// Paths are relative to the root of the fs.FS.
+func (s *FSFileSystemTestSuite) TestOpenOutsideRoot() {
+	s.fs.HideDotFiles = false
+	file, err := s.fs.Open("/../../dir/bar")
+	s.Nil(err)
+	s.Equal("bar content", readFile(&s.Suite, file))
+}
+
+// An error is returned if the file doesn't exist.
+func (s *FSFileSystemTestSuite) TestOpenNotFound() {
+	file, err := s.fs.Open("/not-here")
+	s.True(os.IsNotExist(err))
+	s.Nil(file)
+}
+
+// The file with .html suffix is returned if present.
+func (s *FSFileSystemTestSuite) TestLookupWithHTMLSuffix() {
+	file, err := s.fs.Open("/page")
+	s.Nil(err)
+	s.Equal("page content", readFile(&s.Suite, file))
+}
+
+// Dotfiles can be hidden.
+func (s *FSFileSystemTestSuite) TestHideDotFiles() {
+	file, err := s.fs.Open("/.hidden")
+	s.IsType(os.ErrNotExist, err)
+	s.Nil(file)
+}
+
+// The root directory can be listed.
+func (s *FSFileSystemTestSuite) TestListingRoot() {
+	file, err := s.fs.Open("/")
+	s.Nil(err)
+	s.True(file.Info.IsDir())
+	s.Equal([]string{"dir", "foo", "page.html"}, fileList(&s.Suite, file))
+}
+
+// Dotfiles can be hidden from listing.
+func (s *FSFileSystemTestSuite) TestListingHideDotFiles() {
+	file, err := s.fs.Open("/dir")
+	s.Nil(err)
+	s.Equal([]string{"bar", "sub"}, fileList(&s.Suite, file))
+}
+
+// Dotfiles can be included in listing.
+func (s *FSFileSystemTestSuite) TestListingShowDotFiles() {
+	s.fs.HideDotFiles = false
+	file, err := s.fs.Open("/dir")
+	s.Nil(err)
+	s.Equal([]string{".baz", "bar", "sub"}, fileList(&s.Suite, file))
+}
+
+// Entries in listing can be read.
+func (s *FSFileSystemTestSuite) TestListingReadEntry() {
+	file, err := s.fs.Open("/dir/sub")
+	s.Nil(err)
+	files, err := file.Readdir()
+	s.Nil(err)
+	s.Len(files, 1)
+	s.Equal("file content", readFile(&s.Suite, files[0]))
+}
+
+// OpenFile errors if the File is a directory.
+func (s *FSFileSystemTestSuite) TestOpenFileForDirectory() {
+	file, err := s.fs.OpenFile("/dir")
+	s.Nil(file)
+	s.IsType(os.ErrNotExist, err)
+}
+
 func TestFile(t *testing.T) {
 	suite.Run(t, new(FileTestSuite))
 }
@@ -1,16 +1,24 @@
 package server
 
 import (
+	"bytes"
 	"crypto/sha512"
+	"crypto/x509"
 	"embed"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
 )
 
 // FileHandler is an http.Handler which serves static files under the specified
@@ -18,17 +26,37 @@ import (
 type FileHandler struct {
 	FileSystem     FileSystem
 	DirectoryIndex bool
-	pathPrefix     string
-	template       *DirectoryListingTemplate
+	// If set, responses are compressed on the fly for files without
+	// precompressed variants and for directory listings
+	Compressor  *Compressor
+	id          uint64
+	pathPrefix  string
+	mountPrefix string
+	template    *DirectoryListingTemplate
 }
 
 // NewFileHandler returns a FileHandler for the specified filesystem.
 func NewFileHandler(fileSystem FileSystem, directoryIndex bool, pathPrefix string) *FileHandler {
+	return NewMountFileHandler(fileSystem, directoryIndex, pathPrefix, "")
+}
+
+// NewMountFileHandler returns a FileHandler for the specified filesystem,
+// mounted under a URL path prefix.
+//
+// The mount prefix is expected to be stripped from requests before they're
+// passed to the handler.
+func NewMountFileHandler(fileSystem FileSystem, directoryIndex bool, pathPrefix, mountPrefix string) *FileHandler {
 	return &FileHandler{
 		FileSystem:     fileSystem,
 		DirectoryIndex: directoryIndex,
+		id:             handlerIDs.Add(1),
 		pathPrefix:     pathPrefix,
-		template:       NewDirectoryListingTemplate(DirectoryListingTemplateConfig{PathPrefix: pathPrefix}),
+		mountPrefix:    mountPrefix,
+		template: NewDirectoryListingTemplate(
+			DirectoryListingTemplateConfig{
+				PathPrefix:  pathPrefix,
+				MountPrefix: mountPrefix,
+			}),
 	}
 }
 
@@ -39,13 +67,38 @@ func (f FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if f.FileSystem.BrowseArchives {
+		// keep browsed archives open until the request is served
+		f.FileSystem.archiveRefs = &archiveRefs{}
+		defer f.FileSystem.archiveRefs.release()
+	}
 	urlPath := r.URL.Path
 	if !strings.HasPrefix(urlPath, "/") {
 		urlPath = "/" + urlPath
 		r.URL.Path = urlPath
 	}
+	// whether the path is the target of a rewrite, rather than the requested one
+	rewritten := isRewritten(r)
+	// the path headers from _headers files are matched against
+	requestedPath := cleanURLPath(urlPath)
+	if f.FileSystem.NetlifyFiles != "" {
+		written, rewritePath := f.applyNetlifyRules(w, r, requestedPath)
+		if written {
+			return
+		}
+		if rewritePath != "" {
+			// serve the content for the target path at the requested URL
+			urlPath, _, _ = strings.Cut(rewritePath, "?")
+			rewritten = true
+		}
+	}
 	basePath := path.Clean(urlPath)
-	file, err := f.FileSystem.Open(basePath)
+	name := basePath
+	if strings.HasSuffix(urlPath, "/") && IsArchive(basePath) {
+		// refer to the archive content rather than the archive file
+		name += "/"
+	}
+	file, err := f.FileSystem.Open(name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			writeHTTPError(w, http.StatusNotFound)
@@ -56,11 +109,11 @@ func (f FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	fullPath := file.AbsPath()
+	filePath := path.Join(path.Dir(basePath), file.Info.Name())
 	if file.Info.IsDir() {
-		if !strings.HasSuffix(urlPath, "/") {
+		if !strings.HasSuffix(urlPath, "/") && !rewritten {
 			// always redirect to URL with trailing slash for directories
-			localRedirect(w, r, f.pathPrefix+urlPath+"/")
+			localRedirect(w, r, f.pathPrefix+f.mountPrefix+urlPath+"/")
 			return
 		}
 		// if found, append the index suffix
@@ -73,17 +126,82 @@ func (f FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// list directory content
-			file, err := f.FileSystem.Open(basePath)
-			if err != nil {
-				writeServerError(w, err)
-				return
-			}
+			f.addNetlifyHeaders(w, requestedPath)
 			f.writeDirListing(w, r, basePath, file)
 			return
 		}
-		fullPath += indexPath
+		filePath = basePath + indexPath
+		if file, err = f.FileSystem.OpenFile(filePath); err != nil {
+			writeServerError(w, err)
+			return
+		}
+	} else if rewritten {
+		// serve the target file regardless of the requested URL
+	} else if strings.HasSuffix(urlPath, "/index.html") {
+		// redirect to the directory, as http.ServeFile does
+		localRedirect(w, r, "./")
+		return
+	} else if strings.HasSuffix(urlPath, "/") {
+		// redirect to the URL without trailing slash for files
+		localRedirect(w, r, "../"+path.Base(urlPath))
+		return
+	}
+	f.addNetlifyHeaders(w, requestedPath)
+	header := w.Header()
+	if variant, encoding := f.findPrecompressed(w, r, filePath); variant != nil {
+		// the content type is the one of the original file
+		ctype, err := contentType(file)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		header.Set("Content-Encoding", encoding)
+		header.Set("Content-Type", ctype)
+		file = variant
+		filePath = path.Join(path.Dir(filePath), variant.Info.Name())
+	}
+	etag, err := f.fileETag(file, filePath)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	header.Set("ETag", etag)
+	if header.Get("Content-Encoding") == "" && f.Compressor != nil {
+		served, err := f.Compressor.serveFile(w, r, file, f.id, filePath)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		if served {
+			return
+		}
 	}
-	http.ServeFile(w, r, fullPath)
+	serveFile(w, r, file)
+}
+
+// serveFile serves the content of a File.
+func serveFile(w http.ResponseWriter, r *http.Request, file *File) {
+	content, err := file.Open()
+	if err != nil {
+		if os.IsPermission(err) {
+			writeHTTPError(w, http.StatusForbidden)
+		} else {
+			writeServerError(w, err)
+		}
+		return
+	}
+	defer content.Close()
+	reader, ok := content.(io.ReadSeeker)
+	if !ok {
+		// content must be seekable to serve ranges, read it in memory
+		data, err := io.ReadAll(content)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		reader = bytes.NewReader(data)
+	}
+	http.ServeContent(w, r, file.Info.Name(), file.Info.ModTime(), reader)
 }
 
 // Check if an index file exists for the directory, return its suffix.
@@ -106,16 +224,48 @@ func (f FileHandler) writeDirListing(w http.ResponseWriter, r *http.Request, pat
 		sortColumn = "n"
 	}
 	sortAsc := q.Get("o") != "d"
+	// render the whole listing first, to derive the ETag from its content
+	lw := &listingWriter{ResponseWriter: w}
 	var err error
 	if strings.ToLower(r.Header.Get("Accept")) == "application/json" {
-		err = f.template.RenderJSON(w, path, dir, sortColumn, sortAsc)
+		err = f.template.RenderJSON(lw, path, dir, sortColumn, sortAsc)
 	} else {
-		err = f.template.RenderHTML(w, path, dir, sortColumn, sortAsc)
+		err = f.template.RenderHTML(lw, path, dir, sortColumn, sortAsc)
 	}
 	if err != nil {
 		writeServerError(w, err)
 		return
 	}
+	data := lw.buf.Bytes()
+	etag, err := contentETag(bytes.NewReader(data))
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	if f.Compressor != nil {
+		var encoding string
+		if data, encoding, err = f.Compressor.compressContent(w, r, data); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		if encoding != "" {
+			etag = encodedETag(etag, encoding)
+		}
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// listingWriter buffers a directory listing, writing headers to the
+// response.
+type listingWriter struct {
+	http.ResponseWriter
+
+	buf bytes.Buffer
+}
+
+func (w *listingWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
 }
 
 // LoggingHandler wraps an http.Handler providing logging at startup.
@@ -146,14 +296,21 @@ func (w *loggingResponseWriter) Write(b []byte) (n int, err error) {
 }
 
 // ServeHTTP logs server startup and serves via the configured handler.
+//
+// If the request has a verified TLS client certificate, its subject is also
+// logged.
 func (h LoggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	wr := newLoggingResponseWriter(w)
 	h.Handler.ServeHTTP(wr, r)
 	remoteAddr := h.getRemoteAddr(r)
+	clientCert := ""
+	if cert := verifiedClientCert(r); cert != nil {
+		clientCert = fmt.Sprintf(` client="%s"`, cert.Subject)
+	}
 	log.Printf(
-		`%s %s %s %d %d %d %s "%s"`,
+		`%s %s %s %d %d %d %s "%s"%s`,
 		r.Proto, r.Method, r.URL, r.ContentLength, wr.statusCode, wr.length, remoteAddr,
-		r.Header.Get("User-Agent"))
+		r.Header.Get("User-Agent"), clientCert)
 }
 
 func (h LoggingHandler) getRemoteAddr(r *http.Request) string {
@@ -204,6 +361,168 @@ func (h BasicAuthHandler) hashPassword(password string) string {
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
+// ClientCertHandler restricts access to paths based on the subject of the
+// verified TLS client certificate.
+type ClientCertHandler struct {
+	http.Handler
+
+	// Allowed path prefixes by certificate subject. Subjects can be either
+	// distinguished names (e.g. "CN=client,O=Example") or common names.
+	Access map[string][]string
+}
+
+// ServeHTTP serves the request if the client certificate is allowed access
+// to the path, otherwise it returns a Forbidden response.
+func (h ClientCertHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cert := verifiedClientCert(r)
+	if cert == nil || !h.isAllowed(cert, r.URL.Path) {
+		writeHTTPError(w, http.StatusForbidden)
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+func (h ClientCertHandler) isAllowed(cert *x509.Certificate, urlPath string) bool {
+	urlPath = path.Clean("/" + urlPath)
+	for _, subject := range []string{cert.Subject.String(), cert.Subject.CommonName} {
+		for _, prefix := range h.Access[subject] {
+			prefix = strings.TrimSuffix(prefix, "/")
+			if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifiedClientCert returns the verified TLS client certificate for a
+// request, or nil if there's none.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// HTTPSRedirectHandler redirects requests to the same URL with HTTPS scheme.
+type HTTPSRedirectHandler struct {
+	// Port for the HTTPS server. It's omitted from URLs if 0 or 443.
+	Port uint16
+	// The status code for redirects. If not set, 301 (Moved Permanently)
+	// is used.
+	StatusCode int
+	// Only redirect requests under this path prefix, if set.
+	PathPrefix string
+}
+
+// ServeHTTP redirects the request to the HTTPS URL, preserving path and
+// query.
+func (h HTTPSRedirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.PathPrefix != "" && !h.underPathPrefix(r.URL.Path) {
+		writeHTTPError(w, http.StatusNotFound)
+		return
+	}
+	host := requestHostName(r)
+	if host == "" {
+		writeHTTPError(w, http.StatusBadRequest)
+		return
+	}
+	if h.Port != 0 && h.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(int(h.Port)))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	url := "https://" + host + r.URL.RequestURI()
+	statusCode := h.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, url, statusCode)
+}
+
+// underPathPrefix returns whether a path is the path prefix or under it.
+func (h HTTPSRedirectHandler) underPathPrefix(urlPath string) bool {
+	prefix := strings.TrimSuffix(h.PathPrefix, "/")
+	return urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
+
+// AltSvcHandler adds the Alt-Svc header to responses, advertising an HTTP/3
+// server.
+type AltSvcHandler struct {
+	http.Handler
+
+	Server *http3.Server
+}
+
+// ServeHTTP adds the Alt-Svc header and calls the wrapped handler.
+func (h *AltSvcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the header is only set once the server is listening
+	h.Server.SetQUICHeaders(w.Header())
+	h.Handler.ServeHTTP(w, r)
+}
+
+// HSTSHeader returns the value for the Strict-Transport-Security header.
+func HSTSHeader(maxAge int, includeSubDomains, preload bool) string {
+	value := fmt.Sprintf("max-age=%d", maxAge)
+	if includeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// VirtualHostHandler dispatches requests to different handlers based on the
+// request host name.
+type VirtualHostHandler struct {
+	// Handlers by lowercase host name. Names starting with "*." match any
+	// subdomain of the rest of the name.
+	Hosts map[string]http.Handler
+	// Handler for requests not matching any host
+	Default http.Handler
+}
+
+// ServeHTTP serves the request via the handler matching its host.
+func (h VirtualHostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler := h.findHandler(requestHostName(r))
+	if handler == nil {
+		writeHTTPError(w, http.StatusNotFound)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+func (h VirtualHostHandler) findHandler(name string) http.Handler {
+	if handler, ok := h.Hosts[name]; ok {
+		return handler
+	}
+	// look for wildcard matches, from the most specific
+	for {
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+		if handler, ok := h.Hosts["*."+name]; ok {
+			return handler
+		}
+	}
+	return h.Default
+}
+
+// requestHostName returns the lowercase host name for a request, without
+// port.
+func requestHostName(r *http.Request) string {
+	host := r.Host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
 // AddHeadersHandler wraps an http.Handler adding headers.
 func AddHeadersHandler(headers map[string]string, h http.Handler) http.Handler {
 	return http.HandlerFunc(
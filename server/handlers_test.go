@@ -1,11 +1,15 @@
 package server_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/suite"
 
@@ -130,6 +134,18 @@ func (s *FileHandlerTestSuite) TestDirectoryRedirectWithTrailingSlashPathPrefix(
 	s.Equal("/prefix/baz/", response.Header.Get("Location"))
 }
 
+// URLs for directories without trailing shash are redirected to the URL with
+// slash, including the mount prefix.
+func (s *FileHandlerTestSuite) TestDirectoryRedirectWithTrailingSlashMountPrefix() {
+	handler := server.NewMountFileHandler(s.fileSystem, false, "/prefix", "/mount")
+	r := httptest.NewRequest("GET", "/baz", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal(http.StatusMovedPermanently, response.StatusCode)
+	s.Equal("/prefix/mount/baz/", response.Header.Get("Location"))
+}
+
 // If a directory has an index.html file, it's served instead of listing.
 func (s *FileHandlerTestSuite) TestServeDirectoryIndexHTML() {
 	s.WriteFile("index.html", "some content")
@@ -252,6 +268,98 @@ func (s *FileHandlerTestSuite) TestListingJSONSortSize() {
 	)
 }
 
+// Files are served with support for ranges.
+func (s *FileHandlerTestSuite) TestServeFileRange() {
+	r := httptest.NewRequest("GET", "/foo", nil)
+	r.Header.Set("Range", "bytes=3-5")
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal(http.StatusPartialContent, response.StatusCode)
+	s.Equal("bytes 3-5/9", response.Header.Get("Content-Range"))
+	s.Equal("foo", w.Body.String())
+}
+
+// Requests for index.html files are redirected to the directory.
+func (s *FileHandlerTestSuite) TestIndexHTMLRedirect() {
+	s.WriteFile("baz/index.html", "some content")
+	r := httptest.NewRequest("GET", "/baz/index.html", nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal(http.StatusMovedPermanently, response.StatusCode)
+	s.Equal("./", response.Header.Get("Location"))
+}
+
+// URLs for files with trailing slash are redirected to the URL without slash.
+func (s *FileHandlerTestSuite) TestFileRedirectWithoutTrailingSlash() {
+	r := httptest.NewRequest("GET", "/foo/", nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal(http.StatusMovedPermanently, response.StatusCode)
+	s.Equal("../foo", response.Header.Get("Location"))
+}
+
+// Files can be served from an fs.FS.
+func (s *FileHandlerTestSuite) TestServeFromFS() {
+	fileSystem := server.FileSystem{
+		FS: fstest.MapFS{
+			"docs/index.html": {Data: []byte("docs index")},
+			"style.css":       {Data: []byte("body {}")},
+		},
+	}
+	handler := server.NewFileHandler(fileSystem, true, "")
+
+	r := httptest.NewRequest("GET", "/style.css", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal("text/css; charset=utf-8", response.Header.Get("Content-Type"))
+	s.Equal("body {}", w.Body.String())
+
+	r = httptest.NewRequest("GET", "/docs/", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusOK, w.Result().StatusCode)
+	s.Equal("docs index", w.Body.String())
+}
+
+// Directories from an fs.FS can be listed.
+func (s *FileHandlerTestSuite) TestListingFromFS() {
+	fileSystem := server.FileSystem{
+		FS: fstest.MapFS{
+			"dir/foo": {Data: []byte("foo")},
+			"bar":     {Data: []byte("barbar")},
+		},
+	}
+	handler := server.NewFileHandler(fileSystem, true, "")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusOK, w.Result().StatusCode)
+	var content server.DirInfo
+	s.Nil(json.NewDecoder(w.Body).Decode(&content))
+	s.Equal(
+		[]server.DirEntryInfo{
+			{Name: "bar", Size: 6},
+			{Name: "dir", IsDir: true},
+		},
+		content.Entries,
+	)
+}
+
+// A file not found in an fs.FS returns 404.
+func (s *FileHandlerTestSuite) TestNotFoundFromFS() {
+	handler := server.NewFileHandler(server.FileSystem{FS: fstest.MapFS{}}, true, "")
+	r := httptest.NewRequest("GET", "/foo", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusNotFound, w.Result().StatusCode)
+}
+
 func TestBasicAuthHandler(t *testing.T) {
 	suite.Run(t, new(BasicAuthHandlerTestSuite))
 }
@@ -381,6 +489,17 @@ func (s *LoggingHandlerTestSuite) TestLogRequest() {
 	s.Equal(http.StatusNotFound, response.StatusCode)
 }
 
+// Requests are logged with the TLS client certificate subject.
+func (s *LoggingHandlerTestSuite) TestLogRequestWithClientCert() {
+	ca := testhelpers.NewCertificateAuthority("test CA")
+	cert := ca.IssueClientCertificate(pkix.Name{CommonName: "client", Organization: []string{"Example"}})
+	r := httptest.NewRequest("GET", "/path", nil)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert.Leaf}}}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	s.Contains(s.Logs.String(), `client="CN=client,O=Example"`)
+}
+
 // Requests are logged with the original request IP.
 func (s *LoggingHandlerTestSuite) TestLogRequestWithForward() {
 	r := httptest.NewRequest("GET", "/path", nil)
@@ -393,3 +512,203 @@ func (s *LoggingHandlerTestSuite) TestLogRequestWithForward() {
 	response := w.Result()
 	s.Equal(http.StatusNotFound, response.StatusCode)
 }
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	suite.Run(t, new(HTTPSRedirectHandlerTestSuite))
+}
+
+type HTTPSRedirectHandlerTestSuite struct {
+	suite.Suite
+}
+
+// Requests are redirected to HTTPS, preserving path and query.
+func (s *HTTPSRedirectHandlerTestSuite) TestRedirect() {
+	handler := server.HTTPSRedirectHandler{Port: 443}
+	r := httptest.NewRequest("GET", "http://example.com:8080/foo/bar?baz=1", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal(http.StatusMovedPermanently, response.StatusCode)
+	s.Equal("https://example.com/foo/bar?baz=1", response.Header.Get("Location"))
+}
+
+// The HTTPS port is included in the URL if it's not the default one.
+func (s *HTTPSRedirectHandlerTestSuite) TestRedirectWithPort() {
+	handler := server.HTTPSRedirectHandler{Port: 8443}
+	r := httptest.NewRequest("GET", "http://[::1]:8080/foo", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal("https://[::1]:8443/foo", response.Header.Get("Location"))
+}
+
+// The status code for redirects can be specified.
+func (s *HTTPSRedirectHandlerTestSuite) TestRedirectStatusCode() {
+	handler := server.HTTPSRedirectHandler{StatusCode: http.StatusPermanentRedirect}
+	r := httptest.NewRequest("POST", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal(http.StatusPermanentRedirect, response.StatusCode)
+	s.Equal("https://example.com/foo", response.Header.Get("Location"))
+}
+
+// If a path prefix is set, requests outside of it are not redirected.
+func (s *HTTPSRedirectHandlerTestSuite) TestRedirectPathPrefix() {
+	handler := server.HTTPSRedirectHandler{PathPrefix: "/prefix"}
+	r := httptest.NewRequest("GET", "http://example.com/prefix/foo", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal("https://example.com/prefix/foo", w.Result().Header.Get("Location"))
+
+	r = httptest.NewRequest("GET", "http://example.com/other/foo", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusNotFound, w.Result().StatusCode)
+}
+
+// Paths sharing the path prefix as a string but not as a directory are not
+// redirected.
+func (s *HTTPSRedirectHandlerTestSuite) TestRedirectPathPrefixSiblingPath() {
+	handler := server.HTTPSRedirectHandler{PathPrefix: "/prefix"}
+	r := httptest.NewRequest("GET", "http://example.com/prefixfoo", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusNotFound, w.Result().StatusCode)
+
+	r = httptest.NewRequest("GET", "http://example.com/prefix", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal("https://example.com/prefix", w.Result().Header.Get("Location"))
+}
+
+// HSTSHeader returns the value for the Strict-Transport-Security header.
+func (s *HTTPSRedirectHandlerTestSuite) TestHSTSHeader() {
+	s.Equal("max-age=3600", server.HSTSHeader(3600, false, false))
+	s.Equal(
+		"max-age=31536000; includeSubDomains; preload",
+		server.HSTSHeader(31536000, true, true))
+}
+
+func TestVirtualHostHandler(t *testing.T) {
+	suite.Run(t, new(VirtualHostHandlerTestSuite))
+}
+
+type VirtualHostHandlerTestSuite struct {
+	suite.Suite
+
+	handler server.VirtualHostHandler
+}
+
+func (s *VirtualHostHandlerTestSuite) SetupTest() {
+	s.handler = server.VirtualHostHandler{
+		Hosts: map[string]http.Handler{
+			"example.com":       s.contentHandler("example"),
+			"*.example.com":     s.contentHandler("wildcard"),
+			"foo.example.com":   s.contentHandler("foo"),
+			"*.bar.example.com": s.contentHandler("bar-wildcard"),
+		},
+		Default: s.contentHandler("default"),
+	}
+}
+
+func (s *VirtualHostHandlerTestSuite) contentHandler(content string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(content))
+		})
+}
+
+// Requests are dispatched based on the host name.
+func (s *VirtualHostHandlerTestSuite) TestDispatchByHost() {
+	hosts := map[string]string{
+		"example.com":           "example",
+		"EXAMPLE.com:8080":      "example",
+		"example.com.":          "example",
+		"foo.example.com":       "foo",
+		"other.example.com":     "wildcard",
+		"a.b.example.com":       "wildcard",
+		"baz.bar.example.com":   "bar-wildcard",
+		"a.baz.bar.example.com": "bar-wildcard",
+		"example.org":           "default",
+		"[::1]:8080":            "default",
+	}
+	for host, content := range hosts {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, r)
+		s.Equal(content, w.Body.String(), host)
+	}
+}
+
+// If no default handler is set, a 404 is returned for unknown hosts.
+func (s *VirtualHostHandlerTestSuite) TestNoDefault() {
+	s.handler.Default = nil
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "example.org"
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	s.Equal(http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestClientCertHandler(t *testing.T) {
+	suite.Run(t, new(ClientCertHandlerTestSuite))
+}
+
+type ClientCertHandlerTestSuite struct {
+	suite.Suite
+
+	ca      *testhelpers.CertificateAuthority
+	handler server.ClientCertHandler
+}
+
+func (s *ClientCertHandlerTestSuite) SetupTest() {
+	s.ca = testhelpers.NewCertificateAuthority("test CA")
+	s.handler = server.ClientCertHandler{
+		Handler: http.NotFoundHandler(), // a valid request returns 404
+		Access: map[string][]string{
+			"CN=builder,O=Example": {"/artifacts/"},
+			"reader":               {"/docs", "/public"},
+			"admin":                {"/"},
+		},
+	}
+}
+
+func (s *ClientCertHandlerTestSuite) request(commonName, path string) int {
+	r := httptest.NewRequest("GET", path, nil)
+	if commonName != "" {
+		cert := s.ca.IssueClientCertificate(
+			pkix.Name{CommonName: commonName, Organization: []string{"Example"}})
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert.Leaf}}}
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w.Result().StatusCode
+}
+
+// Requests without a verified client certificate are forbidden.
+func (s *ClientCertHandlerTestSuite) TestNoCertificate() {
+	s.Equal(http.StatusForbidden, s.request("", "/docs/"))
+}
+
+// Requests from unknown subjects are forbidden.
+func (s *ClientCertHandlerTestSuite) TestUnknownSubject() {
+	s.Equal(http.StatusForbidden, s.request("other", "/docs/"))
+}
+
+// Subjects are allowed access to configured path prefixes.
+func (s *ClientCertHandlerTestSuite) TestAllowedPaths() {
+	s.Equal(http.StatusNotFound, s.request("builder", "/artifacts/foo.zip"))
+	s.Equal(http.StatusNotFound, s.request("builder", "/artifacts"))
+	s.Equal(http.StatusNotFound, s.request("reader", "/docs/index.html"))
+	s.Equal(http.StatusNotFound, s.request("reader", "/public"))
+	s.Equal(http.StatusNotFound, s.request("admin", "/anything"))
+}
+
+// Subjects are not allowed access to other paths.
+func (s *ClientCertHandlerTestSuite) TestForbiddenPaths() {
+	s.Equal(http.StatusForbidden, s.request("builder", "/docs/"))
+	s.Equal(http.StatusForbidden, s.request("reader", "/docsfoo"))
+	s.Equal(http.StatusForbidden, s.request("reader", "/docs/../artifacts/"))
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// HeaderRuleConfig holds a rule to set or remove response headers, for
+// requests matching the path and content type conditions.
+type HeaderRuleConfig struct {
+	// Glob pattern matching the response content type (e.g. "text/*")
+	ContentType string `json:"content-type"`
+	// Glob pattern matching the request path. Patterns without slashes
+	// match the last path element.
+	Path string `json:"path"`
+	// Regular expression matching the request path
+	PathRegexp string `json:"path-regexp"`
+	// Names of headers to remove
+	Remove []string `json:"remove"`
+	// Headers to set, by name
+	Set map[string]string `json:"set"`
+}
+
+// Validate raises an error if HeaderRuleConfig is invalid.
+func (c HeaderRuleConfig) Validate() error {
+	_, err := NewHeaderRule(c)
+	return err
+}
+
+// validateHeaderRules raises an error if any of the header rules is invalid.
+func validateHeaderRules(rules []HeaderRuleConfig) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return prefixConfigError(fmt.Sprintf("header-rules[%d]", i), err)
+		}
+	}
+	return nil
+}
+
+// HeaderRule sets or removes response headers for matching requests.
+type HeaderRule struct {
+	contentType string
+	path        *regexp.Regexp
+	pathRegexp  *regexp.Regexp
+	remove      []string
+	set         http.Header
+}
+
+// NewHeaderRule returns a HeaderRule from its config.
+func NewHeaderRule(config HeaderRuleConfig) (*HeaderRule, error) {
+	if len(config.Set) == 0 && len(config.Remove) == 0 {
+		return nil, &ConfigError{Key: "set", Err: errors.New("no headers to set or remove")}
+	}
+	rule := &HeaderRule{set: make(http.Header)}
+	if config.ContentType != "" {
+		if _, err := path.Match(config.ContentType, ""); err != nil {
+			return nil, &ConfigError{
+				Key: "content-type",
+				Err: fmt.Errorf("invalid pattern: %s", config.ContentType),
+			}
+		}
+		rule.contentType = strings.ToLower(config.ContentType)
+	}
+	if config.Path != "" {
+		rule.path = globRegexp(config.Path)
+	}
+	if config.PathRegexp != "" {
+		re, err := regexp.Compile(config.PathRegexp)
+		if err != nil {
+			return nil, &ConfigError{Key: "path-regexp", Err: err}
+		}
+		rule.pathRegexp = re
+	}
+	for name, value := range config.Set {
+		rule.set.Set(name, value)
+	}
+	for _, name := range config.Remove {
+		rule.remove = append(rule.remove, http.CanonicalHeaderKey(name))
+	}
+	return rule, nil
+}
+
+// globRegexp returns a regexp for a glob pattern matching paths. In the
+// pattern, "**" matches any sequence of characters ("**/" also matches no
+// directories), "*" any sequence of characters except slashes, and "?" a
+// single character except a slash.
+//
+// Patterns without slashes match the last path element.
+func globRegexp(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	if strings.Contains(pattern, "/") {
+		expr.WriteString("^")
+	} else {
+		expr.WriteString("(^|/)")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+// Matches returns whether the rule applies to a response with the specified
+// content type, for a request path.
+func (r *HeaderRule) Matches(urlPath, contentType string) bool {
+	if r.path != nil && !r.path.MatchString(urlPath) {
+		return false
+	}
+	if r.pathRegexp != nil && !r.pathRegexp.MatchString(urlPath) {
+		return false
+	}
+	if r.contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false
+		}
+		if matched, _ := path.Match(r.contentType, mediaType); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply updates response headers, removing and then setting headers.
+func (r *HeaderRule) Apply(header http.Header) {
+	for _, name := range r.remove {
+		header.Del(name)
+	}
+	for name, values := range r.set {
+		header[name] = append([]string(nil), values...)
+	}
+}
+
+// HeaderRules is a list of header rules, evaluated in order.
+type HeaderRules []*HeaderRule
+
+// NewHeaderRules returns HeaderRules from their config.
+func NewHeaderRules(configs []HeaderRuleConfig) (HeaderRules, error) {
+	rules := make(HeaderRules, len(configs))
+	for i, config := range configs {
+		rule, err := NewHeaderRule(config)
+		if err != nil {
+			return nil, prefixConfigError(fmt.Sprintf("header-rules[%d]", i), err)
+		}
+		rules[i] = rule
+	}
+	return rules, nil
+}
+
+// Apply updates response headers with all rules matching the request path
+// and the response content type. Since rules are applied in order, later
+// ones override headers set by earlier ones.
+func (r HeaderRules) Apply(header http.Header, urlPath string) {
+	contentType := header.Get("Content-Type")
+	for _, rule := range r {
+		if rule.Matches(urlPath, contentType) {
+			rule.Apply(header)
+		}
+	}
+}
+
+// HeaderRulesHandler wraps an http.Handler, updating response headers
+// according to rules.
+type HeaderRulesHandler struct {
+	http.Handler
+
+	Rules HeaderRules
+}
+
+type headerRulesResponseWriter struct {
+	http.ResponseWriter
+
+	rules   HeaderRules
+	urlPath string
+	applied bool
+}
+
+// apply updates headers with rules, before they're written. Rules only apply
+// to successful and Not Modified responses, not to errors or redirects.
+func (w *headerRulesResponseWriter) apply(statusCode int) {
+	if w.applied {
+		return
+	}
+	w.applied = true
+	if statusCode/100 != 2 && statusCode != http.StatusNotModified {
+		return
+	}
+	w.rules.Apply(w.ResponseWriter.Header(), w.urlPath)
+}
+
+func (w *headerRulesResponseWriter) WriteHeader(statusCode int) {
+	w.apply(statusCode)
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *headerRulesResponseWriter) Write(b []byte) (int, error) {
+	w.apply(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+// ServeHTTP serves the request via the wrapped handler, applying rules to
+// headers once the response status and content type are known.
+func (h HeaderRulesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Handler.ServeHTTP(
+		&headerRulesResponseWriter{
+			ResponseWriter: w,
+			rules:          h.Rules,
+			urlPath:        cleanURLPath(r.URL.Path),
+		},
+		r)
+}
+
+// cleanURLPath returns the cleaned version of a URL path, preserving the
+// trailing slash.
+func cleanURLPath(urlPath string) string {
+	cleaned := path.Clean("/" + urlPath)
+	if strings.HasSuffix(urlPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
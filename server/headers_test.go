@@ -0,0 +1,246 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+)
+
+func TestHeaderRule(t *testing.T) {
+	suite.Run(t, new(HeaderRuleTestSuite))
+}
+
+type HeaderRuleTestSuite struct {
+	suite.Suite
+}
+
+func (s *HeaderRuleTestSuite) rule(config server.HeaderRuleConfig) *server.HeaderRule {
+	if config.Set == nil && config.Remove == nil {
+		config.Set = map[string]string{"X-Test": "yes"}
+	}
+	rule, err := server.NewHeaderRule(config)
+	s.Require().Nil(err)
+	return rule
+}
+
+// Glob patterns match request paths.
+func (s *HeaderRuleTestSuite) TestMatchesPath() {
+	for pattern, paths := range map[string]map[string]bool{
+		"/assets/*.js": {
+			"/assets/app.js":     true,
+			"/assets/sub/app.js": false,
+			"/app.js":            false,
+		},
+		"/assets/**": {
+			"/assets/app.js":     true,
+			"/assets/sub/app.js": true,
+			"/other/app.js":      false,
+		},
+		"/**/*.?????????.css": {
+			"/style.abcdef123.css":     true,
+			"/dir/style.abcdef123.css": true,
+			"/style.css":               false,
+		},
+		"index.html": {
+			"/index.html":        true,
+			"/docs/index.html":   true,
+			"/docs/myindex.html": false,
+		},
+		"/docs/": {
+			"/docs/":           true,
+			"/docs":            false,
+			"/docs/index.html": false,
+		},
+	} {
+		rule := s.rule(server.HeaderRuleConfig{Path: pattern})
+		for path, match := range paths {
+			s.Equal(match, rule.Matches(path, ""), "%s %s", pattern, path)
+		}
+	}
+}
+
+// Regular expressions match request paths.
+func (s *HeaderRuleTestSuite) TestMatchesPathRegexp() {
+	rule := s.rule(server.HeaderRuleConfig{PathRegexp: `\.[0-9a-f]{8}\.(js|css)$`})
+	s.True(rule.Matches("/app.0123abcd.js", ""))
+	s.True(rule.Matches("/static/style.0123abcd.css", ""))
+	s.False(rule.Matches("/app.js", ""))
+}
+
+// Glob patterns match content types.
+func (s *HeaderRuleTestSuite) TestMatchesContentType() {
+	rule := s.rule(server.HeaderRuleConfig{ContentType: "text/*"})
+	s.True(rule.Matches("/", "text/html; charset=utf-8"))
+	s.True(rule.Matches("/", "Text/CSS"))
+	s.False(rule.Matches("/", "application/json"))
+	s.False(rule.Matches("/", ""))
+}
+
+// All conditions must match.
+func (s *HeaderRuleTestSuite) TestMatchesAll() {
+	rule := s.rule(server.HeaderRuleConfig{Path: "/docs/**", ContentType: "text/html"})
+	s.True(rule.Matches("/docs/index.html", "text/html"))
+	s.False(rule.Matches("/docs/style.css", "text/css"))
+	s.False(rule.Matches("/index.html", "text/html"))
+}
+
+// Rules without conditions match everything.
+func (s *HeaderRuleTestSuite) TestMatchesNoConditions() {
+	s.True(s.rule(server.HeaderRuleConfig{}).Matches("/any", "image/png"))
+}
+
+// Headers are removed and set.
+func (s *HeaderRuleTestSuite) TestApply() {
+	rule := s.rule(
+		server.HeaderRuleConfig{
+			Set:    map[string]string{"cache-control": "no-cache"},
+			Remove: []string{"server", "x-unset"},
+		})
+	header := http.Header{
+		"Server":        {"h2static"},
+		"Cache-Control": {"max-age=60"},
+	}
+	rule.Apply(header)
+	s.Equal(http.Header{"Cache-Control": {"no-cache"}}, header)
+}
+
+// Rules are applied in order, with later ones overriding earlier ones.
+func (s *HeaderRuleTestSuite) TestRulesApplyOrder() {
+	rules, err := server.NewHeaderRules(
+		[]server.HeaderRuleConfig{
+			{Set: map[string]string{"Cache-Control": "no-cache", "X-Frame-Options": "DENY"}},
+			{Path: "/assets/**", Set: map[string]string{"Cache-Control": "max-age=31536000, immutable"}},
+			{ContentType: "image/*", Remove: []string{"X-Frame-Options"}},
+		})
+	s.Nil(err)
+
+	header := http.Header{"Content-Type": {"text/css"}}
+	rules.Apply(header, "/assets/style.css")
+	s.Equal("max-age=31536000, immutable", header.Get("Cache-Control"))
+	s.Equal("DENY", header.Get("X-Frame-Options"))
+
+	header = http.Header{"Content-Type": {"image/png"}}
+	rules.Apply(header, "/logo.png")
+	s.Equal("no-cache", header.Get("Cache-Control"))
+	s.Equal("", header.Get("X-Frame-Options"))
+}
+
+// Rules must set or remove headers.
+func (s *HeaderRuleTestSuite) TestNoHeaders() {
+	_, err := server.NewHeaderRule(server.HeaderRuleConfig{Path: "/"})
+	s.Equal("set: no headers to set or remove", err.Error())
+}
+
+// Invalid regular expressions are rejected.
+func (s *HeaderRuleTestSuite) TestInvalidPathRegexp() {
+	_, err := server.NewHeaderRule(
+		server.HeaderRuleConfig{PathRegexp: "[", Remove: []string{"Server"}})
+	s.ErrorContains(err, "path-regexp: error parsing regexp")
+}
+
+// Invalid content type patterns are rejected.
+func (s *HeaderRuleTestSuite) TestInvalidContentType() {
+	_, err := server.NewHeaderRule(
+		server.HeaderRuleConfig{ContentType: "text/[", Remove: []string{"Server"}})
+	s.Equal("content-type: invalid pattern: text/[", err.Error())
+}
+
+// Errors for a list of rules include the rule index.
+func (s *HeaderRuleTestSuite) TestRulesError() {
+	_, err := server.NewHeaderRules(
+		[]server.HeaderRuleConfig{{Remove: []string{"Server"}}, {}})
+	s.Equal("header-rules[1].set: no headers to set or remove", err.Error())
+}
+
+func TestHeaderRulesHandler(t *testing.T) {
+	suite.Run(t, new(HeaderRulesHandlerTestSuite))
+}
+
+type HeaderRulesHandlerTestSuite struct {
+	suite.Suite
+}
+
+// Rules are applied to responses, based on the content type set by the
+// wrapped handler.
+func (s *HeaderRulesHandlerTestSuite) TestApplyRules() {
+	rules, err := server.NewHeaderRules(
+		[]server.HeaderRuleConfig{
+			{ContentType: "text/html", Set: map[string]string{"Cache-Control": "no-cache"}},
+			{Path: "/docs/", Remove: []string{"X-Remove"}},
+		})
+	s.Nil(err)
+	handler := server.HeaderRulesHandler{
+		Handler: http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("X-Remove", "value")
+				w.Write([]byte("content"))
+			}),
+		Rules: rules,
+	}
+
+	r := httptest.NewRequest("GET", "/docs/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal("no-cache", w.Header().Get("Cache-Control"))
+	s.Equal("", w.Header().Get("X-Remove"))
+	s.Equal("content", w.Body.String())
+
+	r = httptest.NewRequest("GET", "/other", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal("no-cache", w.Header().Get("Cache-Control"))
+	s.Equal("value", w.Header().Get("X-Remove"))
+}
+
+// Rules are applied when the status code is written.
+func (s *HeaderRulesHandlerTestSuite) TestApplyRulesWriteHeader() {
+	rules, err := server.NewHeaderRules(
+		[]server.HeaderRuleConfig{{Set: map[string]string{"X-Rule": "applied"}}})
+	s.Nil(err)
+	handler := server.HeaderRulesHandler{
+		Handler: http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}),
+		Rules: rules,
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusNoContent, w.Code)
+	s.Equal("applied", w.Header().Get("X-Rule"))
+}
+
+// Rules are applied to Not Modified responses, but not to errors and
+// redirects.
+func (s *HeaderRulesHandlerTestSuite) TestApplyRulesStatus() {
+	rules, err := server.NewHeaderRules(
+		[]server.HeaderRuleConfig{{Set: map[string]string{"Cache-Control": "immutable"}}})
+	s.Nil(err)
+	for statusCode, applied := range map[int]bool{
+		http.StatusOK:                  true,
+		http.StatusPartialContent:      true,
+		http.StatusNotModified:         true,
+		http.StatusMovedPermanently:    false,
+		http.StatusForbidden:           false,
+		http.StatusNotFound:            false,
+		http.StatusInternalServerError: false,
+	} {
+		handler := server.HeaderRulesHandler{
+			Handler: http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(statusCode)
+				}),
+			Rules: rules,
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		s.Equal(applied, w.Header().Get("Cache-Control") == "immutable", statusCode)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin)
+
+package server
+
+import "io/fs"
+
+// fileInode returns the inode number for a file, or 0 if not available.
+func fileInode(info fs.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package server
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileInode returns the inode number for a file, or 0 if not available.
+func fileInode(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// keyPairCheckInterval is how often certificate and key files are checked
+// for changes.
+var keyPairCheckInterval = 30 * time.Second
+
+// KeyPairLoader provides a TLS certificate and key pair loaded from files,
+// which can be reloaded without restarting the server.
+//
+// If reloading fails, the previously loaded pair keeps being used.
+type KeyPairLoader struct {
+	CertFile string
+	KeyFile  string
+
+	mutex       sync.RWMutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// NewKeyPairLoader returns a KeyPairLoader with the certificate and key
+// loaded from the specified files.
+func NewKeyPairLoader(certFile, keyFile string) (*KeyPairLoader, error) {
+	l := &KeyPairLoader{CertFile: certFile, KeyFile: keyFile}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Certificate returns the current certificate.
+func (l *KeyPairLoader) Certificate() *tls.Certificate {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.cert
+}
+
+// GetCertificate returns the current certificate, to be used as
+// tls.Config.GetCertificate.
+func (l *KeyPairLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return l.Certificate(), nil
+}
+
+// Reload loads the certificate and key from files. If the new pair is not
+// valid, an error is returned and the current one is kept.
+//
+// An expired certificate is only rejected when replacing the current one. On
+// the initial load, a warning is logged instead.
+func (l *KeyPairLoader) Reload() error {
+	certModTime, keyModTime, err := l.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return fmt.Errorf("invalid TLS certificate or key: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("invalid TLS certificate: %w", err)
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if expiry := cert.Leaf.NotAfter; expiry.Before(time.Now()) {
+		if l.cert != nil {
+			return fmt.Errorf("TLS certificate %s expired on %s", l.CertFile, expiry)
+		}
+		log.Printf("Warning: TLS certificate %s expired on %s", l.CertFile, expiry)
+	}
+	l.cert = &cert
+	l.certModTime = certModTime
+	l.keyModTime = keyModTime
+	log.Printf("Loaded TLS certificate %s, expires on %s", l.CertFile, cert.Leaf.NotAfter)
+	return nil
+}
+
+// Watch reloads certificate and key when files change or when SIGHUP is
+// received, until the context is canceled.
+func (l *KeyPairLoader) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(keyPairCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			if !l.changed() {
+				continue
+			}
+		}
+		if err := l.Reload(); err != nil {
+			log.Printf("Failed to reload TLS certificate, keeping the current one: %v", err)
+		}
+	}
+}
+
+// changed returns whether certificate or key files changed since they were
+// last loaded.
+func (l *KeyPairLoader) changed() bool {
+	certModTime, keyModTime, err := l.modTimes()
+	if err != nil {
+		return false
+	}
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return !certModTime.Equal(l.certModTime) || !keyModTime.Equal(l.keyModTime)
+}
+
+func (l *KeyPairLoader) modTimes() (certModTime time.Time, keyModTime time.Time, err error) {
+	certInfo, err := os.Stat(l.CertFile)
+	if err != nil {
+		return
+	}
+	keyInfo, err := os.Stat(l.KeyFile)
+	if err != nil {
+		return
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// CertificateStore selects a certificate among multiple key pairs, based on
+// the server name requested by the client via SNI.
+type CertificateStore struct {
+	// Key pairs to select certificates from
+	KeyPairs []*KeyPairLoader
+	// Key pair used when no certificate matches the server name
+	Default *KeyPairLoader
+}
+
+// GetCertificate returns the certificate matching the requested server
+// name, to be used as tls.Config.GetCertificate.
+//
+// Certificates with an exact match for the name are preferred over ones
+// matching it via wildcard. If no certificate matches, the default one is
+// returned.
+func (s *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := s.findCertificate(strings.ToLower(hello.ServerName)); cert != nil {
+		return cert, nil
+	}
+	if s.Default != nil {
+		return s.Default.Certificate(), nil
+	}
+	return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+}
+
+func (s *CertificateStore) findCertificate(serverName string) *tls.Certificate {
+	if serverName == "" {
+		return nil
+	}
+	var wildcardMatch *tls.Certificate
+	for _, keyPair := range s.KeyPairs {
+		cert := keyPair.Certificate()
+		for _, name := range cert.Leaf.DNSNames {
+			if strings.ToLower(name) == serverName {
+				return cert
+			}
+		}
+		if wildcardMatch == nil && cert.Leaf.VerifyHostname(serverName) == nil {
+			wildcardMatch = cert
+		}
+	}
+	return wildcardMatch
+}
+
+// Watch reloads key pairs when files change or when SIGHUP is received, until
+// the context is canceled.
+func (s *CertificateStore) Watch(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, keyPair := range s.KeyPairs {
+		wg.Add(1)
+		go func(keyPair *KeyPairLoader) {
+			defer wg.Done()
+			keyPair.Watch(ctx)
+		}(keyPair)
+	}
+	wg.Wait()
+}
+
+// loadKeyPairsFromDir returns KeyPairLoaders for certificates in a
+// directory.
+//
+// Certificate files must have a .crt or .pem extension, with the key in a
+// file with the same name and .key extension.
+func loadKeyPairsFromDir(dir string) ([]*KeyPairLoader, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var keyPairs []*KeyPairLoader
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".crt" && ext != ".pem") {
+			continue
+		}
+		certFile := filepath.Join(dir, entry.Name())
+		keyFile := strings.TrimSuffix(certFile, ext) + ".key"
+		if _, err := os.Stat(keyFile); err != nil {
+			log.Printf("Skipping TLS certificate %s, key file not found", certFile)
+			continue
+		}
+		keyPair, err := NewKeyPairLoader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		keyPairs = append(keyPairs, keyPair)
+	}
+	if len(keyPairs) == 0 {
+		return nil, fmt.Errorf("no TLS certificates found in %s", dir)
+	}
+	return keyPairs, nil
+}
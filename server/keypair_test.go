@@ -0,0 +1,200 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestKeyPairLoader(t *testing.T) {
+	suite.Run(t, new(KeyPairLoaderTestSuite))
+}
+
+type KeyPairLoaderTestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	logs     bytes.Buffer
+	certFile string
+	keyFile  string
+}
+
+func (s *KeyPairLoaderTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	s.logs.Reset()
+	log.SetOutput(&s.logs)
+	s.certFile, s.keyFile = s.writeKeyPair(time.Now().Add(time.Hour), "example.com")
+}
+
+func (s *KeyPairLoaderTestSuite) TearDownTest() {
+	log.SetOutput(os.Stderr)
+	s.TempDirTestSuite.TearDownTest()
+}
+
+func (s *KeyPairLoaderTestSuite) writeKeyPair(notAfter time.Time, names ...string) (string, string) {
+	cert, key := testhelpers.GenerateKeyPair(notAfter, names...)
+	return s.WriteFile("cert.pem", string(cert)), s.WriteFile("key.pem", string(key))
+}
+
+// touch updates the modification time of a file.
+func (s *KeyPairLoaderTestSuite) touch(path string, modTime time.Time) {
+	s.Nil(os.Chtimes(path, modTime, modTime))
+}
+
+// The key pair is loaded from files.
+func (s *KeyPairLoaderTestSuite) TestLoad() {
+	loader, err := server.NewKeyPairLoader(s.certFile, s.keyFile)
+	s.Nil(err)
+	cert, err := loader.GetCertificate(&tls.ClientHelloInfo{})
+	s.Nil(err)
+	s.Equal([]string{"example.com"}, cert.Leaf.DNSNames)
+	s.Contains(s.logs.String(), "Loaded TLS certificate "+s.certFile+", expires on ")
+}
+
+// An error is returned if files don't match.
+func (s *KeyPairLoaderTestSuite) TestLoadMismatch() {
+	_, key := testhelpers.GenerateKeyPair(time.Now().Add(time.Hour), "example.com")
+	s.WriteFile("key.pem", string(key))
+	loader, err := server.NewKeyPairLoader(s.certFile, s.keyFile)
+	s.Nil(loader)
+	s.Contains(err.Error(), "invalid TLS certificate or key")
+}
+
+// An expired certificate is loaded initially, with a warning.
+func (s *KeyPairLoaderTestSuite) TestLoadExpired() {
+	s.writeKeyPair(time.Now().Add(-time.Hour), "example.com")
+	loader, err := server.NewKeyPairLoader(s.certFile, s.keyFile)
+	s.Nil(err)
+	s.Equal([]string{"example.com"}, loader.Certificate().Leaf.DNSNames)
+	s.Contains(s.logs.String(), "Warning: TLS certificate "+s.certFile+" expired on ")
+}
+
+// Reload loads the new key pair.
+func (s *KeyPairLoaderTestSuite) TestReload() {
+	loader, err := server.NewKeyPairLoader(s.certFile, s.keyFile)
+	s.Nil(err)
+	s.writeKeyPair(time.Now().Add(time.Hour), "example.org")
+	s.Nil(loader.Reload())
+	s.Equal([]string{"example.org"}, loader.Certificate().Leaf.DNSNames)
+}
+
+// If reloading fails, the current key pair is kept.
+func (s *KeyPairLoaderTestSuite) TestReloadInvalidKeepsCurrent() {
+	loader, err := server.NewKeyPairLoader(s.certFile, s.keyFile)
+	s.Nil(err)
+	s.WriteFile("cert.pem", "invalid")
+	s.NotNil(loader.Reload())
+	s.Equal([]string{"example.com"}, loader.Certificate().Leaf.DNSNames)
+}
+
+// If the new certificate is expired, an error is returned and the current key
+// pair is kept.
+func (s *KeyPairLoaderTestSuite) TestReloadExpiredKeepsCurrent() {
+	loader, err := server.NewKeyPairLoader(s.certFile, s.keyFile)
+	s.Nil(err)
+	s.writeKeyPair(time.Now().Add(-time.Hour), "example.org")
+	err = loader.Reload()
+	s.ErrorContains(err, "TLS certificate "+s.certFile+" expired on ")
+	s.Equal([]string{"example.com"}, loader.Certificate().Leaf.DNSNames)
+}
+
+// Files are reloaded when changed.
+func (s *KeyPairLoaderTestSuite) TestWatch() {
+	defer server.SetKeyPairCheckInterval(10 * time.Millisecond)()
+	loader, err := server.NewKeyPairLoader(s.certFile, s.keyFile)
+	s.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go loader.Watch(ctx)
+
+	s.writeKeyPair(time.Now().Add(time.Hour), "example.org")
+	modTime := time.Now().Add(time.Minute)
+	s.touch(s.certFile, modTime)
+	s.touch(s.keyFile, modTime)
+	s.Eventually(
+		func() bool {
+			return loader.Certificate().Leaf.DNSNames[0] == "example.org"
+		},
+		time.Second, 10*time.Millisecond)
+}
+
+func TestCertificateStore(t *testing.T) {
+	suite.Run(t, new(CertificateStoreTestSuite))
+}
+
+type CertificateStoreTestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	store *server.CertificateStore
+}
+
+func (s *CertificateStoreTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	log.SetOutput(io.Discard)
+	s.store = &server.CertificateStore{
+		KeyPairs: []*server.KeyPairLoader{
+			s.keyPair("default", "default.example.com"),
+			s.keyPair("wildcard", "*.example.com"),
+			s.keyPair("exact", "foo.example.com"),
+			s.keyPair("other", "example.org", "www.example.org"),
+		},
+	}
+	s.store.Default = s.store.KeyPairs[0]
+}
+
+func (s *CertificateStoreTestSuite) TearDownTest() {
+	log.SetOutput(os.Stderr)
+	s.TempDirTestSuite.TearDownTest()
+}
+
+func (s *CertificateStoreTestSuite) keyPair(name string, names ...string) *server.KeyPairLoader {
+	cert, key := testhelpers.GenerateKeyPair(time.Now().Add(time.Hour), names...)
+	keyPair, err := server.NewKeyPairLoader(
+		s.WriteFile(name+".crt", string(cert)), s.WriteFile(name+".key", string(key)))
+	s.Nil(err)
+	return keyPair
+}
+
+func (s *CertificateStoreTestSuite) certName(serverName string) string {
+	cert, err := s.store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	s.Nil(err)
+	return cert.Leaf.DNSNames[0]
+}
+
+// The certificate is selected based on the server name.
+func (s *CertificateStoreTestSuite) TestGetCertificate() {
+	s.Equal("example.org", s.certName("example.org"))
+	s.Equal("example.org", s.certName("WWW.example.org"))
+	s.Equal("default.example.com", s.certName("default.example.com"))
+}
+
+// Certificates with an exact match are preferred to wildcard ones.
+func (s *CertificateStoreTestSuite) TestGetCertificateWildcard() {
+	s.Equal("*.example.com", s.certName("bar.example.com"))
+	s.Equal("foo.example.com", s.certName("foo.example.com"))
+	// wildcards only match one level
+	s.Equal("default.example.com", s.certName("a.bar.example.com"))
+}
+
+// The default certificate is returned if no name matches.
+func (s *CertificateStoreTestSuite) TestGetCertificateDefault() {
+	s.Equal("default.example.com", s.certName("example.net"))
+	s.Equal("default.example.com", s.certName(""))
+}
+
+// An error is returned if no name matches and there's no default.
+func (s *CertificateStoreTestSuite) TestGetCertificateNoDefault() {
+	s.store.Default = nil
+	cert, err := s.store.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.net"})
+	s.Nil(cert)
+	s.Equal(`no certificate for server name "example.net"`, err.Error())
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// Prefix for addresses of Unix domain sockets (e.g. "unix:/run/h2static.sock")
+	unixAddrPrefix = "unix:"
+	// Prefix for addresses of sockets passed via systemd socket activation,
+	// optionally followed by the socket name (e.g. "systemd:http")
+	systemdAddrPrefix = "systemd:"
+)
+
+// AddrList is a list of addresses to listen on.
+//
+// In configuration files, it can be set either as a single string or as a
+// list of strings.
+type AddrList []string
+
+// UnmarshalJSON decodes the list from either a string or a list of strings.
+func (l *AddrList) UnmarshalJSON(data []byte) error {
+	var addr string
+	if err := json.Unmarshal(data, &addr); err == nil {
+		*l = AddrList{addr}
+		return nil
+	}
+	var addrs []string
+	if err := json.Unmarshal(data, &addrs); err != nil {
+		return fmt.Errorf("invalid address list: %s", data)
+	}
+	*l = addrs
+	return nil
+}
+
+// systemdFDStart is the first file descriptor passed via systemd socket
+// activation.
+var systemdFDStart = 3
+
+// isTCPAddr returns whether the address is for a TCP socket.
+func isTCPAddr(addr string) bool {
+	return !strings.HasPrefix(addr, unixAddrPrefix) && !strings.HasPrefix(addr, systemdAddrPrefix)
+}
+
+// validateAddr raises an error if the address is invalid.
+//
+// Since each socket passed via systemd can only be used once, systemd
+// addresses are tracked in seenSystemd, and repeated ones are rejected.
+func validateAddr(addr string, seenSystemd map[string]bool) error {
+	if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok && path == "" {
+		return errors.New("socket path not set")
+	}
+	if strings.HasPrefix(addr, systemdAddrPrefix) {
+		if seenSystemd[addr] {
+			return fmt.Errorf("systemd socket used more than once: %s", addr)
+		}
+		seenSystemd[addr] = true
+	}
+	return nil
+}
+
+// socketOwner holds user and group IDs for a Unix socket file. IDs are -1 if
+// not changed.
+type socketOwner struct {
+	uid int
+	gid int
+}
+
+// parseSocketMode returns the file mode from an octal string.
+func parseSocketMode(mode string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("invalid file mode: %s", mode)
+	}
+	return os.FileMode(n), nil
+}
+
+// parseSocketOwner returns the socket owner from a string in the
+// "user[:group]" form. User and group can be either names or numeric IDs.
+func parseSocketOwner(owner string) (socketOwner, error) {
+	result := socketOwner{uid: -1, gid: -1}
+	userName, groupName, _ := strings.Cut(owner, ":")
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			if u, err = user.LookupId(userName); err != nil {
+				return result, fmt.Errorf("unknown user: %s", userName)
+			}
+		}
+		if result.uid, err = strconv.Atoi(u.Uid); err != nil {
+			return result, fmt.Errorf("unsupported user ID: %s", u.Uid)
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return result, fmt.Errorf("unknown group: %s", groupName)
+			}
+		}
+		if result.gid, err = strconv.Atoi(g.Gid); err != nil {
+			return result, fmt.Errorf("unsupported group ID: %s", g.Gid)
+		}
+	}
+	return result, nil
+}
+
+// validateSocketOptions raises an error if options for Unix sockets are
+// invalid.
+func (c StaticServerConfig) validateSocketOptions() error {
+	seen := make(map[string]bool)
+	seenSystemd := make(map[string]bool)
+	for _, addr := range c.Addr {
+		if err := validateAddr(addr, seenSystemd); err != nil {
+			return &ConfigError{Key: "addr", Err: err}
+		}
+		if seen[addr] {
+			return &ConfigError{Key: "addr", Err: fmt.Errorf("duplicated address: %s", addr)}
+		}
+		seen[addr] = true
+	}
+	addrs := []struct{ key, addr string }{
+		{"debug-addr", c.DebugAddr},
+		{"http-redirect-addr", c.HTTPRedirectAddr},
+		{"acme.http-addr", c.ACME.HTTPAddr},
+	}
+	for _, a := range addrs {
+		if a.key == "acme.http-addr" && a.addr == c.HTTPRedirectAddr {
+			// the same server is used for both
+			continue
+		}
+		if err := validateAddr(a.addr, seenSystemd); err != nil {
+			return &ConfigError{Key: a.key, Err: err}
+		}
+	}
+	if c.SocketMode != "" {
+		if _, err := parseSocketMode(c.SocketMode); err != nil {
+			return &ConfigError{Key: "socket-mode", Err: err}
+		}
+	}
+	if c.SocketOwner != "" {
+		if _, err := parseSocketOwner(c.SocketOwner); err != nil {
+			return &ConfigError{Key: "socket-owner", Err: err}
+		}
+	}
+	return nil
+}
+
+// listen returns a listener for an address.
+//
+// The address can be a TCP host:port, a Unix socket path with the "unix:"
+// prefix, or a socket passed via systemd socket activation with the
+// "systemd:" prefix.
+func (s *StaticServer) listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+		return s.listenUnix(path)
+	}
+	if name, ok := strings.CutPrefix(addr, systemdAddrPrefix); ok {
+		return s.systemdSockets.listener(name)
+	}
+	if addr == "" {
+		addr = ":http"
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenUnix returns a listener for a Unix socket, setting mode and owner of
+// the socket file if configured.
+func (s *StaticServer) listenUnix(path string) (net.Listener, error) {
+	// remove a stale socket from a previous run, but not one still in use by
+	// another process
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket in use: %s", path)
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setupSocketFile(path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// boundServer is a server along with a listener to serve requests on.
+type boundServer struct {
+	server   *http.Server
+	listener net.Listener
+	// whether to serve requests over TLS. This can't be determined from the
+	// server when serving, since it sets up a TLS config for HTTP/2 anyway.
+	useTLS bool
+}
+
+// serve serves requests on the listener until the server is shut down. It
+// returns nil if the server is shut down.
+func (b boundServer) serve() error {
+	var err error
+	if b.useTLS {
+		// certificates are provided by the TLS config
+		err = b.server.ServeTLS(b.listener, "", "")
+	} else {
+		err = b.server.Serve(b.listener)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// setupSocketFile sets mode and owner of a Unix socket file.
+func (s *StaticServer) setupSocketFile(path string) error {
+	if s.Config.SocketMode != "" {
+		mode, err := parseSocketMode(s.Config.SocketMode)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	if s.Config.SocketOwner != "" {
+		owner, err := parseSocketOwner(s.Config.SocketOwner)
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(path, owner.uid, owner.gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// systemdSocket is a socket passed via systemd socket activation.
+type systemdSocket struct {
+	fd   int
+	name string
+	// whether the socket has been handed out
+	used bool
+}
+
+// systemdSockets holds sockets passed via systemd socket activation to a
+// server. They're resolved from the environment on first use.
+type systemdSockets struct {
+	mutex    sync.Mutex
+	resolved bool
+	sockets  []*systemdSocket
+}
+
+// resolve returns sockets passed via systemd socket activation, reading them
+// from the environment on the first call.
+//
+// It must be called with the mutex held.
+func (s *systemdSockets) resolve() []*systemdSocket {
+	if s.resolved {
+		return s.sockets
+	}
+	s.resolved = true
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < count; i++ {
+		socket := &systemdSocket{fd: systemdFDStart + i}
+		if i < len(names) {
+			socket.name = names[i]
+		}
+		s.sockets = append(s.sockets, socket)
+	}
+	return s.sockets
+}
+
+// listener returns a listener for a socket passed via systemd socket
+// activation.
+//
+// If name is empty, the first socket not used yet is returned, otherwise the
+// one with the matching name (as set with FileDescriptorName= in the socket
+// unit). Each socket is only handed out once.
+func (s *systemdSockets) listener(name string) (net.Listener, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sockets := s.resolve()
+	if len(sockets) == 0 {
+		return nil, errors.New("no sockets passed via systemd socket activation")
+	}
+	for _, socket := range sockets {
+		if name != "" && socket.name != name {
+			continue
+		}
+		if socket.used {
+			if name != "" {
+				return nil, fmt.Errorf("socket %q passed via systemd socket activation already in use", name)
+			}
+			continue
+		}
+		// the passed file descriptor is left open, so that the socket can
+		// also be used by other servers in the process
+		fd, err := dupFD(socket.fd)
+		if err != nil {
+			return nil, err
+		}
+		socket.used = true
+		file := os.NewFile(uintptr(fd), socket.name)
+		listener, err := net.FileListener(file)
+		// the listener uses a duplicate of the file descriptor
+		file.Close()
+		return listener, err
+	}
+	if name == "" {
+		return nil, errors.New("all sockets passed via systemd socket activation already in use")
+	}
+	return nil, fmt.Errorf("no socket named %q passed via systemd socket activation", name)
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin)
+
+package server
+
+import "errors"
+
+// dupFD returns a duplicate of a file descriptor.
+func dupFD(fd int) (int, error) {
+	return 0, errors.New("socket activation not supported on this platform")
+}
@@ -0,0 +1,135 @@
+package server_test
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestListener(t *testing.T) {
+	suite.Run(t, new(ListenerTestSuite))
+}
+
+type ListenerTestSuite struct {
+	testhelpers.TempDirTestSuite
+}
+
+// An error is returned if a systemd socket is used for more than one
+// address.
+func (s *ListenerTestSuite) TestValidateDuplicatedSystemdAddr() {
+	config := server.StaticServerConfig{
+		Dir:              s.TempDir,
+		Addr:             server.AddrList{"systemd:http", "systemd:"},
+		HTTPRedirectAddr: "systemd:",
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("http-redirect-addr: systemd socket used more than once: systemd:", err.Error())
+}
+
+// Port returns 0 for addresses that are not TCP ones.
+func (s *ListenerTestSuite) TestPortNotTCP() {
+	for _, addr := range []string{"unix:/run/h2static.sock", "systemd:", "systemd:http"} {
+		config := server.StaticServerConfig{Addr: server.AddrList{addr}}
+		s.Equal(uint16(0), config.Port())
+	}
+}
+
+// Port returns the port for the first TCP address.
+func (s *ListenerTestSuite) TestPortFirstTCPAddr() {
+	config := server.StaticServerConfig{
+		Addr: server.AddrList{"unix:/run/h2static.sock", "127.0.0.1:8080", "[::1]:9090"},
+	}
+	s.Equal(uint16(8080), config.Port())
+}
+
+// An error is returned if an address is duplicated.
+func (s *ListenerTestSuite) TestValidateDuplicatedAddr() {
+	config := server.StaticServerConfig{
+		Dir:  s.TempDir,
+		Addr: server.AddrList{":8080", "[::1]:8080", ":8080"},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("addr: duplicated address: :8080", err.Error())
+}
+
+// Run returns an error if any of the addresses can't be bound.
+func (s *ListenerTestSuite) TestRunBindError() {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Nil(err)
+	defer busy.Close()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:  s.TempDir,
+		Addr: server.AddrList{"127.0.0.1:0", busy.Addr().String()},
+	})
+	s.Nil(err)
+	err = serv.Run()
+	s.NotNil(err)
+	s.Contains(err.Error(), busy.Addr().String())
+}
+
+// An error is returned if the Unix socket path is not set.
+func (s *ListenerTestSuite) TestValidateUnixNoPath() {
+	config := server.StaticServerConfig{Dir: s.TempDir, Addr: server.AddrList{"unix:"}}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("addr: socket path not set", err.Error())
+}
+
+// An error is returned if the socket mode is invalid.
+func (s *ListenerTestSuite) TestValidateInvalidSocketMode() {
+	for _, mode := range []string{"abc", "0999", "01777"} {
+		config := server.StaticServerConfig{Dir: s.TempDir, SocketMode: mode}
+		err := config.Validate()
+		s.NotNil(err)
+		s.Equal("socket-mode: invalid file mode: "+mode, err.Error())
+	}
+}
+
+// An error is returned if the socket owner is unknown.
+func (s *ListenerTestSuite) TestValidateUnknownSocketOwner() {
+	config := server.StaticServerConfig{Dir: s.TempDir, SocketOwner: "not-a-user"}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("socket-owner: unknown user: not-a-user", err.Error())
+
+	config.SocketOwner = ":not-a-group"
+	err = config.Validate()
+	s.NotNil(err)
+	s.Equal("socket-owner: unknown group: not-a-group", err.Error())
+}
+
+// HTTP/3 can't be enabled if the address is not a TCP one.
+func (s *ListenerTestSuite) TestValidateH3NotTCP() {
+	config := server.StaticServerConfig{
+		Dir:      s.TempDir,
+		Addr:     server.AddrList{"unix:" + s.TempDir + "/h2static.sock"},
+		TLSCert:  s.WriteFile("cert.pem", "cert"),
+		TLSKey:   s.WriteFile("key.pem", "key"),
+		EnableH3: true,
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("enable-h3: requires a TCP address", err.Error())
+}
+
+// Listen returns an error if no sockets are passed via systemd.
+func (s *ListenerTestSuite) TestListenSystemdNoSockets() {
+	s.T().Setenv("LISTEN_PID", "")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+	s.Nil(err)
+	_, err = server.Listen(serv, "systemd:")
+	s.NotNil(err)
+	s.Equal("no sockets passed via systemd socket activation", err.Error())
+}
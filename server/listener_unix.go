@@ -0,0 +1,10 @@
+//go:build linux || darwin
+
+package server
+
+import "syscall"
+
+// dupFD returns a duplicate of a file descriptor.
+func dupFD(fd int) (int, error) {
+	return syscall.Dup(fd)
+}
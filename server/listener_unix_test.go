@@ -0,0 +1,196 @@
+//go:build linux || darwin
+
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/albertodonato/h2static/server"
+)
+
+// Listen returns a listener for a Unix socket, setting mode and owner for the
+// socket file.
+func (s *ListenerTestSuite) TestListenUnix() {
+	s.WriteFile("test.txt", "some content")
+	socketPath := filepath.Join(s.TempDir, "h2static.sock")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:         s.TempDir,
+		Addr:        server.AddrList{"unix:" + socketPath},
+		SocketMode:  "0600",
+		SocketOwner: fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+	})
+	s.Nil(err)
+	listener, err := server.Listen(serv, serv.Config.Addr[0])
+	s.Nil(err)
+	defer listener.Close()
+
+	info, err := os.Stat(socketPath)
+	s.Nil(err)
+	s.Equal(os.ModeSocket|0600, info.Mode())
+
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	go httpServer.Serve(listener)
+	defer httpServer.Close()
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	response, err := client.Get("http://localhost/test.txt")
+	s.Nil(err)
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	s.Nil(err)
+	s.Equal("some content", string(content))
+}
+
+// Listen replaces a stale Unix socket file.
+func (s *ListenerTestSuite) TestListenUnixStaleSocket() {
+	socketPath := filepath.Join(s.TempDir, "h2static.sock")
+	stale, err := net.Listen("unix", socketPath)
+	s.Nil(err)
+	// leave the socket file behind
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+	s.Nil(err)
+	listener, err := server.Listen(serv, "unix:"+socketPath)
+	s.Nil(err)
+	listener.Close()
+}
+
+// Listen doesn't replace a Unix socket file in use by another listener.
+func (s *ListenerTestSuite) TestListenUnixSocketInUse() {
+	socketPath := filepath.Join(s.TempDir, "h2static.sock")
+	active, err := net.Listen("unix", socketPath)
+	s.Nil(err)
+	defer active.Close()
+
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+	s.Nil(err)
+	_, err = server.Listen(serv, "unix:"+socketPath)
+	s.Equal("socket in use: "+socketPath, err.Error())
+	// the socket is still reachable
+	conn, err := net.Dial("unix", socketPath)
+	s.Nil(err)
+	conn.Close()
+}
+
+// Listen returns an error if the Unix socket path exists and is not a
+// socket.
+func (s *ListenerTestSuite) TestListenUnixNotSocket() {
+	path := s.WriteFile("h2static.sock", "")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+	s.Nil(err)
+	_, err = server.Listen(serv, "unix:"+path)
+	s.NotNil(err)
+}
+
+// Listen returns a listener for a socket passed via systemd socket
+// activation, matching it by name.
+func (s *ListenerTestSuite) TestListenSystemd() {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Nil(err)
+	defer tcpListener.Close()
+	file, err := tcpListener.(*net.TCPListener).File()
+	s.Nil(err)
+	// pass a raw file descriptor, as systemd does
+	fd, err := syscall.Dup(int(file.Fd()))
+	s.Nil(err)
+	file.Close()
+	defer syscall.Close(fd)
+	// only the socket with the requested name is used
+	defer server.SetSystemdFDStart(fd - 1)()
+	s.T().Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	s.T().Setenv("LISTEN_FDS", "2")
+	s.T().Setenv("LISTEN_FDNAMES", "other:http")
+
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+	s.Nil(err)
+	listener, err := server.Listen(serv, "systemd:http")
+	s.Nil(err)
+	defer listener.Close()
+	s.Equal(tcpListener.Addr().String(), listener.Addr().String())
+}
+
+// Each socket passed via systemd socket activation is only handed out once.
+func (s *ListenerTestSuite) TestListenSystemdOnce() {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Nil(err)
+	defer tcpListener.Close()
+	file, err := tcpListener.(*net.TCPListener).File()
+	s.Nil(err)
+	fd, err := syscall.Dup(int(file.Fd()))
+	s.Nil(err)
+	file.Close()
+	defer syscall.Close(fd)
+	defer server.SetSystemdFDStart(fd)()
+	s.T().Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	s.T().Setenv("LISTEN_FDS", "1")
+	s.T().Setenv("LISTEN_FDNAMES", "http")
+
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+	s.Nil(err)
+	listener, err := server.Listen(serv, "systemd:")
+	s.Nil(err)
+	defer listener.Close()
+	s.Equal(tcpListener.Addr().String(), listener.Addr().String())
+
+	_, err = server.Listen(serv, "systemd:http")
+	s.Equal(`socket "http" passed via systemd socket activation already in use`, err.Error())
+	_, err = server.Listen(serv, "systemd:")
+	s.Equal("all sockets passed via systemd socket activation already in use", err.Error())
+}
+
+// Sockets passed via systemd socket activation are tracked per server, so
+// each server in the process can use them.
+func (s *ListenerTestSuite) TestListenSystemdMultipleServers() {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Nil(err)
+	defer tcpListener.Close()
+	file, err := tcpListener.(*net.TCPListener).File()
+	s.Nil(err)
+	fd, err := syscall.Dup(int(file.Fd()))
+	s.Nil(err)
+	file.Close()
+	defer syscall.Close(fd)
+	defer server.SetSystemdFDStart(fd)()
+	s.T().Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	s.T().Setenv("LISTEN_FDS", "1")
+	s.T().Setenv("LISTEN_FDNAMES", "http")
+
+	for i := 0; i < 2; i++ {
+		serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+		s.Nil(err)
+		listener, err := server.Listen(serv, "systemd:http")
+		s.Nil(err)
+		s.Equal(tcpListener.Addr().String(), listener.Addr().String())
+		listener.Close()
+	}
+}
+
+// Listen returns an error if no socket with the name is passed via systemd.
+func (s *ListenerTestSuite) TestListenSystemdUnknownName() {
+	s.T().Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	s.T().Setenv("LISTEN_FDS", "1")
+	s.T().Setenv("LISTEN_FDNAMES", "http")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+	s.Nil(err)
+	_, err = server.Listen(serv, "systemd:https")
+	s.NotNil(err)
+	s.Equal(`no socket named "https" passed via systemd socket activation`, err.Error())
+}
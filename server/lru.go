@@ -0,0 +1,102 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruCacheEntry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+}
+
+// lruCache is an LRU cache limited by the total size of values. Caches
+// created with newLRUCache count each value as one, so they're limited by
+// the number of entries.
+type lruCache[K comparable, V any] struct {
+	mutex   sync.Mutex
+	maxSize int64
+	size    int64
+	sizeOf  func(V) int64
+	// if set, called with values removed from the cache
+	onEvict func(V)
+	// entries, from the most recently used
+	entries *list.List
+	items   map[K]*list.Element
+}
+
+// newLRUCache returns an lruCache limited by the number of entries.
+func newLRUCache[K comparable, V any](maxEntries int) *lruCache[K, V] {
+	return newSizedLRUCache[K](int64(maxEntries), func(V) int64 { return 1 })
+}
+
+// newSizedLRUCache returns an lruCache limited by the total size of values,
+// as returned by sizeOf.
+func newSizedLRUCache[K comparable, V any](maxSize int64, sizeOf func(V) int64) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		maxSize: maxSize,
+		sizeOf:  sizeOf,
+		entries: list.New(),
+		items:   make(map[K]*list.Element),
+	}
+}
+
+// Get returns the cached value for a key.
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.entries.MoveToFront(element)
+	return element.Value.(*lruCacheEntry[K, V]).value, true
+}
+
+// Add caches the value for a key, evicting the least recently used entries
+// if the cache is full. Values larger than the cache are not cached, and
+// false is returned.
+func (c *lruCache[K, V]) Add(key K, value V) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+	size := c.sizeOf(value)
+	if size > c.maxSize {
+		return false
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.entries.Back())
+	}
+	c.items[key] = c.entries.PushFront(&lruCacheEntry[K, V]{key: key, value: value, size: size})
+	c.size += size
+	return true
+}
+
+// Purge removes all values from the cache.
+func (c *lruCache[K, V]) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.entries.Len() > 0 {
+		c.remove(c.entries.Back())
+	}
+}
+
+// Size returns the total size of cached values.
+func (c *lruCache[K, V]) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+func (c *lruCache[K, V]) remove(element *list.Element) {
+	entry := c.entries.Remove(element).(*lruCacheEntry[K, V])
+	delete(c.items, entry.key)
+	c.size -= entry.size
+	if c.onEvict != nil {
+		c.onEvict(entry.value)
+	}
+}
@@ -0,0 +1,439 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Modes for Netlify-style _headers and _redirects files.
+const (
+	// Files are only read from the root directory
+	NetlifyFilesRoot = "root"
+	// Files are read from any directory, and apply to paths under it
+	NetlifyFilesDirs = "dirs"
+)
+
+// Names of Netlify-style control files.
+const (
+	headersFile   = "_headers"
+	redirectsFile = "_redirects"
+)
+
+// controlFileCacheSize is the maximum number of parsed control files cached.
+const controlFileCacheSize = 256
+
+var (
+	parsedHeaders   = newLRUCache[controlFileKey, []headerRule](controlFileCacheSize)
+	parsedRedirects = newLRUCache[controlFileKey, []redirectRule](controlFileCacheSize)
+)
+
+// validateNetlifyFiles raises an error if the mode for Netlify-style files
+// is invalid.
+func validateNetlifyFiles(mode string) error {
+	switch mode {
+	case "", NetlifyFilesRoot, NetlifyFilesDirs:
+		return nil
+	}
+	return &ConfigError{
+		Key: "netlify-files",
+		Err: fmt.Errorf(`invalid mode (must be "%s" or "%s"): %s`, NetlifyFilesRoot, NetlifyFilesDirs, mode),
+	}
+}
+
+// pathPattern matches URL paths with Netlify-style patterns, where ":name"
+// matches a path segment and "*" any sequence of characters, captured as
+// "splat".
+type pathPattern struct {
+	re    *regexp.Regexp
+	names []string
+}
+
+func parsePathPattern(pattern string) (*pathPattern, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("invalid path pattern: %s", pattern)
+	}
+	p := &pathPattern{}
+	var expr strings.Builder
+	expr.WriteString("^")
+	segments := strings.Split(trimTrailingSlash(pattern), "/")[1:]
+	for i, segment := range segments {
+		if segment == "*" && i == len(segments)-1 {
+			// a trailing splat also matches the parent path
+			expr.WriteString("(?:/(.*))?")
+			p.names = append(p.names, "splat")
+			break
+		}
+		expr.WriteString("/")
+		if name, ok := strings.CutPrefix(segment, ":"); ok && name != "" {
+			expr.WriteString("([^/]+)")
+			p.names = append(p.names, name)
+			continue
+		}
+		for j, part := range strings.Split(segment, "*") {
+			if j > 0 {
+				expr.WriteString("(.*)")
+				p.names = append(p.names, "splat")
+			}
+			expr.WriteString(regexp.QuoteMeta(part))
+		}
+	}
+	if len(segments) == 1 && segments[0] == "" {
+		// the root path
+		expr.Reset()
+		expr.WriteString("^/")
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	p.re = re
+	return p, nil
+}
+
+// Match returns the values for placeholders if the path matches.
+func (p *pathPattern) Match(urlPath string) (map[string]string, bool) {
+	match := p.re.FindStringSubmatch(trimTrailingSlash(urlPath))
+	if match == nil {
+		return nil, false
+	}
+	params := make(map[string]string, len(p.names))
+	for i, name := range p.names {
+		params[name] = match[i+1]
+	}
+	return params, true
+}
+
+var placeholderRegexp = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+// expandPlaceholders replaces placeholders in a target with their values.
+// Unknown placeholders are left unchanged.
+func expandPlaceholders(target string, params map[string]string) string {
+	return placeholderRegexp.ReplaceAllStringFunc(
+		target,
+		func(placeholder string) string {
+			if value, ok := params[placeholder[1:]]; ok {
+				return value
+			}
+			return placeholder
+		})
+}
+
+// trimTrailingSlash removes the trailing slash from a path, except for the
+// root.
+func trimTrailingSlash(urlPath string) string {
+	if len(urlPath) > 1 {
+		return strings.TrimSuffix(urlPath, "/")
+	}
+	return urlPath
+}
+
+// headerRule sets headers for paths matching a pattern.
+type headerRule struct {
+	pattern *pathPattern
+	header  http.Header
+}
+
+// parseHeaders parses rules from a _headers file. Invalid lines are logged
+// and ignored.
+func parseHeaders(r io.Reader, name string) []headerRule {
+	var rules []headerRule
+	var rule *headerRule
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			pattern, err := parsePathPattern(line)
+			if err != nil {
+				log.Printf("%s:%d: %v", name, lineno, err)
+				rule = nil
+				continue
+			}
+			rules = append(rules, headerRule{pattern: pattern, header: make(http.Header)})
+			rule = &rules[len(rules)-1]
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		if !found || key == "" || rule == nil {
+			log.Printf("%s:%d: invalid header line: %s", name, lineno, line)
+			continue
+		}
+		rule.header.Add(key, strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("%s: %v", name, err)
+	}
+	return rules
+}
+
+// redirectRule redirects or rewrites paths matching a pattern to a target.
+type redirectRule struct {
+	from   *pathPattern
+	to     string
+	status int
+	// whether the rule applies even if a file exists for the path
+	force bool
+}
+
+// isLocal returns whether the target of the rule is a path on the site.
+func (r redirectRule) isLocal() bool {
+	return strings.HasPrefix(r.to, "/")
+}
+
+// parseRedirects parses rules from a _redirects file. Invalid lines are
+// logged and ignored.
+func parseRedirects(r io.Reader, name string) []redirectRule {
+	var rules []redirectRule
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRedirectRule(line)
+		if err != nil {
+			log.Printf("%s:%d: %v", name, lineno, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("%s: %v", name, err)
+	}
+	return rules
+}
+
+// parseRedirectRule parses a "from to [status[!]]" line.
+func parseRedirectRule(line string) (redirectRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return redirectRule{}, fmt.Errorf("invalid redirect rule: %s", line)
+	}
+	from, err := parsePathPattern(fields[0])
+	if err != nil {
+		return redirectRule{}, err
+	}
+	rule := redirectRule{from: from, to: fields[1], status: http.StatusMovedPermanently}
+	if !rule.isLocal() && !strings.HasPrefix(rule.to, "http://") && !strings.HasPrefix(rule.to, "https://") {
+		return redirectRule{}, fmt.Errorf("invalid redirect target: %s", rule.to)
+	}
+	if len(fields) == 3 {
+		status, force := strings.CutSuffix(fields[2], "!")
+		code, err := strconv.Atoi(status)
+		if err != nil || !validRedirectStatus(code) {
+			return redirectRule{}, fmt.Errorf("invalid redirect status: %s", fields[2])
+		}
+		rule.status = code
+		rule.force = force
+	}
+	if !rule.isLocal() && (rule.status == http.StatusOK || rule.status >= 400) {
+		return redirectRule{}, fmt.Errorf("status %d requires a local target: %s", rule.status, rule.to)
+	}
+	return rule, nil
+}
+
+// validRedirectStatus returns whether a status code is valid for redirect
+// rules: 200 for rewrites, redirects, or client errors (e.g. for custom 404
+// pages).
+func validRedirectStatus(code int) bool {
+	switch {
+	case code == http.StatusOK:
+		return true
+	case code == http.StatusMovedPermanently, code == http.StatusFound,
+		code == http.StatusSeeOther, code == http.StatusTemporaryRedirect,
+		code == http.StatusPermanentRedirect:
+		return true
+	case code >= 400 && code < 500:
+		return true
+	}
+	return false
+}
+
+// controlFileKey identifies a parsed control file in caches.
+type controlFileKey struct {
+	handler uint64
+	path    string
+	size    int64
+	modTime int64
+}
+
+// loadControlFile returns rules parsed from a control file, or nil if the
+// file doesn't exist.
+func loadControlFile[T any](f FileHandler, cache *lruCache[controlFileKey, []T], name string, parse func(io.Reader, string) []T) []T {
+	file, err := f.FileSystem.openControlFile(name)
+	if err != nil {
+		return nil
+	}
+	key := controlFileKey{
+		handler: f.id,
+		path:    name,
+		size:    file.Info.Size(),
+		modTime: file.Info.ModTime().UnixNano(),
+	}
+	if rules, ok := cache.Get(key); ok {
+		return rules
+	}
+	content, err := file.Open()
+	if err != nil {
+		log.Printf("%s: %v", name, err)
+		return nil
+	}
+	defer content.Close()
+	rules := parse(content, name)
+	cache.Add(key, rules)
+	return rules
+}
+
+// controlFileDirs returns directories to read control files from for a
+// path, from the root.
+func (f FileHandler) controlFileDirs(urlPath string) []string {
+	dirs := []string{"/"}
+	if f.FileSystem.NetlifyFiles != NetlifyFilesDirs {
+		return dirs
+	}
+	dirPath := urlPath
+	if !strings.HasSuffix(dirPath, "/") {
+		dirPath = path.Dir(dirPath)
+	}
+	dir := "/"
+	for _, segment := range strings.Split(strings.Trim(dirPath, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		dir = path.Join(dir, segment)
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// relativePath returns a path relative to a directory, as an absolute path.
+func relativePath(dir, urlPath string) string {
+	if dir == "/" {
+		return urlPath
+	}
+	return "/" + strings.TrimPrefix(urlPath, dir+"/")
+}
+
+// applyNetlifyRules applies rules from _redirects files for a request path.
+// The first matching rule is applied, with rules from directories closer to
+// the path taking precedence over ones from their parents.
+//
+// Unless rules are forced, they're skipped if a file exists for the path.
+//
+// It returns true if the response has been written, or the path to serve
+// instead of the requested one, for rewrites.
+func (f FileHandler) applyNetlifyRules(w http.ResponseWriter, r *http.Request, urlPath string) (bool, string) {
+	dirs := f.controlFileDirs(urlPath)
+	checked, exists := false, false
+	fileExists := func() bool {
+		if !checked {
+			_, err := f.FileSystem.Open(urlPath)
+			checked, exists = true, err == nil
+		}
+		return exists
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		relPath := relativePath(dir, urlPath)
+		for _, rule := range loadControlFile(f, parsedRedirects, path.Join(dir, redirectsFile), parseRedirects) {
+			params, ok := rule.from.Match(relPath)
+			if !ok {
+				continue
+			}
+			if !rule.force && fileExists() {
+				continue
+			}
+			target := expandPlaceholders(rule.to, params)
+			if rule.isLocal() {
+				trailingSlash := strings.HasSuffix(target, "/")
+				target = path.Join(dir, target)
+				if trailingSlash && !strings.HasSuffix(target, "/") {
+					target += "/"
+				}
+			}
+			switch {
+			case rule.status == http.StatusOK:
+				return false, target
+			case rule.status >= 400:
+				f.serveStatusPage(w, r, target, rule.status)
+				return true, ""
+			}
+			if rule.isLocal() {
+				target = f.pathPrefix + f.mountPrefix + target
+			}
+			if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, rule.status)
+			return true, ""
+		}
+	}
+	return false, ""
+}
+
+// addNetlifyHeaders adds headers from _headers files matching a path to the
+// response. As for Netlify, they only apply to content that's served, not to
+// errors, status pages or redirects.
+func (f FileHandler) addNetlifyHeaders(w http.ResponseWriter, urlPath string) {
+	if f.FileSystem.NetlifyFiles == "" {
+		return
+	}
+	header := w.Header()
+	for _, dir := range f.controlFileDirs(urlPath) {
+		relPath := relativePath(dir, urlPath)
+		for _, rule := range loadControlFile(f, parsedHeaders, path.Join(dir, headersFile), parseHeaders) {
+			if _, ok := rule.pattern.Match(relPath); !ok {
+				continue
+			}
+			for key, values := range rule.header {
+				for _, value := range values {
+					header.Add(key, value)
+				}
+			}
+		}
+	}
+}
+
+// serveStatusPage serves the content of a page with the specified status
+// code (e.g. for custom 404 pages).
+func (f FileHandler) serveStatusPage(w http.ResponseWriter, r *http.Request, urlPath string, statusCode int) {
+	file, err := f.FileSystem.Open(urlPath)
+	if err == nil && file.Info.IsDir() {
+		if indexPath := f.findIndexSuffix(path.Clean(urlPath)); indexPath != "" {
+			file, err = f.FileSystem.OpenFile(path.Clean(urlPath) + indexPath)
+		} else {
+			err = fmt.Errorf("not a file: %s", urlPath)
+		}
+	}
+	if err != nil {
+		writeHTTPError(w, statusCode)
+		return
+	}
+	ctype, err := contentType(file)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	content, err := file.Open()
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", ctype)
+	w.WriteHeader(statusCode)
+	if r.Method != http.MethodHead {
+		io.Copy(w, content)
+	}
+}
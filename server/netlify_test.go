@@ -0,0 +1,322 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestNetlifyFiles(t *testing.T) {
+	suite.Run(t, new(NetlifyFilesTestSuite))
+}
+
+type NetlifyFilesTestSuite struct {
+	testhelpers.TempDirTestSuite
+}
+
+func (s *NetlifyFilesTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	s.WriteFile("index.html", "index")
+	s.WriteFile("404.html", "not found")
+	s.Mkdir("blog")
+	s.WriteFile("blog/post.html", "post")
+}
+
+func (s *NetlifyFilesTestSuite) handler(mode string) *server.FileHandler {
+	return server.NewFileHandler(
+		server.FileSystem{Root: s.TempDir, ResolveHTML: true, HideDotFiles: true, NetlifyFiles: mode},
+		true, "")
+}
+
+func (s *NetlifyFilesTestSuite) request(handler http.Handler, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// Headers from the _headers file are added to responses for matching paths.
+func (s *NetlifyFilesTestSuite) TestHeaders() {
+	s.WriteFile(
+		"_headers",
+		"# comment\n"+
+			"/*\n"+
+			"  X-Frame-Options: DENY\n"+
+			"/blog/*\n"+
+			"  Cache-Control: max-age=60\n"+
+			"  X-Multi: one\n"+
+			"  X-Multi: two\n")
+	handler := s.handler(server.NetlifyFilesRoot)
+
+	w := s.request(handler, "/blog/post")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("DENY", w.Header().Get("X-Frame-Options"))
+	s.Equal("max-age=60", w.Header().Get("Cache-Control"))
+	s.Equal([]string{"one", "two"}, w.Header().Values("X-Multi"))
+
+	w = s.request(handler, "/")
+	s.Equal("DENY", w.Header().Get("X-Frame-Options"))
+	s.Equal("", w.Header().Get("Cache-Control"))
+}
+
+// Headers from the _headers file only apply to content that's served, not to
+// errors, status pages or redirects.
+func (s *NetlifyFilesTestSuite) TestHeadersOnlyServedContent() {
+	s.WriteFile("_headers", "/*\n  X-Frame-Options: DENY\n")
+	s.WriteFile(
+		"_redirects",
+		"/old /new\n"+
+			"/gone /404.html 404\n"+
+			"/app/* /index.html 200\n")
+	handler := s.handler(server.NetlifyFilesRoot)
+	for path, expected := range map[string]struct {
+		code    int
+		applied bool
+	}{
+		"/":        {http.StatusOK, true},
+		"/app/foo": {http.StatusOK, true},
+		"/missing": {http.StatusNotFound, false},
+		"/gone":    {http.StatusNotFound, false},
+		"/old":     {http.StatusMovedPermanently, false},
+	} {
+		w := s.request(handler, path)
+		s.Equal(expected.code, w.Code, path)
+		s.Equal(expected.applied, w.Header().Get("X-Frame-Options") == "DENY", path)
+	}
+}
+
+// Invalid lines in the _headers file are ignored.
+func (s *NetlifyFilesTestSuite) TestHeadersInvalidLines() {
+	s.WriteFile(
+		"_headers",
+		"  X-Orphan: yes\n"+
+			"/\n"+
+			"  invalid\n"+
+			"  X-Valid: yes\n")
+	w := s.request(s.handler(server.NetlifyFilesRoot), "/")
+	s.Equal("yes", w.Header().Get("X-Valid"))
+	s.Equal("", w.Header().Get("X-Orphan"))
+}
+
+// Rules from _redirects redirect with the specified status code, 301 by
+// default.
+func (s *NetlifyFilesTestSuite) TestRedirects() {
+	s.WriteFile(
+		"_redirects",
+		"/old /new\n"+
+			"/temp /new 302\n"+
+			"/external https://example.com/path 307\n")
+	handler := s.handler(server.NetlifyFilesRoot)
+
+	for path, expected := range map[string]struct {
+		code     int
+		location string
+	}{
+		"/old":      {http.StatusMovedPermanently, "/new"},
+		"/old/":     {http.StatusMovedPermanently, "/new"},
+		"/temp":     {http.StatusFound, "/new"},
+		"/external": {http.StatusTemporaryRedirect, "https://example.com/path"},
+	} {
+		w := s.request(handler, path)
+		s.Equal(expected.code, w.Code, path)
+		s.Equal(expected.location, w.Header().Get("Location"), path)
+	}
+}
+
+// Splats and placeholders are replaced in redirect targets.
+func (s *NetlifyFilesTestSuite) TestRedirectsSplatsAndPlaceholders() {
+	s.WriteFile(
+		"_redirects",
+		"/news/:year/:month/:slug /blog/:year-:month-:slug\n"+
+			"/docs/* /documentation/:splat\n"+
+			"/files/*.txt /text/:splat\n")
+	handler := s.handler(server.NetlifyFilesRoot)
+
+	for path, location := range map[string]string{
+		"/news/2024/05/hello": "/blog/2024-05-hello",
+		"/docs/guide/intro":   "/documentation/guide/intro",
+		"/docs":               "/documentation/",
+		"/files/notes.txt":    "/text/notes",
+	} {
+		w := s.request(handler, path)
+		s.Equal(http.StatusMovedPermanently, w.Code, path)
+		s.Equal(location, w.Header().Get("Location"), path)
+	}
+	w := s.request(handler, "/news/2024/hello")
+	s.Equal(http.StatusNotFound, w.Code)
+}
+
+// The query string is preserved in redirects.
+func (s *NetlifyFilesTestSuite) TestRedirectsQueryString() {
+	s.WriteFile("_redirects", "/old /new\n")
+	w := s.request(s.handler(server.NetlifyFilesRoot), "/old?page=2")
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("/new?page=2", w.Header().Get("Location"))
+}
+
+// The first matching rule is applied.
+func (s *NetlifyFilesTestSuite) TestRedirectsFirstMatch() {
+	s.WriteFile(
+		"_redirects",
+		"/blog/special /special\n"+
+			"/blog/* /posts/:splat\n")
+	handler := s.handler(server.NetlifyFilesRoot)
+	w := s.request(handler, "/blog/special")
+	s.Equal("/special", w.Header().Get("Location"))
+	w = s.request(handler, "/blog/other")
+	s.Equal("/posts/other", w.Header().Get("Location"))
+}
+
+// Rules are not applied to paths for existing files, unless forced.
+func (s *NetlifyFilesTestSuite) TestRedirectsShadowing() {
+	s.WriteFile(
+		"_redirects",
+		"/blog/post /elsewhere\n"+
+			"/index.html /home 302!\n")
+	handler := s.handler(server.NetlifyFilesRoot)
+
+	w := s.request(handler, "/blog/post")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("post", w.Body.String())
+
+	w = s.request(handler, "/index.html")
+	s.Equal(http.StatusFound, w.Code)
+	s.Equal("/home", w.Header().Get("Location"))
+}
+
+// Rules with status 200 serve content from the target path.
+func (s *NetlifyFilesTestSuite) TestRewrites() {
+	s.WriteFile("_redirects", "/app/* /index.html 200\n")
+	w := s.request(s.handler(server.NetlifyFilesRoot), "/app/some/route")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("index", w.Body.String())
+	s.Equal("", w.Header().Get("Location"))
+}
+
+// Rules with a 4xx status serve content from the target path with that
+// status.
+func (s *NetlifyFilesTestSuite) TestStatusPage() {
+	s.WriteFile("_redirects", "/* /404.html 404\n")
+	handler := s.handler(server.NetlifyFilesRoot)
+
+	w := s.request(handler, "/missing")
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Equal("not found", w.Body.String())
+	s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+	w = s.request(handler, "/blog/post")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("post", w.Body.String())
+}
+
+// Invalid redirect rules are ignored.
+func (s *NetlifyFilesTestSuite) TestRedirectsInvalidRules() {
+	s.WriteFile(
+		"_redirects",
+		"/only-source\n"+
+			"/bad-status /new 299\n"+
+			"/external-rewrite https://example.com 200\n"+
+			"/relative new\n"+
+			"/* /fallback 302\n")
+	handler := s.handler(server.NetlifyFilesRoot)
+	for _, path := range []string{"/only-source", "/bad-status", "/external-rewrite", "/relative"} {
+		w := s.request(handler, path)
+		s.Equal(http.StatusFound, w.Code, path)
+		s.Equal("/fallback", w.Header().Get("Location"), path)
+	}
+}
+
+// Redirects to local paths include the path prefix and mount prefix.
+func (s *NetlifyFilesTestSuite) TestRedirectsMountPrefix() {
+	s.WriteFile("_redirects", "/old /new\n")
+	handler := server.NewMountFileHandler(
+		server.FileSystem{Root: s.TempDir, NetlifyFiles: server.NetlifyFilesRoot},
+		true, "/prefix", "/docs")
+	w := s.request(handler, "/old")
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("/prefix/docs/new", w.Header().Get("Location"))
+}
+
+// In "root" mode, files in subdirectories are not read.
+func (s *NetlifyFilesTestSuite) TestRootModeIgnoresSubdirectories() {
+	s.WriteFile("blog/_redirects", "/old /new\n")
+	handler := s.handler(server.NetlifyFilesRoot)
+	w := s.request(handler, "/blog/old")
+	s.Equal(http.StatusNotFound, w.Code)
+	w = s.request(handler, "/blog/_redirects")
+	s.Equal(http.StatusOK, w.Code)
+}
+
+// In "dirs" mode, files in subdirectories apply to paths under them, relative
+// to the directory, and take precedence over ones in parent directories.
+func (s *NetlifyFilesTestSuite) TestDirsMode() {
+	s.WriteFile(
+		"_redirects",
+		"/blog/old /root-target\n"+
+			"/blog/other /root-other\n")
+	s.WriteFile("_headers", "/*\n  X-Root: yes\n")
+	s.WriteFile("blog/_redirects", "/old /new\n")
+	s.WriteFile("blog/_headers", "/post\n  X-Blog: yes\n")
+	handler := s.handler(server.NetlifyFilesDirs)
+
+	w := s.request(handler, "/blog/old")
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("/blog/new", w.Header().Get("Location"))
+
+	w = s.request(handler, "/blog/other")
+	s.Equal("/root-other", w.Header().Get("Location"))
+
+	w = s.request(handler, "/blog/post")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("yes", w.Header().Get("X-Root"))
+	s.Equal("yes", w.Header().Get("X-Blog"))
+}
+
+// Control files are not served.
+func (s *NetlifyFilesTestSuite) TestControlFilesHidden() {
+	s.WriteFile("_headers", "")
+	s.WriteFile("_redirects", "")
+	s.WriteFile("blog/_redirects", "")
+	for mode, paths := range map[string][]string{
+		server.NetlifyFilesRoot: {"/_headers", "/_redirects"},
+		server.NetlifyFilesDirs: {"/_headers", "/_redirects", "/blog/_redirects"},
+	} {
+		handler := s.handler(mode)
+		for _, path := range paths {
+			w := s.request(handler, path)
+			s.Equal(http.StatusNotFound, w.Code, "%s %s", mode, path)
+		}
+	}
+}
+
+// Control files are not shown in directory listings.
+func (s *NetlifyFilesTestSuite) TestControlFilesHiddenFromListing() {
+	s.RemoveAll("index.html")
+	s.WriteFile("_redirects", "")
+	s.WriteFile("blog/_headers", "")
+
+	w := s.request(s.handler(server.NetlifyFilesRoot), "/")
+	s.Equal(http.StatusOK, w.Code)
+	s.NotContains(w.Body.String(), "_redirects")
+	w = s.request(s.handler(server.NetlifyFilesRoot), "/blog/")
+	s.Contains(w.Body.String(), "_headers")
+
+	w = s.request(s.handler(server.NetlifyFilesDirs), "/blog/")
+	s.NotContains(w.Body.String(), "_headers")
+}
+
+// Control files are served as regular files if the mode is not set.
+func (s *NetlifyFilesTestSuite) TestDisabled() {
+	s.WriteFile("_redirects", "/old /new\n")
+	handler := s.handler("")
+	w := s.request(handler, "/old")
+	s.Equal(http.StatusNotFound, w.Code)
+	w = s.request(handler, "/_redirects")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("/old /new\n", w.Body.String())
+}
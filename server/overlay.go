@@ -0,0 +1,176 @@
+package server
+
+import (
+	iofs "io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// Prefix for whiteout files, hiding the file with the same name without
+	// prefix in lower roots
+	whiteoutPrefix = ".wh."
+	// Name of the whiteout file hiding all content of a directory in lower
+	// roots
+	opaqueWhiteout = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// resolveRoots returns the roots containing a file, starting from the one it
+// is served from, along with the file info.
+//
+// A file is served from the first root containing it. Directories are merged
+// with ones with the same path in lower roots, unless hidden by a whiteout.
+// Whiteout files themselves are never served, even with a single root.
+func resolveRoots(roots []FileSystemRoot, name string) ([]FileSystemRoot, iofs.FileInfo, error) {
+	if len(roots) == 1 {
+		for _, part := range strings.Split(name, "/") {
+			if isWhiteout(part) {
+				return nil, nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+			}
+		}
+		info, err := iofs.Stat(roots[0].fsys(), name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return roots, info, nil
+	}
+
+	for i, root := range roots {
+		if exists(root.fsys(), opaqueWhiteout) {
+			roots = roots[:i+1]
+			break
+		}
+	}
+	info, err := iofs.Stat(roots[0].fsys(), ".")
+	if err != nil {
+		return nil, nil, err
+	}
+	if name == "." {
+		return roots, info, nil
+	}
+	dir := "."
+	for _, part := range strings.Split(name, "/") {
+		if !info.IsDir() {
+			return nil, nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+		}
+		if roots, info, err = resolveChild(roots, dir, part); err != nil {
+			return nil, nil, err
+		}
+		dir = path.Join(dir, part)
+	}
+	return roots, info, nil
+}
+
+// resolveChild returns the roots containing an entry of a directory, along
+// with the entry info. The directory is expected to be in all roots.
+func resolveChild(roots []FileSystemRoot, dir, child string) ([]FileSystemRoot, iofs.FileInfo, error) {
+	name := path.Join(dir, child)
+	if isWhiteout(child) {
+		return nil, nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+	}
+	if len(roots) == 1 {
+		info, err := iofs.Stat(roots[0].fsys(), name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return roots, info, nil
+	}
+
+	var (
+		found []FileSystemRoot
+		info  iofs.FileInfo
+	)
+	for _, root := range roots {
+		fsys := root.fsys()
+		rootInfo, err := iofs.Stat(fsys, name)
+		if err == nil {
+			if info == nil {
+				info = rootInfo
+			} else if !rootInfo.IsDir() {
+				// directories hide files in lower roots
+				break
+			}
+			found = append(found, root)
+			if !info.IsDir() || exists(fsys, path.Join(name, opaqueWhiteout)) {
+				break
+			}
+		} else if !os.IsNotExist(err) {
+			if info == nil {
+				return nil, nil, err
+			}
+			break
+		}
+		if exists(fsys, path.Join(dir, whiteoutPrefix+child)) {
+			break
+		}
+	}
+	if info == nil {
+		return nil, nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+	}
+	return found, info, nil
+}
+
+// readDirNames returns sorted names of entries in a directory, merged
+// across roots. Whiteout files and entries hidden by them are excluded.
+func readDirNames(roots []FileSystemRoot, name string) ([]string, error) {
+	if len(roots) == 1 {
+		entries, err := iofs.ReadDir(roots[0].fsys(), name)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if !isWhiteout(entry.Name()) {
+				names = append(names, entry.Name())
+			}
+		}
+		return names, nil
+	}
+
+	var names []string
+	// entries already listed or hidden by a whiteout
+	skip := make(map[string]bool)
+	for i, root := range roots {
+		entries, err := iofs.ReadDir(root.fsys(), name)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			log.Printf("%v", err)
+			continue
+		}
+		var whiteouts []string
+		for _, entry := range entries {
+			entryName := entry.Name()
+			if hidden, ok := strings.CutPrefix(entryName, whiteoutPrefix); ok {
+				whiteouts = append(whiteouts, hidden)
+				continue
+			}
+			if skip[entryName] {
+				continue
+			}
+			skip[entryName] = true
+			names = append(names, entryName)
+		}
+		// whiteouts only apply to lower roots
+		for _, hidden := range whiteouts {
+			skip[hidden] = true
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// isWhiteout returns whether a name is for a whiteout file.
+func isWhiteout(name string) bool {
+	return strings.HasPrefix(name, whiteoutPrefix)
+}
+
+// exists returns whether a file exists in the fs.FS.
+func exists(fsys iofs.FS, name string) bool {
+	_, err := iofs.Stat(fsys, name)
+	return err == nil
+}
@@ -0,0 +1,229 @@
+package server_test
+
+import (
+	"archive/zip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestOverlay(t *testing.T) {
+	suite.Run(t, new(OverlayTestSuite))
+}
+
+type OverlayTestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	fs server.FileSystem
+}
+
+func (s *OverlayTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	upper := s.Mkdir("upper")
+	s.WriteFile("upper/index.html", "upper index")
+	s.Mkdir("upper/docs")
+	s.WriteFile("upper/docs/patched.html", "upper patched")
+
+	lower := s.Mkdir("lower")
+	s.WriteFile("lower/index.html", "lower index")
+	s.WriteFile("lower/base.txt", "lower base")
+	s.Mkdir("lower/docs")
+	s.WriteFile("lower/docs/patched.html", "lower patched")
+	s.WriteFile("lower/docs/guide.html", "lower guide")
+
+	s.fs = server.FileSystem{
+		Root:         upper,
+		Fallbacks:    []server.FileSystemRoot{{Dir: lower}},
+		ResolveHTML:  true,
+		HideDotFiles: true,
+	}
+}
+
+func (s *OverlayTestSuite) open(name string) string {
+	file, err := s.fs.Open(name)
+	s.Require().Nil(err, name)
+	return readFile(&s.Suite, file)
+}
+
+// Files are served from the first root containing them.
+func (s *OverlayTestSuite) TestOpen() {
+	s.Equal("upper index", s.open("/index.html"))
+	s.Equal("lower base", s.open("/base.txt"))
+	s.Equal("upper patched", s.open("/docs/patched.html"))
+	s.Equal("lower guide", s.open("/docs/guide.html"))
+}
+
+// Suffix lookup applies across roots.
+func (s *OverlayTestSuite) TestOpenResolveHTML() {
+	s.Equal("lower guide", s.open("/docs/guide"))
+}
+
+// OpenFile finds files in lower roots.
+func (s *OverlayTestSuite) TestOpenFile() {
+	file, err := s.fs.OpenFile("/docs/guide.html")
+	s.Nil(err)
+	s.Equal("lower guide", readFile(&s.Suite, file))
+	_, err = s.fs.OpenFile("/docs")
+	s.ErrorIs(err, os.ErrNotExist)
+}
+
+// Files from the OS filesystem report the path in the root they're from.
+func (s *OverlayTestSuite) TestAbsPath() {
+	file, err := s.fs.Open("/docs/guide.html")
+	s.Nil(err)
+	s.Equal(filepath.Join(s.TempDir, "lower", "docs", "guide.html"), file.AbsPath())
+}
+
+// Directory entries are merged across roots.
+func (s *OverlayTestSuite) TestReaddir() {
+	file, err := s.fs.Open("/")
+	s.Nil(err)
+	s.Equal([]string{"base.txt", "docs", "index.html"}, fileList(&s.Suite, file))
+	file, err = s.fs.Open("/docs")
+	s.Nil(err)
+	s.Equal([]string{"guide.html", "patched.html"}, fileList(&s.Suite, file))
+}
+
+// Entries of merged directories are served from the first root containing
+// them.
+func (s *OverlayTestSuite) TestReaddirEntries() {
+	file, err := s.fs.Open("/docs")
+	s.Nil(err)
+	files, err := file.Readdir()
+	s.Nil(err)
+	s.Len(files, 2)
+	s.Equal("lower guide", readFile(&s.Suite, files[0]))
+	s.Equal("upper patched", readFile(&s.Suite, files[1]))
+}
+
+// Whiteout files hide files from lower roots.
+func (s *OverlayTestSuite) TestWhiteout() {
+	s.WriteFile("upper/.wh.base.txt", "")
+	s.WriteFile("upper/docs/.wh.guide.html", "")
+	_, err := s.fs.Open("/base.txt")
+	s.ErrorIs(err, os.ErrNotExist)
+	_, err = s.fs.Open("/docs/guide.html")
+	s.ErrorIs(err, os.ErrNotExist)
+
+	file, err := s.fs.Open("/docs")
+	s.Nil(err)
+	s.Equal([]string{"patched.html"}, fileList(&s.Suite, file))
+}
+
+// Whiteout files for directories hide their content.
+func (s *OverlayTestSuite) TestWhiteoutDirectory() {
+	s.RemoveAll("upper/docs")
+	s.WriteFile("upper/.wh.docs", "")
+	_, err := s.fs.Open("/docs/guide.html")
+	s.ErrorIs(err, os.ErrNotExist)
+	file, err := s.fs.Open("/")
+	s.Nil(err)
+	s.Equal([]string{"base.txt", "index.html"}, fileList(&s.Suite, file))
+}
+
+// Whiteout files are never served or listed, even if dotfiles are shown.
+func (s *OverlayTestSuite) TestWhiteoutHidden() {
+	s.WriteFile("upper/.wh.base.txt", "")
+	s.fs.HideDotFiles = false
+	_, err := s.fs.Open("/.wh.base.txt")
+	s.ErrorIs(err, os.ErrNotExist)
+	file, err := s.fs.Open("/")
+	s.Nil(err)
+	s.Equal([]string{"docs", "index.html"}, fileList(&s.Suite, file))
+}
+
+// Whiteout files are not served or listed with a single root either.
+func (s *OverlayTestSuite) TestWhiteoutHiddenSingleRoot() {
+	s.WriteFile("upper/.wh.base.txt", "")
+	s.WriteFile("upper/docs/.wh..wh..opq", "")
+	s.fs.Fallbacks = nil
+	s.fs.HideDotFiles = false
+	for _, name := range []string{"/.wh.base.txt", "/docs/.wh..wh..opq"} {
+		_, err := s.fs.Open(name)
+		s.ErrorIs(err, os.ErrNotExist, name)
+	}
+	file, err := s.fs.Open("/")
+	s.Nil(err)
+	s.Equal([]string{"docs", "index.html"}, fileList(&s.Suite, file))
+	file, err = s.fs.Open("/docs/")
+	s.Nil(err)
+	s.Equal([]string{"patched.html"}, fileList(&s.Suite, file))
+}
+
+// Opaque whiteouts hide the whole content of directories in lower roots.
+func (s *OverlayTestSuite) TestOpaqueWhiteout() {
+	s.WriteFile("upper/docs/.wh..wh..opq", "")
+	_, err := s.fs.Open("/docs/guide.html")
+	s.ErrorIs(err, os.ErrNotExist)
+	file, err := s.fs.Open("/docs")
+	s.Nil(err)
+	s.Equal([]string{"patched.html"}, fileList(&s.Suite, file))
+}
+
+// Files in upper roots hide directories with the same name in lower roots.
+func (s *OverlayTestSuite) TestFileHidesDirectory() {
+	s.RemoveAll("upper/docs")
+	s.WriteFile("upper/docs", "upper docs")
+	s.Equal("upper docs", s.open("/docs"))
+	_, err := s.fs.Open("/docs/guide.html")
+	s.ErrorIs(err, os.ErrNotExist)
+}
+
+// Roots can be any fs.FS.
+func (s *OverlayTestSuite) TestFSRoots() {
+	s.fs.Fallbacks = append(s.fs.Fallbacks, server.FileSystemRoot{
+		FS: fstest.MapFS{
+			"docs/extra.html": {Data: []byte("extra")},
+			"base.txt":        {Data: []byte("hidden by lower")},
+		},
+	})
+	s.Equal("extra", s.open("/docs/extra"))
+	s.Equal("lower base", s.open("/base.txt"))
+	file, err := s.fs.Open("/docs")
+	s.Nil(err)
+	s.Equal([]string{"extra.html", "guide.html", "patched.html"}, fileList(&s.Suite, file))
+}
+
+// Directories are listed with merged content.
+func (s *OverlayTestSuite) TestHandlerListing() {
+	handler := server.NewFileHandler(s.fs, true, "")
+	r := httptest.NewRequest("GET", "/docs/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusOK, w.Code)
+	s.Contains(w.Body.String(), `href="guide.html"`)
+	s.Contains(w.Body.String(), `href="patched.html"`)
+}
+
+// Fallback directories and archives are configured for the server.
+func (s *OverlayTestSuite) TestServerFallbackDirs() {
+	archive := s.WriteZip("base.zip", map[string]string{"archived.txt": "archived"}, zip.Deflate)
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:          s.fs.Root,
+		FallbackDirs: []string{s.fs.Fallbacks[0].Dir, archive},
+	})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(err)
+	defer serv.Shutdown(context.Background())
+	for path, content := range map[string]string{
+		"/":             "upper index",
+		"/base.txt":     "lower base",
+		"/archived.txt": "archived",
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		s.Equal(http.StatusOK, w.Code, path)
+		s.Equal(content, w.Body.String(), path)
+	}
+}
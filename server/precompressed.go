@@ -0,0 +1,145 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// precompressedEncodings lists content encodings for precompressed files,
+// along with the suffix of their files, in order of preference.
+var precompressedEncodings = []struct {
+	encoding string
+	suffix   string
+}{
+	{encoding: "br", suffix: ".br"},
+	{encoding: "zstd", suffix: ".zst"},
+	{encoding: "gzip", suffix: ".gz"},
+}
+
+// precompressedOriginal returns the name of the file a precompressed file is
+// a variant of, or an empty string if the name doesn't have a suffix for
+// precompressed files.
+func precompressedOriginal(name string) string {
+	for _, p := range precompressedEncodings {
+		if original, found := strings.CutSuffix(name, p.suffix); found && original != "" {
+			return original
+		}
+	}
+	return ""
+}
+
+// acceptedEncodings parses the Accept-Encoding header, returning the quality
+// value for each of the listed encodings.
+func acceptedEncodings(header string) map[string]float64 {
+	encodings := map[string]float64{}
+	for _, item := range strings.Split(header, ",") {
+		encoding, params, _ := strings.Cut(item, ";")
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			quality = q
+		}
+		encodings[encoding] = quality
+	}
+	return encodings
+}
+
+// encodingQuality returns the quality value for an encoding from the
+// accepted ones. Encodings not explicitly listed get the value for "*", if
+// present.
+func encodingQuality(accepted map[string]float64, encoding string) float64 {
+	if q, ok := accepted[encoding]; ok {
+		return q
+	}
+	return accepted["*"]
+}
+
+// preferredEncoding returns the encoding with the highest quality value from
+// the accepted ones. Encodings are in order of preference, so a later one is
+// only picked if the client explicitly prefers it. If none is accepted, an
+// empty string is returned.
+func preferredEncoding(accepted map[string]float64, encodings []string) string {
+	var (
+		preferred string
+		quality   float64
+	)
+	for _, encoding := range encodings {
+		if q := encodingQuality(accepted, encoding); q > quality {
+			preferred, quality = encoding, q
+		}
+	}
+	return preferred
+}
+
+// addVaryAcceptEncoding marks a response as varying based on the
+// Accept-Encoding header of the request.
+func addVaryAcceptEncoding(header http.Header) {
+	for _, value := range header.Values("Vary") {
+		if strings.EqualFold(value, "Accept-Encoding") {
+			return
+		}
+	}
+	header.Add("Vary", "Accept-Encoding")
+}
+
+// findPrecompressed returns the precompressed variant of the file at the
+// specified path to serve for a request, along with its content encoding.
+// If the request doesn't accept any of the encodings for existing variants,
+// a nil File is returned.
+//
+// If any variant exists, the response is marked as varying based on the
+// Accept-Encoding header.
+func (f FileHandler) findPrecompressed(w http.ResponseWriter, r *http.Request, filePath string) (*File, string) {
+	if precompressedOriginal(filePath) != "" {
+		// don't look up variants of precompressed files
+		return nil, ""
+	}
+	variants := map[string]*File{}
+	var encodings []string
+	for _, p := range precompressedEncodings {
+		if file, err := f.FileSystem.OpenFile(filePath + p.suffix); err == nil {
+			variants[p.encoding] = file
+			encodings = append(encodings, p.encoding)
+		}
+	}
+	if len(encodings) == 0 {
+		return nil, ""
+	}
+	addVaryAcceptEncoding(w.Header())
+	encoding := preferredEncoding(acceptedEncodings(r.Header.Get("Accept-Encoding")), encodings)
+	return variants[encoding], encoding
+}
+
+// contentType returns the content type for a File, based on its extension
+// or, if not known, on its content.
+func contentType(file *File) (string, error) {
+	if ctype := mime.TypeByExtension(path.Ext(file.Info.Name())); ctype != "" {
+		return ctype, nil
+	}
+	content, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+	var buf [512]byte
+	n, err := io.ReadFull(content, buf[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
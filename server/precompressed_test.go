@@ -0,0 +1,217 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestPrecompressed(t *testing.T) {
+	suite.Run(t, new(PrecompressedTestSuite))
+}
+
+type PrecompressedTestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	fileSystem server.FileSystem
+	handler    *server.FileHandler
+}
+
+func (s *PrecompressedTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	s.fileSystem = server.FileSystem{
+		Root:         s.TempDir,
+		ResolveHTML:  true,
+		HideDotFiles: true,
+	}
+	s.handler = server.NewFileHandler(s.fileSystem, true, "")
+	s.WriteFile("app.js", "plain content")
+	s.WriteFile("app.js.br", "brotli content")
+	s.WriteFile("app.js.zst", "zstd content")
+	s.WriteFile("app.js.gz", "gzip content")
+}
+
+func (s *PrecompressedTestSuite) get(path, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+// The Accept-Encoding header is parsed with quality values.
+func (s *PrecompressedTestSuite) TestAcceptedEncodings() {
+	s.Equal(
+		map[string]float64{"gzip": 1, "br": 0.5, "zstd": 0, "*": 0.1},
+		server.AcceptedEncodings("gzip, BR;q=0.5, zstd;q=0,*;q=0.1"))
+	s.Equal(map[string]float64{"gzip": 0}, server.AcceptedEncodings("gzip;q=invalid"))
+	s.Equal(map[string]float64{}, server.AcceptedEncodings(""))
+}
+
+// The preferred precompressed variant accepted by the client is served,
+// with the content type of the original file.
+func (s *PrecompressedTestSuite) TestServePreferred() {
+	w := s.get("/app.js", "gzip, deflate, br, zstd")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("br", w.Header().Get("Content-Encoding"))
+	s.Equal("text/javascript; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal("Accept-Encoding", w.Header().Get("Vary"))
+	s.Equal("brotli content", w.Body.String())
+}
+
+// Quality values in the Accept-Encoding header are honored.
+func (s *PrecompressedTestSuite) TestServeQuality() {
+	for acceptEncoding, content := range map[string]string{
+		"gzip":                       "gzip content",
+		"zstd, gzip":                 "zstd content",
+		"br;q=0.5, gzip":             "gzip content",
+		"br;q=0, zstd;q=0, *":        "gzip content",
+		"*":                          "brotli content",
+		"br;q=0, zstd;q=0, gzip;q=0": "plain content",
+		"identity":                   "plain content",
+	} {
+		w := s.get("/app.js", acceptEncoding)
+		s.Equal(http.StatusOK, w.Code, acceptEncoding)
+		s.Equal(content, w.Body.String(), acceptEncoding)
+		s.Equal("Accept-Encoding", w.Header().Get("Vary"), acceptEncoding)
+	}
+}
+
+// Without Accept-Encoding, the original file is served.
+func (s *PrecompressedTestSuite) TestServeNoAcceptEncoding() {
+	w := s.get("/app.js", "")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("", w.Header().Get("Content-Encoding"))
+	s.Equal("Accept-Encoding", w.Header().Get("Vary"))
+	s.Equal("plain content", w.Body.String())
+}
+
+// If no precompressed variants exist, the response doesn't vary.
+func (s *PrecompressedTestSuite) TestServeNoVariants() {
+	s.WriteFile("style.css", "body {}")
+	w := s.get("/style.css", "gzip, br")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("", w.Header().Get("Content-Encoding"))
+	s.Equal("", w.Header().Get("Vary"))
+	s.Equal("body {}", w.Body.String())
+}
+
+// Precompressed files requested directly are served as they are.
+func (s *PrecompressedTestSuite) TestServeVariantDirectly() {
+	w := s.get("/app.js.gz", "gzip, br")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("", w.Header().Get("Content-Encoding"))
+	s.Equal("", w.Header().Get("Vary"))
+	s.Equal("gzip content", w.Body.String())
+}
+
+// Ranges apply to the precompressed content.
+func (s *PrecompressedTestSuite) TestServeRange() {
+	r := httptest.NewRequest("GET", "/app.js", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=0-3")
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	s.Equal(http.StatusPartialContent, w.Code)
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal("bytes 0-3/12", w.Header().Get("Content-Range"))
+	s.Equal("gzip", w.Body.String())
+}
+
+// Responses for unsatisfiable ranges are not marked as encoded.
+func (s *PrecompressedTestSuite) TestServeRangeNotSatisfiable() {
+	r := httptest.NewRequest("GET", "/app.js", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=100-200")
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	s.Equal(http.StatusRequestedRangeNotSatisfiable, w.Code)
+	s.Equal("", w.Header().Get("Content-Encoding"))
+}
+
+// Precompressed variants of index files are served for directories.
+func (s *PrecompressedTestSuite) TestServeIndex() {
+	s.Mkdir("docs")
+	s.WriteFile("docs/index.html", "index")
+	s.WriteFile("docs/index.html.gz", "gzip index")
+	w := s.get("/docs/", "gzip")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal("gzip index", w.Body.String())
+}
+
+// Precompressed variants are served for files found with suffix lookup.
+func (s *PrecompressedTestSuite) TestServeResolveHTML() {
+	s.WriteFile("page.html", "page")
+	s.WriteFile("page.html.br", "brotli page")
+	w := s.get("/page", "br")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("br", w.Header().Get("Content-Encoding"))
+	s.Equal("brotli page", w.Body.String())
+}
+
+// The content type for files with unknown extensions is detected from the
+// original content.
+func (s *PrecompressedTestSuite) TestServeDetectContentType() {
+	s.WriteFile("README", "some text")
+	s.WriteFile("README.gz", "\x1f\x8b\x08\x00")
+	w := s.get("/README", "gzip")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal("text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+}
+
+// HEAD requests report the headers of the precompressed variant.
+func (s *PrecompressedTestSuite) TestServeHEAD() {
+	r := httptest.NewRequest("HEAD", "/app.js", nil)
+	r.Header.Set("Accept-Encoding", "zstd")
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("zstd", w.Header().Get("Content-Encoding"))
+	s.Equal("", w.Body.String())
+}
+
+// Precompressed variants are served from an fs.FS.
+func (s *PrecompressedTestSuite) TestServeFromFS() {
+	s.handler = server.NewFileHandler(
+		server.FileSystem{
+			FS: fstest.MapFS{
+				"style.css":    {Data: []byte("body {}")},
+				"style.css.gz": {Data: []byte("gzip style")},
+			},
+		}, true, "")
+	w := s.get("/style.css", "gzip")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal("text/css; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal("gzip style", w.Body.String())
+}
+
+// Precompressed variants are listed by default.
+func (s *PrecompressedTestSuite) TestListing() {
+	file, err := s.fileSystem.Open("/")
+	s.Nil(err)
+	s.Equal(
+		[]string{"app.js", "app.js.br", "app.js.gz", "app.js.zst"},
+		fileList(&s.Suite, file))
+}
+
+// Precompressed variants can be hidden from listings, as long as the
+// original file is present.
+func (s *PrecompressedTestSuite) TestListingHidePrecompressed() {
+	s.WriteFile("data.tar.gz", "archive")
+	s.fileSystem.HidePrecompressed = true
+	file, err := s.fileSystem.Open("/")
+	s.Nil(err)
+	s.Equal([]string{"app.js", "data.tar.gz"}, fileList(&s.Suite, file))
+}
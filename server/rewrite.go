@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Actions for rewrite rules.
+const (
+	// Redirect with a 301 (Moved Permanently) status
+	RewriteActionPermanent = "permanent"
+	// Redirect with a 302 (Found) status
+	RewriteActionTemporary = "temporary"
+	// Serve the target path instead of the requested one
+	RewriteActionRewrite = "rewrite"
+)
+
+// MatchConfig holds a condition on a string, with one of exact, prefix or
+// regular expression matching.
+type MatchConfig struct {
+	// Value to match exactly
+	Exact string `json:"exact"`
+	// Prefix to match. The rest of the string is captured as a group.
+	Prefix string `json:"prefix"`
+	// Regular expression to match, possibly with capture groups
+	Regexp string `json:"regexp"`
+}
+
+// isSet returns whether any condition is set.
+func (c MatchConfig) isSet() bool {
+	return c.Exact != "" || c.Prefix != "" || c.Regexp != ""
+}
+
+// compile returns a regexp for the condition, or nil if none is set. If
+// ignoreCase is true, exact and prefix matches are case-insensitive.
+func (c MatchConfig) compile(ignoreCase bool) (*regexp.Regexp, error) {
+	set := 0
+	for _, value := range []string{c.Exact, c.Prefix, c.Regexp} {
+		if value != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, errors.New("only one of exact, prefix or regexp can be set")
+	}
+	flags := ""
+	if ignoreCase {
+		flags = "(?i)"
+	}
+	switch {
+	case c.Exact != "":
+		return regexp.MustCompile(flags + "^" + regexp.QuoteMeta(c.Exact) + "$"), nil
+	case c.Prefix != "":
+		return regexp.MustCompile(flags + "^" + regexp.QuoteMeta(c.Prefix) + "(.*)$"), nil
+	case c.Regexp != "":
+		return regexp.Compile(c.Regexp)
+	}
+	return nil, nil
+}
+
+// RewriteRuleConfig holds a rule to redirect or internally rewrite requests
+// matching path, host and query conditions.
+type RewriteRuleConfig struct {
+	// Action for matching requests ("permanent", "temporary" or "rewrite")
+	Action string `json:"action"`
+	// Condition on the request host name, without port
+	Host MatchConfig `json:"host"`
+	// Condition on the request path
+	Path MatchConfig `json:"path"`
+	// Condition on the request query string
+	Query MatchConfig `json:"query"`
+	// URL or path to redirect or rewrite to. "$1" or "${name}" are
+	// replaced with groups captured by conditions.
+	Target string `json:"target"`
+}
+
+// Validate raises an error if RewriteRuleConfig is invalid.
+func (c RewriteRuleConfig) Validate() error {
+	_, err := NewRewriteRule(c)
+	return err
+}
+
+// validateRewriteRules raises an error if any of the rewrite rules is
+// invalid.
+func validateRewriteRules(rules []RewriteRuleConfig) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return prefixConfigError(fmt.Sprintf("rewrite-rules[%d]", i), err)
+		}
+	}
+	return nil
+}
+
+// RewriteRule redirects or rewrites matching requests to a target.
+type RewriteRule struct {
+	action string
+	host   *regexp.Regexp
+	path   *regexp.Regexp
+	query  *regexp.Regexp
+	target string
+}
+
+// NewRewriteRule returns a RewriteRule from its config.
+func NewRewriteRule(config RewriteRuleConfig) (*RewriteRule, error) {
+	rule := &RewriteRule{action: config.Action, target: config.Target}
+	switch config.Action {
+	case RewriteActionPermanent, RewriteActionTemporary, RewriteActionRewrite:
+	default:
+		return nil, &ConfigError{
+			Key: "action",
+			Err: fmt.Errorf(
+				`invalid action (must be "%s", "%s" or "%s"): %s`,
+				RewriteActionPermanent, RewriteActionTemporary, RewriteActionRewrite, config.Action),
+		}
+	}
+	if !config.Host.isSet() && !config.Path.isSet() && !config.Query.isSet() {
+		return nil, &ConfigError{Key: "path", Err: errors.New("no conditions specified")}
+	}
+	for _, c := range []struct {
+		key    string
+		config MatchConfig
+		re     **regexp.Regexp
+	}{
+		{"host", config.Host, &rule.host},
+		{"path", config.Path, &rule.path},
+		{"query", config.Query, &rule.query},
+	} {
+		re, err := c.config.compile(c.key == "host")
+		if err != nil {
+			return nil, &ConfigError{Key: c.key, Err: err}
+		}
+		*c.re = re
+	}
+	if config.Target == "" {
+		return nil, &ConfigError{Key: "target", Err: errors.New("no target specified")}
+	}
+	if config.Action == RewriteActionRewrite && !strings.HasPrefix(config.Target, "/") {
+		return nil, &ConfigError{
+			Key: "target",
+			Err: fmt.Errorf("must be an absolute path for rewrites: %s", config.Target),
+		}
+	}
+	return rule, nil
+}
+
+// Action returns the action for the rule.
+func (r *RewriteRule) Action() string {
+	return r.action
+}
+
+// StatusCode returns the status code for redirects from the rule.
+func (r *RewriteRule) StatusCode() int {
+	if r.action == RewriteActionTemporary {
+		return http.StatusFound
+	}
+	return http.StatusMovedPermanently
+}
+
+// Match returns the target for a request with the specified host name, path
+// and query string, if the rule matches it.
+//
+// Groups captured by the path, host and query conditions are numbered in
+// this order. Since the path is matched unescaped, groups captured from it
+// are escaped in the target, for the path or the query string depending on
+// where they're used. If the target has no query string, the one from the
+// request is preserved.
+func (r *RewriteRule) Match(host, urlPath, query string) (string, bool) {
+	var groups []capturedGroup
+	named := map[string]capturedGroup{}
+	for _, c := range []struct {
+		re        *regexp.Regexp
+		value     string
+		unescaped bool
+	}{
+		{r.path, urlPath, true},
+		{r.host, host, false},
+		{r.query, query, false},
+	} {
+		if c.re == nil {
+			continue
+		}
+		match := c.re.FindStringSubmatch(c.value)
+		if match == nil {
+			return "", false
+		}
+		for _, value := range match[1:] {
+			groups = append(groups, capturedGroup{value: value, unescaped: c.unescaped})
+		}
+		for i, name := range c.re.SubexpNames() {
+			if name != "" {
+				named[name] = capturedGroup{value: match[i], unescaped: c.unescaped}
+			}
+		}
+	}
+	target := expandGroups(r.target, groups, named)
+	if query != "" && !strings.Contains(target, "?") {
+		target += "?" + query
+	}
+	return target, true
+}
+
+// escapePath escapes a path for use in a URL, preserving slashes.
+func escapePath(urlPath string) string {
+	return (&url.URL{Path: urlPath}).EscapedPath()
+}
+
+// capturedGroup is a group captured by a rule condition.
+type capturedGroup struct {
+	value string
+	// whether the value needs escaping in URLs
+	unescaped bool
+}
+
+var groupRegexp = regexp.MustCompile(`\$(\$|[0-9]+|\{[A-Za-z0-9_]+\})`)
+
+// expandGroups replaces references to captured groups in a target, either by
+// number ("$1" or "${1}") or by name ("${name}"). Unknown groups are
+// replaced with an empty string, and "$$" with a literal "$".
+//
+// Unescaped values are escaped for the part of the target they're in, either
+// the path or the query string.
+func expandGroups(target string, groups []capturedGroup, named map[string]capturedGroup) string {
+	targetPath, targetQuery, hasQuery := strings.Cut(target, "?")
+	expanded := expandGroupsIn(targetPath, groups, named, escapePath)
+	if hasQuery {
+		expanded += "?" + expandGroupsIn(targetQuery, groups, named, url.QueryEscape)
+	}
+	return expanded
+}
+
+// expandGroupsIn replaces references to captured groups in part of a target,
+// escaping unescaped values with the specified function.
+func expandGroupsIn(target string, groups []capturedGroup, named map[string]capturedGroup, escape func(string) string) string {
+	return groupRegexp.ReplaceAllStringFunc(
+		target,
+		func(ref string) string {
+			ref = strings.TrimSuffix(strings.TrimPrefix(ref[1:], "{"), "}")
+			if ref == "$" {
+				return "$"
+			}
+			var group capturedGroup
+			if n, err := strconv.Atoi(ref); err == nil {
+				if n > 0 && n <= len(groups) {
+					group = groups[n-1]
+				}
+			} else {
+				group = named[ref]
+			}
+			if group.unescaped {
+				return escape(group.value)
+			}
+			return group.value
+		})
+}
+
+// RewriteRules is a list of rewrite rules, evaluated in order.
+type RewriteRules []*RewriteRule
+
+// NewRewriteRules returns RewriteRules from their config.
+func NewRewriteRules(configs []RewriteRuleConfig) (RewriteRules, error) {
+	rules := make(RewriteRules, len(configs))
+	for i, config := range configs {
+		rule, err := NewRewriteRule(config)
+		if err != nil {
+			return nil, prefixConfigError(fmt.Sprintf("rewrite-rules[%d]", i), err)
+		}
+		rules[i] = rule
+	}
+	return rules, nil
+}
+
+// Match returns the index of the first rule matching a request with the
+// specified host name, path and query string, along with its target. If no
+// rule matches, the index is -1.
+func (r RewriteRules) Match(host, urlPath, query string) (int, string) {
+	for i, rule := range r {
+		if target, ok := rule.Match(host, urlPath, query); ok {
+			return i, target
+		}
+	}
+	return -1, ""
+}
+
+// rewrittenKey is the context key marking requests rewritten by rules.
+type rewrittenKey struct{}
+
+// isRewritten returns whether a request was rewritten by a rule, so that
+// content for the target path is served at the requested URL.
+func isRewritten(r *http.Request) bool {
+	rewritten, _ := r.Context().Value(rewrittenKey{}).(bool)
+	return rewritten
+}
+
+// RewriteRulesHandler wraps an http.Handler, redirecting or rewriting
+// requests according to rules. Requests not matching any rule are passed to
+// the wrapped handler unchanged.
+//
+// Rewritten requests are marked in their context, so that content for the
+// target path is served without redirects.
+type RewriteRulesHandler struct {
+	http.Handler
+
+	Rules RewriteRules
+	// Prefix added to local redirect targets, if the request path prefix is
+	// stripped before rules are applied
+	PathPrefix string
+}
+
+// ServeHTTP applies the first matching rule to the request. Rules are matched
+// against the cleaned request path.
+func (h RewriteRulesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i, target := h.Rules.Match(requestHostName(r), cleanURLPath(r.URL.Path), r.URL.RawQuery)
+	if i < 0 {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	rule := h.Rules[i]
+	if rule.Action() != RewriteActionRewrite {
+		if isLocalTarget(target) {
+			target = h.PathPrefix + target
+		}
+		http.Redirect(w, r, target, rule.StatusCode())
+		return
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	// rules are not evaluated again for the rewritten request
+	rewritten := r.Clone(context.WithValue(r.Context(), rewrittenKey{}, true))
+	rewritten.URL.Path = targetURL.Path
+	rewritten.URL.RawPath = targetURL.RawPath
+	rewritten.URL.RawQuery = targetURL.RawQuery
+	rewritten.RequestURI = rewritten.URL.RequestURI()
+	h.Handler.ServeHTTP(w, rewritten)
+}
+
+// isLocalTarget returns whether a redirect target is a path on the server.
+func isLocalTarget(target string) bool {
+	return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//")
+}
+
+// RewriteRuleMatch describes the rule matching a URL.
+type RewriteRuleMatch struct {
+	// Index of the rule in the config
+	Index int
+	// Action for the rule
+	Action string
+	// Target with captured groups replaced
+	Target string
+}
+
+// MatchRewriteRules returns the rewrite rule matching a URL, as it would be
+// applied to a request for it, or nil if no rule matches. The URL can be a
+// path or include the host name.
+//
+// If a request path prefix is set, rules are matched against the path
+// without it, and it's added to local redirect targets.
+func (c StaticServerConfig) MatchRewriteRules(rawURL string) (*RewriteRuleMatch, error) {
+	rules, err := NewRewriteRules(c.RewriteRules)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	urlPath := u.Path
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+	if !strings.HasPrefix(urlPath, c.RequestPathPrefix) {
+		// not served
+		return nil, nil
+	}
+	urlPath = cleanURLPath(strings.TrimPrefix(urlPath, c.RequestPathPrefix))
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	i, target := rules.Match(host, urlPath, u.RawQuery)
+	if i < 0 {
+		return nil, nil
+	}
+	action := rules[i].Action()
+	if action != RewriteActionRewrite && isLocalTarget(target) {
+		target = c.RequestPathPrefix + target
+	}
+	return &RewriteRuleMatch{Index: i, Action: action, Target: target}, nil
+}
@@ -0,0 +1,415 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+)
+
+func TestRewriteRule(t *testing.T) {
+	suite.Run(t, new(RewriteRuleTestSuite))
+}
+
+type RewriteRuleTestSuite struct {
+	suite.Suite
+}
+
+func (s *RewriteRuleTestSuite) rule(config server.RewriteRuleConfig) *server.RewriteRule {
+	if config.Action == "" {
+		config.Action = server.RewriteActionPermanent
+	}
+	rule, err := server.NewRewriteRule(config)
+	s.Require().Nil(err)
+	return rule
+}
+
+// Exact conditions match the whole value.
+func (s *RewriteRuleTestSuite) TestMatchExact() {
+	rule := s.rule(server.RewriteRuleConfig{
+		Path:   server.MatchConfig{Exact: "/old.html"},
+		Target: "/new.html",
+	})
+	target, ok := rule.Match("example.com", "/old.html", "")
+	s.True(ok)
+	s.Equal("/new.html", target)
+	_, ok = rule.Match("example.com", "/old.html/more", "")
+	s.False(ok)
+	_, ok = rule.Match("example.com", "/docs/old.html", "")
+	s.False(ok)
+}
+
+// Prefix conditions capture the rest of the value.
+func (s *RewriteRuleTestSuite) TestMatchPrefix() {
+	rule := s.rule(server.RewriteRuleConfig{
+		Path:   server.MatchConfig{Prefix: "/old-docs/"},
+		Target: "/docs/$1",
+	})
+	target, ok := rule.Match("example.com", "/old-docs/guide/intro.html", "")
+	s.True(ok)
+	s.Equal("/docs/guide/intro.html", target)
+	_, ok = rule.Match("example.com", "/old-docs", "")
+	s.False(ok)
+}
+
+// Regexp conditions capture groups by number and name.
+func (s *RewriteRuleTestSuite) TestMatchRegexp() {
+	rule := s.rule(server.RewriteRuleConfig{
+		Path:   server.MatchConfig{Regexp: `^/blog/(\d{4})/(?P<slug>[^/]+)$`},
+		Target: "/posts/${slug}-$1.html",
+	})
+	target, ok := rule.Match("example.com", "/blog/2024/hello", "")
+	s.True(ok)
+	s.Equal("/posts/hello-2024.html", target)
+	_, ok = rule.Match("example.com", "/blog/latest/hello", "")
+	s.False(ok)
+}
+
+// Groups captured from the path are escaped in the target.
+func (s *RewriteRuleTestSuite) TestMatchEscapePathGroups() {
+	rule := s.rule(server.RewriteRuleConfig{
+		Path:   server.MatchConfig{Prefix: "/old/"},
+		Target: "/new/$1",
+	})
+	target, ok := rule.Match("example.com", "/old/a?b/c", "")
+	s.True(ok)
+	s.Equal("/new/a%3Fb/c", target)
+	target, ok = rule.Match("example.com", "/old/%25", "")
+	s.True(ok)
+	s.Equal("/new/%2525", target)
+}
+
+// Groups captured from the path are escaped for the query string if used
+// there.
+func (s *RewriteRuleTestSuite) TestMatchEscapePathGroupsInQuery() {
+	rule := s.rule(server.RewriteRuleConfig{
+		Path:   server.MatchConfig{Regexp: `^/app/(?P<route>.*)$`},
+		Target: "/app/$1/index.html?route=${route}",
+	})
+	target, ok := rule.Match("example.com", "/app/a&admin=1", "")
+	s.True(ok)
+	s.Equal("/app/a&admin=1/index.html?route=a%26admin%3D1", target)
+}
+
+// Conditions on the host are case-insensitive for exact and prefix matches.
+func (s *RewriteRuleTestSuite) TestMatchHost() {
+	rule := s.rule(server.RewriteRuleConfig{
+		Host:   server.MatchConfig{Exact: "Old.Example.com"},
+		Target: "https://new.example.com/",
+	})
+	_, ok := rule.Match("old.example.com", "/any", "")
+	s.True(ok)
+	_, ok = rule.Match("new.example.com", "/any", "")
+	s.False(ok)
+}
+
+// Groups from all conditions are numbered in order of path, host and query.
+func (s *RewriteRuleTestSuite) TestMatchGroupsOrder() {
+	rule := s.rule(server.RewriteRuleConfig{
+		Path:   server.MatchConfig{Prefix: "/docs/"},
+		Host:   server.MatchConfig{Regexp: `^([a-z]+)\.example\.com$`},
+		Query:  server.MatchConfig{Regexp: `(?:^|&)v=(?P<version>[0-9.]+)`},
+		Target: "https://docs.example.com/$2/${version}/$1?ref=$$",
+	})
+	target, ok := rule.Match("app.example.com", "/docs/intro", "lang=en&v=1.2")
+	s.True(ok)
+	s.Equal("https://docs.example.com/app/1.2/intro?ref=$", target)
+	_, ok = rule.Match("app.example.com", "/docs/intro", "lang=en")
+	s.False(ok)
+}
+
+// The query string of the request is preserved if the target has none.
+func (s *RewriteRuleTestSuite) TestMatchPreserveQuery() {
+	rule := s.rule(server.RewriteRuleConfig{
+		Path:   server.MatchConfig{Exact: "/search"},
+		Target: "/find",
+	})
+	target, ok := rule.Match("example.com", "/search", "q=foo")
+	s.True(ok)
+	s.Equal("/find?q=foo", target)
+
+	rule = s.rule(server.RewriteRuleConfig{
+		Path:   server.MatchConfig{Exact: "/search"},
+		Target: "/find?all=1",
+	})
+	target, ok = rule.Match("example.com", "/search", "q=foo")
+	s.True(ok)
+	s.Equal("/find?all=1", target)
+}
+
+// Redirect status codes depend on the action.
+func (s *RewriteRuleTestSuite) TestStatusCode() {
+	for action, code := range map[string]int{
+		server.RewriteActionPermanent: http.StatusMovedPermanently,
+		server.RewriteActionTemporary: http.StatusFound,
+	} {
+		rule := s.rule(server.RewriteRuleConfig{
+			Action: action,
+			Path:   server.MatchConfig{Exact: "/"},
+			Target: "/new",
+		})
+		s.Equal(code, rule.StatusCode())
+	}
+}
+
+// Invalid rules are reported with the key for the invalid option.
+func (s *RewriteRuleTestSuite) TestNewRewriteRuleInvalid() {
+	for errMessage, config := range map[string]server.RewriteRuleConfig{
+		`action: invalid action (must be "permanent", "temporary" or "rewrite"): move`: {
+			Action: "move",
+			Path:   server.MatchConfig{Exact: "/"},
+			Target: "/new",
+		},
+		"path: no conditions specified": {
+			Action: server.RewriteActionPermanent,
+			Target: "/new",
+		},
+		"path: only one of exact, prefix or regexp can be set": {
+			Action: server.RewriteActionPermanent,
+			Path:   server.MatchConfig{Exact: "/", Prefix: "/"},
+			Target: "/new",
+		},
+		"query: error parsing regexp: missing closing ): `(`": {
+			Action: server.RewriteActionPermanent,
+			Query:  server.MatchConfig{Regexp: "("},
+			Target: "/new",
+		},
+		"target: no target specified": {
+			Action: server.RewriteActionPermanent,
+			Path:   server.MatchConfig{Exact: "/"},
+		},
+		"target: must be an absolute path for rewrites: https://example.com/": {
+			Action: server.RewriteActionRewrite,
+			Path:   server.MatchConfig{Exact: "/"},
+			Target: "https://example.com/",
+		},
+	} {
+		_, err := server.NewRewriteRule(config)
+		s.EqualError(err, errMessage)
+	}
+}
+
+func TestRewriteRulesHandler(t *testing.T) {
+	suite.Run(t, new(RewriteRulesHandlerTestSuite))
+}
+
+type RewriteRulesHandlerTestSuite struct {
+	suite.Suite
+
+	handler http.Handler
+}
+
+func (s *RewriteRulesHandlerTestSuite) SetupTest() {
+	rules, err := server.NewRewriteRules([]server.RewriteRuleConfig{
+		{
+			Action: server.RewriteActionPermanent,
+			Path:   server.MatchConfig{Prefix: "/old/"},
+			Target: "/new/$1",
+		},
+		{
+			Action: server.RewriteActionTemporary,
+			Host:   server.MatchConfig{Exact: "www.example.com"},
+			Target: "https://example.com/",
+		},
+		{
+			Action: server.RewriteActionRewrite,
+			Path:   server.MatchConfig{Regexp: `^/app(/.*)?$`},
+			Target: "/index.html?route=$1",
+		},
+		{
+			Action: server.RewriteActionRewrite,
+			Path:   server.MatchConfig{Prefix: "/static/"},
+			Target: "/assets/$1",
+		},
+	})
+	s.Require().Nil(err)
+	s.handler = &server.RewriteRulesHandler{
+		Handler: http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.URL.RequestURI()))
+			}),
+		Rules: rules,
+	}
+}
+
+func (s *RewriteRulesHandlerTestSuite) request(url string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+// Requests are redirected for redirect actions.
+func (s *RewriteRulesHandlerTestSuite) TestRedirect() {
+	w := s.request("http://example.com/old/page.html?lang=en")
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("/new/page.html?lang=en", w.Header().Get("Location"))
+
+	w = s.request("http://www.example.com/page.html")
+	s.Equal(http.StatusFound, w.Code)
+	s.Equal("https://example.com/", w.Header().Get("Location"))
+}
+
+// Rules are matched against the cleaned request path.
+func (s *RewriteRulesHandlerTestSuite) TestRedirectCleanPath() {
+	for _, url := range []string{
+		"http://example.com//old/page.html",
+		"http://example.com/a/../old/page.html",
+	} {
+		w := s.request(url)
+		s.Equal(http.StatusMovedPermanently, w.Code, url)
+		s.Equal("/new/page.html", w.Header().Get("Location"), url)
+	}
+}
+
+// Escaped characters in the request path are preserved in redirects.
+func (s *RewriteRulesHandlerTestSuite) TestRedirectEscapedPath() {
+	w := s.request("http://example.com/old/a%3Fb")
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("/new/a%3Fb", w.Header().Get("Location"))
+}
+
+// Requests are passed to the wrapped handler with the target path for
+// rewrite actions.
+func (s *RewriteRulesHandlerTestSuite) TestRewrite() {
+	w := s.request("http://example.com/app/settings")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("/index.html?route=%2Fsettings", w.Body.String())
+}
+
+// Rewritten requests are marked as such.
+func (s *RewriteRulesHandlerTestSuite) TestRewriteMarked() {
+	handler := s.handler.(*server.RewriteRulesHandler)
+	var rewritten bool
+	handler.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			rewritten = server.IsRewritten(r)
+		})
+	s.request("http://example.com/app/settings")
+	s.True(rewritten)
+	s.request("http://example.com/other")
+	s.False(rewritten)
+}
+
+// The path prefix is added to local redirect targets.
+func (s *RewriteRulesHandlerTestSuite) TestRedirectPathPrefix() {
+	s.handler.(*server.RewriteRulesHandler).PathPrefix = "/prefix"
+	w := s.request("http://example.com/old/page.html")
+	s.Equal("/prefix/new/page.html", w.Header().Get("Location"))
+	w = s.request("http://www.example.com/page.html")
+	s.Equal("https://example.com/", w.Header().Get("Location"))
+}
+
+// Escaped characters in the request path are not decoded again in the
+// rewritten path.
+func (s *RewriteRulesHandlerTestSuite) TestRewriteEscapedPath() {
+	w := s.request("http://example.com/static/a%3Fb")
+	s.Equal("/assets/a%3Fb", w.Body.String())
+	w = s.request("http://example.com/static/%2525")
+	s.Equal("/assets/%2525", w.Body.String())
+}
+
+// Requests not matching any rule are passed to the wrapped handler
+// unchanged.
+func (s *RewriteRulesHandlerTestSuite) TestNoMatch() {
+	w := s.request("http://example.com/other?x=1")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("/other?x=1", w.Body.String())
+}
+
+func TestMatchRewriteRules(t *testing.T) {
+	suite.Run(t, new(MatchRewriteRulesTestSuite))
+}
+
+type MatchRewriteRulesTestSuite struct {
+	suite.Suite
+
+	config server.StaticServerConfig
+}
+
+func (s *MatchRewriteRulesTestSuite) SetupTest() {
+	s.config = server.StaticServerConfig{
+		RewriteRules: []server.RewriteRuleConfig{
+			{
+				Action: server.RewriteActionPermanent,
+				Host:   server.MatchConfig{Exact: "old.example.com"},
+				Target: "https://example.com/",
+			},
+			{
+				Action: server.RewriteActionRewrite,
+				Path:   server.MatchConfig{Prefix: "/docs/"},
+				Target: "/documentation/$1",
+			},
+		},
+	}
+}
+
+// The first rule matching a URL is returned, along with its target.
+func (s *MatchRewriteRulesTestSuite) TestMatch() {
+	match, err := s.config.MatchRewriteRules("https://Old.Example.com./docs/intro")
+	s.Nil(err)
+	s.Equal(
+		&server.RewriteRuleMatch{
+			Index:  0,
+			Action: server.RewriteActionPermanent,
+			Target: "https://example.com/",
+		},
+		match)
+
+	match, err = s.config.MatchRewriteRules("/docs/intro?v=2")
+	s.Nil(err)
+	s.Equal(
+		&server.RewriteRuleMatch{
+			Index:  1,
+			Action: server.RewriteActionRewrite,
+			Target: "/documentation/intro?v=2",
+		},
+		match)
+}
+
+// URL paths are cleaned before matching rules.
+func (s *MatchRewriteRulesTestSuite) TestMatchCleanPath() {
+	for _, url := range []string{"/docs/./intro", "/a/../docs/intro", "docs/intro"} {
+		match, err := s.config.MatchRewriteRules(url)
+		s.Nil(err, url)
+		s.Require().NotNil(match, url)
+		s.Equal("/documentation/intro", match.Target, url)
+	}
+}
+
+// With a request path prefix, rules match paths without it, and it's added to
+// local redirect targets.
+func (s *MatchRewriteRulesTestSuite) TestMatchPathPrefix() {
+	s.config.RequestPathPrefix = "/prefix"
+	s.config.RewriteRules = append(s.config.RewriteRules, server.RewriteRuleConfig{
+		Action: server.RewriteActionPermanent,
+		Path:   server.MatchConfig{Prefix: "/old/"},
+		Target: "/new/$1",
+	})
+	match, err := s.config.MatchRewriteRules("/prefix/docs/intro")
+	s.Nil(err)
+	s.Equal("/documentation/intro", match.Target)
+	match, err = s.config.MatchRewriteRules("/prefix/old/page")
+	s.Nil(err)
+	s.Equal("/prefix/new/page", match.Target)
+	match, err = s.config.MatchRewriteRules("/docs/intro")
+	s.Nil(err)
+	s.Nil(match)
+}
+
+// If no rule matches, nil is returned.
+func (s *MatchRewriteRulesTestSuite) TestNoMatch() {
+	match, err := s.config.MatchRewriteRules("/other")
+	s.Nil(err)
+	s.Nil(match)
+}
+
+// An error is returned if rules are invalid.
+func (s *MatchRewriteRulesTestSuite) TestInvalidRules() {
+	s.config.RewriteRules[1].Action = "move"
+	_, err := s.config.MatchRewriteRules("/docs/intro")
+	s.ErrorContains(err, "rewrite-rules[1].action: invalid action")
+}
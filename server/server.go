@@ -4,72 +4,512 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
 	"github.com/albertodonato/h2static/version"
 )
 
 // StaticServerConfig holds configuration options for a StaticServer.
+//
+// Field tags define the key names used in configuration files.
 type StaticServerConfig struct {
-	Addr                    string
-	AllowOutsideSymlinks    bool
-	CSS                     string
-	DebugAddr               string
-	Dir                     string
-	DisableH2               bool
-	DisableIndex            bool
-	DisableLookupWithSuffix bool
-	Log                     bool
-	PasswordFile            string
-	RequestPathPrefix       string
-	ShowDotFiles            bool
-	TLSCert                 string
-	TLSKey                  string
-}
-
-// Port returns the port from the config.
+	ACME ACMEConfig `json:"acme"`
+	// Addresses to listen on, sharing the same handler
+	Addr                 AddrList `json:"addr"`
+	AllowOutsideSymlinks bool     `json:"allow-outside-symlinks"`
+	BrowseArchives       bool     `json:"browse-archives"`
+	CSS                  string   `json:"css"`
+	// Whether to compress responses on the fly for clients accepting it
+	Compress bool `json:"compress"`
+	// Maximum size in bytes of compressed content cached in memory. If
+	// zero, the default is used.
+	CompressCacheSize int64 `json:"compress-cache-size"`
+	// Minimum size in bytes for content to be compressed. If zero, the
+	// default is used.
+	CompressMinSize         int64  `json:"compress-min-size"`
+	DebugAddr               string `json:"debug-addr"`
+	Dir                     string `json:"dir"`
+	DisableH2               bool   `json:"disable-h2"`
+	DisableIndex            bool   `json:"disable-index"`
+	DisableLookupWithSuffix bool   `json:"disable-lookup-with-suffix"`
+	// Whether to serve HTTP/2 over cleartext connections (h2c), both with
+	// prior knowledge and via Upgrade
+	EnableH2C bool `json:"enable-h2c"`
+	// Whether to serve HTTP/3 over QUIC on the same address, via UDP
+	EnableH3 bool `json:"enable-h3"`
+	// Directories or archives to serve files not found in Dir from, in
+	// order of priority
+	FallbackDirs []string `json:"fallback-dirs"`
+	// Rules to set or remove response headers, evaluated in order
+	HeaderRules []HeaderRuleConfig `json:"header-rules"`
+	// Whether to hide precompressed variants of files from listings
+	HidePrecompressed bool `json:"hide-precompressed"`
+	// Virtual hosts. Top-level options define the default host, used for
+	// requests not matching any of them.
+	Hosts []HostConfig `json:"hosts"`
+	// Whether the Strict-Transport-Security header applies to subdomains
+	HSTSIncludeSubDomains bool `json:"hsts-include-subdomains"`
+	// Max age in seconds for the Strict-Transport-Security header. If zero,
+	// the header is not sent.
+	HSTSMaxAge int `json:"hsts-max-age"`
+	// Whether to allow preloading of the Strict-Transport-Security policy
+	HSTSPreload bool `json:"hsts-preload"`
+	// Address and port to listen on for plain HTTP, redirecting to HTTPS
+	HTTPRedirectAddr string `json:"http-redirect-addr"`
+	// Status code for HTTP to HTTPS redirects (301 or 308)
+	HTTPRedirectCode int  `json:"http-redirect-code"`
+	Log              bool `json:"log"`
+	// Additional directories served under URL path prefixes
+	Mounts []MountConfig `json:"mounts"`
+	// Mode for Netlify-style _headers and _redirects files ("root" or
+	// "dirs"). If empty, they're not read.
+	NetlifyFiles      string `json:"netlify-files"`
+	PasswordFile      string `json:"basic-auth"`
+	RequestPathPrefix string `json:"request-path-prefix"`
+	// Rules to redirect or rewrite requests for all hosts, evaluated in
+	// order
+	RewriteRules []RewriteRuleConfig `json:"rewrite-rules"`
+	ShowDotFiles bool                `json:"show-dotfiles"`
+	// Maximum time to wait for active connections to complete on shutdown.
+	// If zero, the default is used.
+	ShutdownTimeout Duration `json:"shutdown-timeout"`
+	// File mode for Unix socket files, in octal notation (e.g. "0660")
+	SocketMode string `json:"socket-mode"`
+	// Owner for Unix socket files, in the "user[:group]" form
+	SocketOwner string `json:"socket-owner"`
+	TLSCert     string `json:"tls-cert"`
+	// Directory with additional certificate/key pairs, selected via SNI
+	TLSCertDir string `json:"tls-cert-dir"`
+	// Additional certificate/key pairs, selected via SNI
+	TLSCertificates []TLSKeyPairConfig `json:"tls-certificates"`
+	// Cipher suites names, overriding the ones from the profile
+	TLSCipherSuites []string `json:"tls-cipher-suites"`
+	// Path prefixes allowed for TLS client certificate subjects
+	TLSClientAccess []ClientAccessConfig `json:"tls-client-access"`
+	// Client certificates verification mode ("request" or "require")
+	TLSClientAuth string `json:"tls-client-auth"`
+	// CA certificates file to verify TLS client certificates
+	TLSClientCA string `json:"tls-client-ca"`
+	// Elliptic curves names, overriding the ones from the profile
+	TLSCurves []string `json:"tls-curves"`
+	TLSKey    string   `json:"tls-key"`
+	// Minimum TLS version, overriding the one from the profile
+	TLSMinVersion string `json:"tls-min-version"`
+	// TLS parameters profile ("modern", "intermediate" or "legacy")
+	TLSProfile string `json:"tls-profile"`
+}
+
+// TLSKeyPairConfig holds paths for a TLS certificate and key pair.
+type TLSKeyPairConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// Validate raises an error if TLSKeyPairConfig is invalid.
+func (c TLSKeyPairConfig) Validate() error {
+	if err := checkFile(c.Cert, false); err != nil {
+		return &ConfigError{Key: "cert", Err: err}
+	}
+	if err := checkFile(c.Key, false); err != nil {
+		return &ConfigError{Key: "key", Err: err}
+	}
+	return nil
+}
+
+// HostConfig holds configuration options for a virtual host.
+type HostConfig struct {
+	AllowOutsideSymlinks    bool   `json:"allow-outside-symlinks"`
+	BrowseArchives          bool   `json:"browse-archives"`
+	CSS                     string `json:"css"`
+	Dir                     string `json:"dir"`
+	DisableIndex            bool   `json:"disable-index"`
+	DisableLookupWithSuffix bool   `json:"disable-lookup-with-suffix"`
+	// Directories or archives to serve files not found in Dir from, in
+	// order of priority
+	FallbackDirs []string `json:"fallback-dirs"`
+	// Rules to set or remove response headers, evaluated in order
+	HeaderRules []HeaderRuleConfig `json:"header-rules"`
+	// Whether to hide precompressed variants of files from listings
+	HidePrecompressed bool `json:"hide-precompressed"`
+	// Additional directories served under URL path prefixes
+	Mounts []MountConfig `json:"mounts"`
+	// Host names to match. Names starting with "*." match any subdomain.
+	Names []string `json:"names"`
+	// Mode for Netlify-style _headers and _redirects files ("root" or
+	// "dirs"). If empty, they're not read.
+	NetlifyFiles string `json:"netlify-files"`
+	PasswordFile string `json:"basic-auth"`
+	ShowDotFiles bool   `json:"show-dotfiles"`
+}
+
+// Validate raises an error if HostConfig is invalid.
+func (c HostConfig) Validate() error {
+	if len(c.Names) == 0 {
+		return &ConfigError{Key: "names", Err: errors.New("no host names specified")}
+	}
+	if err := checkRoot(c.Dir); err != nil {
+		return &ConfigError{Key: "dir", Err: err}
+	}
+	if err := validateFallbackDirs(c.FallbackDirs); err != nil {
+		return err
+	}
+	if err := validateHeaderRules(c.HeaderRules); err != nil {
+		return err
+	}
+	if err := validateNetlifyFiles(c.NetlifyFiles); err != nil {
+		return err
+	}
+	if c.CSS != "" {
+		if err := checkFile(c.CSS, false); err != nil {
+			return &ConfigError{Key: "css", Err: err}
+		}
+	}
+	if c.PasswordFile != "" {
+		if err := checkFile(c.PasswordFile, false); err != nil {
+			return &ConfigError{Key: "basic-auth", Err: err}
+		}
+	}
+	return validateMounts(c.Mounts)
+}
+
+// MountConfig holds configuration options for a directory served under a
+// URL path prefix.
+type MountConfig struct {
+	AllowOutsideSymlinks    bool   `json:"allow-outside-symlinks"`
+	BrowseArchives          bool   `json:"browse-archives"`
+	Dir                     string `json:"dir"`
+	DisableIndex            bool   `json:"disable-index"`
+	DisableLookupWithSuffix bool   `json:"disable-lookup-with-suffix"`
+	// Directories or archives to serve files not found in Dir from, in
+	// order of priority
+	FallbackDirs []string `json:"fallback-dirs"`
+	// Whether to hide precompressed variants of files from listings
+	HidePrecompressed bool `json:"hide-precompressed"`
+	// Mode for Netlify-style _headers and _redirects files ("root" or
+	// "dirs"). If empty, they're not read.
+	NetlifyFiles string `json:"netlify-files"`
+	// URL path prefix for the mount (e.g. "/docs")
+	Prefix       string `json:"prefix"`
+	ShowDotFiles bool   `json:"show-dotfiles"`
+}
+
+// Validate raises an error if MountConfig is invalid.
+func (c MountConfig) Validate() error {
+	prefix := c.cleanPrefix()
+	if !strings.HasPrefix(c.Prefix, "/") || prefix == "" {
+		return &ConfigError{
+			Key: "prefix",
+			Err: fmt.Errorf("must be an absolute path other than /: %q", c.Prefix),
+		}
+	}
+	if strings.HasPrefix(prefix+"/", AssetsPrefix) {
+		return &ConfigError{
+			Key: "prefix",
+			Err: fmt.Errorf("conflicts with builtin assets prefix: %s", c.Prefix),
+		}
+	}
+	if err := checkRoot(c.Dir); err != nil {
+		return &ConfigError{Key: "dir", Err: err}
+	}
+	if err := validateFallbackDirs(c.FallbackDirs); err != nil {
+		return err
+	}
+	if err := validateNetlifyFiles(c.NetlifyFiles); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cleanPrefix returns the mount prefix without trailing slash.
+func (c MountConfig) cleanPrefix() string {
+	return strings.TrimSuffix(path.Clean("/"+c.Prefix), "/")
+}
+
+func validateFallbackDirs(dirs []string) error {
+	for i, dir := range dirs {
+		if err := checkRoot(dir); err != nil {
+			return &ConfigError{Key: fmt.Sprintf("fallback-dirs[%d]", i), Err: err}
+		}
+	}
+	return nil
+}
+
+func validateMounts(mounts []MountConfig) error {
+	prefixes := make(map[string]bool)
+	for i, mount := range mounts {
+		if err := mount.Validate(); err != nil {
+			return prefixConfigError(fmt.Sprintf("mounts[%d]", i), err)
+		}
+		prefix := mount.cleanPrefix()
+		if prefixes[prefix] {
+			return &ConfigError{
+				Key: fmt.Sprintf("mounts[%d].prefix", i),
+				Err: fmt.Errorf("duplicated mount prefix: %s", prefix),
+			}
+		}
+		prefixes[prefix] = true
+	}
+	return nil
+}
+
+// defaultHost returns the HostConfig for the default host.
+func (c StaticServerConfig) defaultHost() HostConfig {
+	return HostConfig{
+		AllowOutsideSymlinks:    c.AllowOutsideSymlinks,
+		BrowseArchives:          c.BrowseArchives,
+		CSS:                     c.CSS,
+		Dir:                     c.Dir,
+		DisableIndex:            c.DisableIndex,
+		DisableLookupWithSuffix: c.DisableLookupWithSuffix,
+		FallbackDirs:            c.FallbackDirs,
+		HeaderRules:             c.HeaderRules,
+		HidePrecompressed:       c.HidePrecompressed,
+		Mounts:                  c.Mounts,
+		NetlifyFiles:            c.NetlifyFiles,
+		PasswordFile:            c.PasswordFile,
+		ShowDotFiles:            c.ShowDotFiles,
+	}
+}
+
+// Port returns the port from the first TCP address in the config, or 0 if
+// there's none.
 func (c StaticServerConfig) Port() uint16 {
-	i := strings.LastIndex(c.Addr, ":")
-	n, err := strconv.ParseUint(c.Addr[i+1:], 10, 16)
-	if err != nil {
-		return 0
+	for _, addr := range c.Addr {
+		if !isTCPAddr(addr) {
+			continue
+		}
+		i := strings.LastIndex(addr, ":")
+		n, err := strconv.ParseUint(addr[i+1:], 10, 16)
+		if err != nil {
+			return 0
+		}
+		return uint16(n)
+	}
+	return 0
+}
+
+// addrs returns addresses to listen on. If none is set, the default HTTP
+// port is used.
+func (c StaticServerConfig) addrs() []string {
+	if len(c.Addr) == 0 {
+		return []string{":http"}
 	}
-	return uint16(n)
+	return c.Addr
+}
+
+// defaultShutdownTimeout is the default maximum time to wait for active
+// connections on shutdown.
+const defaultShutdownTimeout = 5 * time.Second
+
+// defaultCompressCacheSize is the default maximum size of compressed content
+// cached in memory.
+const defaultCompressCacheSize = 64 << 20
+
+// defaultCompressMinSize is the default minimum size for content to be
+// compressed.
+const defaultCompressMinSize = 1024
+
+// compressCacheSize returns the maximum size of compressed content cached in
+// memory.
+func (c StaticServerConfig) compressCacheSize() int64 {
+	if c.CompressCacheSize == 0 {
+		return defaultCompressCacheSize
+	}
+	return c.CompressCacheSize
+}
+
+// compressMinSize returns the minimum size for content to be compressed.
+func (c StaticServerConfig) compressMinSize() int64 {
+	if c.CompressMinSize == 0 {
+		return defaultCompressMinSize
+	}
+	return c.CompressMinSize
+}
+
+// shutdownTimeout returns the maximum time to wait for active connections on
+// shutdown.
+func (c StaticServerConfig) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout == 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(c.ShutdownTimeout)
+}
+
+// tcpPorts returns distinct ports for TCP addresses to listen on.
+func (c StaticServerConfig) tcpPorts() []int {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, addr := range c.tcpAddrs() {
+		_, portString, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(portString)
+		if err != nil || port == 0 || seen[port] {
+			continue
+		}
+		seen[port] = true
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// tcpAddrs returns TCP addresses to listen on.
+func (c StaticServerConfig) tcpAddrs() []string {
+	var addrs []string
+	for _, addr := range c.addrs() {
+		if isTCPAddr(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
 }
 
 // IsHTTPS returns whether HTTPS is enabled in the config.
 func (c StaticServerConfig) IsHTTPS() bool {
+	return c.hasTLSKeyPair() || len(c.TLSCertificates) > 0 || c.TLSCertDir != "" || c.ACME.Enabled()
+}
+
+// hasTLSKeyPair returns whether a certificate and key are set in the config.
+func (c StaticServerConfig) hasTLSKeyPair() bool {
 	return c.TLSCert != "" && c.TLSKey != ""
 }
 
 // Validate raises an error if StaticServerConfig is invalid.
+//
+// The returned error is a *ConfigError reporting the invalid option.
 func (c StaticServerConfig) Validate() error {
-	if err := checkFile(c.Dir, true); err != nil {
+	if err := checkRoot(c.Dir); err != nil {
+		return &ConfigError{Key: "dir", Err: err}
+	}
+	if err := validateFallbackDirs(c.FallbackDirs); err != nil {
+		return err
+	}
+	if err := validateHeaderRules(c.HeaderRules); err != nil {
+		return err
+	}
+	if err := validateNetlifyFiles(c.NetlifyFiles); err != nil {
+		return err
+	}
+	if err := validateRewriteRules(c.RewriteRules); err != nil {
 		return err
 	}
 	if c.CSS != "" {
 		if err := checkFile(c.CSS, false); err != nil {
-			return err
+			return &ConfigError{Key: "css", Err: err}
 		}
 	}
-	if c.IsHTTPS() {
-		for _, path := range []string{c.TLSCert, c.TLSKey} {
-			if err := checkFile(path, false); err != nil {
-				return err
-			}
+	if c.ACME.Enabled() {
+		if err := c.ACME.Validate(); err != nil {
+			return prefixConfigError("acme", err)
+		}
+	}
+	if c.hasTLSKeyPair() {
+		if err := checkFile(c.TLSCert, false); err != nil {
+			return &ConfigError{Key: "tls-cert", Err: err}
+		}
+		if err := checkFile(c.TLSKey, false); err != nil {
+			return &ConfigError{Key: "tls-key", Err: err}
+		}
+	}
+	for i, keyPair := range c.TLSCertificates {
+		if err := keyPair.Validate(); err != nil {
+			return prefixConfigError(fmt.Sprintf("tls-certificates[%d]", i), err)
+		}
+	}
+	if c.TLSCertDir != "" {
+		if err := checkFile(c.TLSCertDir, true); err != nil {
+			return &ConfigError{Key: "tls-cert-dir", Err: err}
+		}
+	}
+	if c.CompressCacheSize < 0 {
+		return &ConfigError{
+			Key: "compress-cache-size",
+			Err: fmt.Errorf("must not be negative: %d", c.CompressCacheSize),
+		}
+	}
+	if c.CompressMinSize < 0 {
+		return &ConfigError{
+			Key: "compress-min-size",
+			Err: fmt.Errorf("must not be negative: %d", c.CompressMinSize),
+		}
+	}
+	if c.ShutdownTimeout < 0 {
+		return &ConfigError{
+			Key: "shutdown-timeout",
+			Err: fmt.Errorf("must not be negative: %s", time.Duration(c.ShutdownTimeout)),
+		}
+	}
+	if err := c.validateSocketOptions(); err != nil {
+		return err
+	}
+	if err := c.validateClientAuth(); err != nil {
+		return err
+	}
+	if _, err := c.tlsParameters(); err != nil {
+		return err
+	}
+	if err := c.validateHTTPSRedirect(); err != nil {
+		return err
+	}
+	if c.EnableH2C {
+		if c.IsHTTPS() {
+			return &ConfigError{Key: "enable-h2c", Err: errors.New("not supported with HTTPS")}
+		}
+		if c.DisableH2 {
+			return &ConfigError{Key: "enable-h2c", Err: errors.New("conflicts with disable-h2")}
+		}
+	}
+	if c.EnableH3 {
+		if !c.IsHTTPS() {
+			return &ConfigError{Key: "enable-h3", Err: errors.New("requires HTTPS")}
+		}
+		if len(c.tcpAddrs()) == 0 {
+			return &ConfigError{Key: "enable-h3", Err: errors.New("requires a TCP address")}
 		}
 	}
 	if c.PasswordFile != "" {
 		if err := checkFile(c.PasswordFile, false); err != nil {
-			return err
+			return &ConfigError{Key: "basic-auth", Err: err}
+		}
+	}
+	if err := validateMounts(c.Mounts); err != nil {
+		return err
+	}
+	hostNames := make(map[string]bool)
+	for i, host := range c.Hosts {
+		if err := host.Validate(); err != nil {
+			return prefixConfigError(fmt.Sprintf("hosts[%d]", i), err)
+		}
+		for _, name := range host.Names {
+			name = strings.ToLower(name)
+			if hostNames[name] {
+				return &ConfigError{
+					Key: fmt.Sprintf("hosts[%d].names", i),
+					Err: fmt.Errorf("duplicated host name: %s", name),
+				}
+			}
+			hostNames[name] = true
 		}
 	}
 
@@ -79,6 +519,29 @@ func (c StaticServerConfig) Validate() error {
 // StaticServer is a static HTTP server.
 type StaticServer struct {
 	Config StaticServerConfig
+
+	acmeManager  *autocert.Manager
+	certificates *CertificateStore
+	compressor   *Compressor
+	h3Server     *http3.Server
+	// archives browsed from directories
+	archiveCache *archiveCache
+	// sockets passed via systemd socket activation
+	systemdSockets systemdSockets
+
+	mutex sync.Mutex
+	// the main server, set up on first use
+	server      *http.Server
+	servers     []boundServer
+	httpServers []*http.Server
+	packetConns []net.PacketConn
+	archives    []*ArchiveFS
+	// stops background tasks
+	cancel  context.CancelFunc
+	done    chan struct{}
+	started bool
+	stopped bool
+	err     error
 }
 
 // NewStaticServer returns a StaticServer.
@@ -89,7 +552,7 @@ func NewStaticServer(config StaticServerConfig) (*StaticServer, error) {
 	if err := config.Validate(); err != nil {
 		return nil, err
 	}
-	server := StaticServer{Config: config}
+	server := StaticServer{Config: config, archiveCache: newArchiveCache(archiveCacheSize)}
 
 	// always use absolute path for the root dir.
 	absDir, err := filepath.Abs(config.Dir)
@@ -97,10 +560,62 @@ func NewStaticServer(config StaticServerConfig) (*StaticServer, error) {
 		return nil, err
 	}
 	server.Config.Dir = absDir
+	if server.Config.FallbackDirs, err = absPaths(config.FallbackDirs); err != nil {
+		return nil, err
+	}
+	if server.Config.Mounts, err = absMounts(config.Mounts); err != nil {
+		return nil, err
+	}
+	// copy hosts to avoid modifying the caller's config
+	server.Config.Hosts = append([]HostConfig(nil), config.Hosts...)
+	for i := range server.Config.Hosts {
+		host := &server.Config.Hosts[i]
+		if host.Dir, err = filepath.Abs(host.Dir); err != nil {
+			return nil, err
+		}
+		if host.FallbackDirs, err = absPaths(host.FallbackDirs); err != nil {
+			return nil, err
+		}
+		if host.Mounts, err = absMounts(host.Mounts); err != nil {
+			return nil, err
+		}
+	}
 
 	return &server, nil
 }
 
+// absMounts returns a copy of mounts with absolute paths for directories.
+func absMounts(mounts []MountConfig) ([]MountConfig, error) {
+	mounts = append([]MountConfig(nil), mounts...)
+	for i := range mounts {
+		absDir, err := filepath.Abs(mounts[i].Dir)
+		if err != nil {
+			return nil, err
+		}
+		mounts[i].Dir = absDir
+		if mounts[i].FallbackDirs, err = absPaths(mounts[i].FallbackDirs); err != nil {
+			return nil, err
+		}
+	}
+	return mounts, nil
+}
+
+// absPaths returns a copy of paths as absolute paths.
+func absPaths(paths []string) ([]string, error) {
+	if paths == nil {
+		return nil, nil
+	}
+	absPaths := make([]string, len(paths))
+	for i, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		absPaths[i] = absPath
+	}
+	return absPaths, nil
+}
+
 // Scheme returns the server scheme (http or https)
 func (s *StaticServer) Scheme() string {
 	if s.Config.IsHTTPS() {
@@ -111,15 +626,115 @@ func (s *StaticServer) Scheme() string {
 
 // getServer returns a configured server.
 func (s *StaticServer) getServer() (*http.Server, error) {
+	if s.Config.Compress {
+		// shared by all handlers, to limit the overall cache size
+		s.compressor = NewCompressor(s.Config.compressMinSize(), s.Config.compressCacheSize())
+	}
+	handler, err := s.getHostHandler(s.Config.defaultHost())
+	if err != nil {
+		return nil, err
+	}
+	// optionally, dispatch requests to virtual hosts
+	if len(s.Config.Hosts) > 0 {
+		vhostHandler := &VirtualHostHandler{
+			Hosts:   make(map[string]http.Handler),
+			Default: handler,
+		}
+		for _, host := range s.Config.Hosts {
+			hostHandler, err := s.getHostHandler(host)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range host.Names {
+				vhostHandler.Hosts[strings.ToLower(name)] = hostHandler
+			}
+		}
+		handler = vhostHandler
+	}
+	// optionally, enable logging
+	if s.Config.Log {
+		handler = &LoggingHandler{Handler: handler}
+	}
+
+	// always add server version to headers
+	headers := map[string]string{"Server": version.App.Identifier()}
+	// optionally, add HSTS header
+	if s.Config.HSTSMaxAge > 0 {
+		headers["Strict-Transport-Security"] = HSTSHeader(
+			s.Config.HSTSMaxAge, s.Config.HSTSIncludeSubDomains, s.Config.HSTSPreload)
+	}
+	handler = AddHeadersHandler(headers, handler)
+
+	// optionally, enable HTTP/2 over cleartext connections
+	if s.Config.EnableH2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	tlsNextProto := make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	if !s.Config.DisableH2 {
+		// Setting to nil means to use the default (which is H2-enabled)
+		tlsNextProto = nil
+	}
+
+	server := &http.Server{
+		Handler:      handler,
+		TLSNextProto: tlsNextProto,
+	}
+	if s.Config.IsHTTPS() {
+		if server.TLSConfig, err = s.getTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+	// optionally, serve HTTP/3 with the same handler, advertising it in
+	// responses over TCP
+	if s.Config.EnableH3 {
+		s.h3Server = &http3.Server{
+			Handler:   handler,
+			TLSConfig: http3.ConfigureTLSConfig(server.TLSConfig),
+		}
+		// advertise the port once if all addresses use the same one,
+		// otherwise the port of each listener is advertised
+		if ports := s.Config.tcpPorts(); len(ports) == 1 {
+			s.h3Server.Port = ports[0]
+		}
+		server.Handler = &AltSvcHandler{Handler: handler, Server: s.h3Server}
+	}
+	return server, nil
+}
+
+// getHostHandler returns the handler serving content for a host.
+func (s *StaticServer) getHostHandler(host HostConfig) (http.Handler, error) {
 	mux := http.NewServeMux()
 	// handler for static files
+	fsys, err := s.openRoot(host.Dir)
+	if err != nil {
+		return nil, err
+	}
+	fallbacks, err := s.openFallbackRoots(host.FallbackDirs)
+	if err != nil {
+		return nil, err
+	}
 	fileSystem := FileSystem{
-		AllowOutsideSymlinks: s.Config.AllowOutsideSymlinks,
-		HideDotFiles:         !s.Config.ShowDotFiles,
-		ResolveHTML:          !s.Config.DisableLookupWithSuffix,
-		Root:                 s.Config.Dir,
+		AllowOutsideSymlinks: host.AllowOutsideSymlinks,
+		BrowseArchives:       host.BrowseArchives,
+		archives:             s.archiveCache,
+		HideDotFiles:         !host.ShowDotFiles,
+		HidePrecompressed:    host.HidePrecompressed,
+		NetlifyFiles:         host.NetlifyFiles,
+		ResolveHTML:          !host.DisableLookupWithSuffix,
+		Root:                 host.Dir,
+		FS:                   fsys,
+		Fallbacks:            fallbacks,
+	}
+	fileHandler := NewFileHandler(fileSystem, !host.DisableIndex, s.Config.RequestPathPrefix)
+	fileHandler.Compressor = s.compressor
+	mux.Handle("/", fileHandler)
+	// handlers for additional mounts
+	for _, mount := range host.Mounts {
+		if err := s.addMountHandler(mux, mount); err != nil {
+			return nil, err
+		}
 	}
-	mux.Handle("/", NewFileHandler(fileSystem, !s.Config.DisableIndex, s.Config.RequestPathPrefix))
 
 	// add handler for builtin assets. Cache them for 24h so they don't
 	// get requested every time
@@ -130,22 +745,50 @@ func (s *StaticServer) getServer() (*http.Server, error) {
 	mux.Handle(AssetsPrefix, http.StripPrefix(AssetsPrefix, assetsHandler))
 
 	// optionally, serve CSS from the specified file instead of the builtin assets
-	if s.Config.CSS != "" {
+	if host.CSS != "" {
 		mux.HandleFunc(
 			CSSAsset,
 			func(w http.ResponseWriter, r *http.Request) {
-				http.ServeFile(w, r, s.Config.CSS)
+				http.ServeFile(w, r, host.CSS)
 			})
 	}
 
 	var handler http.Handler = mux
+	// optionally, redirect or rewrite requests based on rules. These apply
+	// to paths without the request path prefix, and after authentication
+	if len(s.Config.RewriteRules) > 0 {
+		rules, err := NewRewriteRules(s.Config.RewriteRules)
+		if err != nil {
+			return nil, err
+		}
+		handler = &RewriteRulesHandler{
+			Handler:    handler,
+			Rules:      rules,
+			PathPrefix: s.Config.RequestPathPrefix,
+		}
+	}
+	// optionally, update response headers based on rules
+	if len(host.HeaderRules) > 0 {
+		rules, err := NewHeaderRules(host.HeaderRules)
+		if err != nil {
+			return nil, err
+		}
+		handler = &HeaderRulesHandler{Handler: handler, Rules: rules}
+	}
+	// optionally, restrict access based on TLS client certificates
+	if len(s.Config.TLSClientAccess) > 0 {
+		handler = &ClientCertHandler{
+			Handler: handler,
+			Access:  s.Config.clientAccess(),
+		}
+	}
 	// optionally, strip request path prefix
 	if s.Config.RequestPathPrefix != "" {
 		handler = http.StripPrefix(s.Config.RequestPathPrefix, handler)
 	}
 	// optionally, wrap handler with Basic-Auth
-	if s.Config.PasswordFile != "" {
-		credentials, err := loadCredentials(s.Config.PasswordFile)
+	if host.PasswordFile != "" {
+		credentials, err := loadCredentials(host.PasswordFile)
 		if err != nil {
 			return nil, err
 		}
@@ -155,63 +798,219 @@ func (s *StaticServer) getServer() (*http.Server, error) {
 			Realm:       version.App.Name,
 		}
 	}
+	return handler, nil
+}
 
-	// optionally, enable logging
-	if s.Config.Log {
-		handler = &LoggingHandler{Handler: handler}
+// addMountHandler adds a handler serving static files for a mount.
+func (s *StaticServer) addMountHandler(mux *http.ServeMux, mount MountConfig) error {
+	prefix := mount.cleanPrefix()
+	fsys, err := s.openRoot(mount.Dir)
+	if err != nil {
+		return err
 	}
+	fallbacks, err := s.openFallbackRoots(mount.FallbackDirs)
+	if err != nil {
+		return err
+	}
+	fileSystem := FileSystem{
+		AllowOutsideSymlinks: mount.AllowOutsideSymlinks,
+		BrowseArchives:       mount.BrowseArchives,
+		archives:             s.archiveCache,
+		HideDotFiles:         !mount.ShowDotFiles,
+		HidePrecompressed:    mount.HidePrecompressed,
+		NetlifyFiles:         mount.NetlifyFiles,
+		ResolveHTML:          !mount.DisableLookupWithSuffix,
+		Root:                 mount.Dir,
+		FS:                   fsys,
+		Fallbacks:            fallbacks,
+	}
+	fileHandler := NewMountFileHandler(
+		fileSystem, !mount.DisableIndex, s.Config.RequestPathPrefix, prefix)
+	fileHandler.Compressor = s.compressor
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, fileHandler))
+	// redirect to the URL with trailing slash, including the request path
+	// prefix (which the default ServeMux redirect wouldn't preserve)
+	mux.HandleFunc(
+		prefix,
+		func(w http.ResponseWriter, r *http.Request) {
+			localRedirect(w, r, s.Config.RequestPathPrefix+prefix+"/")
+		})
+	return nil
+}
 
-	// always add server version to headers
-	handler = AddHeadersHandler(
-		map[string]string{"Server": version.App.Identifier()},
-		handler,
-	)
-
-	tlsNextProto := make(map[string]func(*http.Server, *tls.Conn, http.Handler))
-	if !s.Config.DisableH2 {
-		// Setting to nil means to use the default (which is H2-enabled)
-		tlsNextProto = nil
+// openRoot returns the fs.FS to serve files from for a directory or an
+// archive. For directories, nil is returned, since files are served from
+// the OS filesystem.
+//
+// Archives are closed when the server is shut down.
+func (s *StaticServer) openRoot(root string) (fs.FS, error) {
+	if !IsArchive(root) {
+		return nil, nil
+	}
+	archive, err := OpenArchive(root)
+	if err != nil {
+		return nil, err
 	}
+	s.archives = append(s.archives, archive)
+	return archive, nil
+}
 
-	return &http.Server{
-		Addr:         s.Config.Addr,
-		Handler:      handler,
-		TLSNextProto: tlsNextProto,
-	}, nil
+// openFallbackRoots returns FileSystemRoots for fallback directories or
+// archives.
+func (s *StaticServer) openFallbackRoots(dirs []string) ([]FileSystemRoot, error) {
+	var roots []FileSystemRoot
+	for _, dir := range dirs {
+		fsys, err := s.openRoot(dir)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, FileSystemRoot{Dir: dir, FS: fsys})
+	}
+	return roots, nil
 }
 
-// Run starts the server.
+// Run starts the server and blocks until it's stopped by a signal (SIGINT
+// or SIGTERM), or any of the listeners fails.
 func (s *StaticServer) Run() error {
-	log.Printf("Starting %v %s server on %s, serving path %s",
-		version.App, strings.ToUpper(s.Scheme()), s.Config.Addr, s.Config.Dir)
+	tlsInfo := ""
+	if s.Config.IsHTTPS() {
+		params, err := s.Config.tlsParameters()
+		if err != nil {
+			return err
+		}
+		tlsInfo = fmt.Sprintf(" (TLS %s)", params)
+	}
+	log.Printf("Starting %v %s server on %s%s, serving path %s",
+		version.App, strings.ToUpper(s.Scheme()), strings.Join(s.Config.addrs(), ", "), tlsInfo, s.Config.Dir)
 
-	return s.runServer()
-}
+	// handle signals received while starting up too
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
 
-func (s *StaticServer) runServer() error {
-	server, err := s.getServer()
-	if err != nil {
+	if err := s.Start(context.Background()); err != nil {
 		return err
 	}
+	select {
+	case sig := <-stop:
+		log.Printf("Received %s signal, shutting down", sig)
+	case <-s.Done():
+		// a listener failed and the server was shut down
+		return s.Err()
+	}
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	timeout := s.Config.shutdownTimeout()
+	log.Printf("Waiting up to %s for active connections to complete", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	go func() {
-		var err error
-		if s.Config.IsHTTPS() {
-			err = server.ListenAndServeTLS(s.Config.TLSCert, s.Config.TLSKey)
-		} else {
-			err = server.ListenAndServe()
+		select {
+		case <-stop:
+			log.Printf("Received another signal, closing active connections")
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+	if err := s.Shutdown(ctx); err != nil {
+		return err
+	}
+	return s.afterShutdown()
+}
+
+// Handler returns the handler for the server, with all configured
+// middlewares. It can be used to serve requests from a separate http.Server.
+func (s *StaticServer) Handler() (http.Handler, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.setup(); err != nil {
+		return nil, err
+	}
+	return s.server.Handler, nil
+}
 
-		if err != nil && err != http.ErrServerClosed {
-			log.Fatal(err)
+// Start starts listening on configured addresses and serving requests in
+// background.
+//
+// An error is returned if any of the addresses can't be bound, in which case
+// none is. The server is shut down when the context is canceled, or when
+// Shutdown is called. A server can only be started once, and not after it's
+// been shut down.
+func (s *StaticServer) Start(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		return errors.New("server already shut down")
+	}
+	if s.started {
+		return errors.New("server already started")
+	}
+	if err := s.setup(); err != nil {
+		return err
+	}
+
+	// bind all addresses before serving, so that startup fails if any of
+	// them is not available
+	var servers []boundServer
+	var packetConns []net.PacketConn
+	closeAll := func() {
+		for _, b := range servers {
+			b.listener.Close()
 		}
-	}()
+		for _, conn := range packetConns {
+			conn.Close()
+		}
+	}
+	bind := func(server *http.Server, addr string) error {
+		listener, err := s.listen(addr)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		servers = append(servers, boundServer{
+			server:   server,
+			listener: listener,
+			useTLS:   server == s.server && s.Config.IsHTTPS(),
+		})
+		return nil
+	}
 
-	if s.Config.DebugAddr != "" {
-		log.Printf("Serving debug URLs on %s", s.Config.DebugAddr)
+	for _, addr := range s.Config.addrs() {
+		if err := bind(s.server, addr); err != nil {
+			return err
+		}
+	}
+
+	if s.h3Server != nil {
+		for _, addr := range s.Config.tcpAddrs() {
+			conn, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				closeAll()
+				return err
+			}
+			packetConns = append(packetConns, conn)
+			log.Printf("Serving HTTP/3 on %s (UDP)", conn.LocalAddr())
+		}
+	}
+
+	if s.Config.HTTPRedirectAddr != "" {
+		redirectServer := &http.Server{Handler: s.getRedirectHandler()}
+		if err := bind(redirectServer, s.Config.HTTPRedirectAddr); err != nil {
+			return err
+		}
+		log.Printf("Redirecting HTTP requests on %s to HTTPS", s.Config.HTTPRedirectAddr)
+	}
+
+	// ACME challenges are served by the redirect server if it uses the same
+	// address
+	if s.acmeManager != nil && s.Config.ACME.HTTPAddr != "" && s.Config.ACME.HTTPAddr != s.Config.HTTPRedirectAddr {
+		acmeServer := &http.Server{Handler: s.acmeManager.HTTPHandler(nil)}
+		if err := bind(acmeServer, s.Config.ACME.HTTPAddr); err != nil {
+			return err
+		}
+		log.Printf("Serving ACME HTTP challenges on %s", s.Config.ACME.HTTPAddr)
+	}
 
+	if s.Config.DebugAddr != "" {
 		var handler http.Handler = newDebugMux()
 		// optionally, enable logging
 		if s.Config.Log {
@@ -223,24 +1022,220 @@ func (s *StaticServer) runServer() error {
 			handler,
 		)
 
-		go func() {
-			debugServer := http.Server{
-				Addr:    s.Config.DebugAddr,
-				Handler: handler,
+		debugServer := &http.Server{Handler: handler}
+		if err := bind(debugServer, s.Config.DebugAddr); err != nil {
+			return err
+		}
+		log.Printf("Serving debug URLs on %s", s.Config.DebugAddr)
+	}
+
+	for _, b := range servers {
+		s.addBoundServer(b)
+		go func(b boundServer) {
+			if err := b.serve(); err != nil {
+				s.fail(err)
 			}
-			if err := debugServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Fatal(err)
+		}(b)
+	}
+	s.packetConns = append(s.packetConns, packetConns...)
+	for _, conn := range packetConns {
+		go func(conn net.PacketConn) {
+			if err := s.h3Server.Serve(conn); err != nil && err != http.ErrServerClosed {
+				s.fail(err)
 			}
-		}()
+		}(conn)
 	}
-	<-stop
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
+	s.started = true
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.shutdownWithTimeout()
+		case <-s.done:
+		}
+	}()
+	return nil
+}
+
+// Serve serves requests on the listener, blocking until the server is shut
+// down. It returns nil if the server is shut down via Shutdown.
+//
+// If HTTPS is enabled, TLS connections are accepted on the listener.
+func (s *StaticServer) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	if err := s.setup(); err != nil {
+		s.mutex.Unlock()
 		return err
 	}
-	return s.afterShutdown()
+	b := boundServer{server: s.server, listener: listener, useTLS: s.Config.IsHTTPS()}
+	s.addBoundServer(b)
+	s.mutex.Unlock()
+	return b.serve()
+}
+
+// Shutdown gracefully shuts down the server: listeners are closed, and
+// active connections are waited for until the context is done. Connections
+// still active after that are closed.
+func (s *StaticServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	httpServers := s.httpServers
+	packetConns := s.packetConns
+	s.mutex.Unlock()
+
+	// shut down servers in parallel, since each waits for its connections
+	var wg sync.WaitGroup
+	errs := make(chan error, len(httpServers)+1)
+	shutdownServer := func(shutdownFunc func(context.Context) error, closeFunc func() error) {
+		defer wg.Done()
+		if err := shutdownFunc(ctx); err != nil {
+			closeFunc()
+			errs <- err
+		}
+	}
+	for _, server := range httpServers {
+		wg.Add(1)
+		go shutdownServer(server.Shutdown, server.Close)
+	}
+	if s.h3Server != nil {
+		wg.Add(1)
+		go shutdownServer(s.h3Server.Shutdown, s.h3Server.Close)
+	}
+	wg.Wait()
+	close(errs)
+	for _, conn := range packetConns {
+		conn.Close()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, archive := range s.archives {
+		archive.Close()
+	}
+	s.archives = nil
+	s.archiveCache.Purge()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if !s.stopped {
+		s.stopped = true
+		if s.done == nil {
+			s.done = make(chan struct{})
+		}
+		close(s.done)
+	}
+	if err := <-errs; err != nil {
+		return fmt.Errorf("active connections closed before completing: %w", err)
+	}
+	return nil
+}
+
+// Done returns a channel that is closed when the server is shut down.
+func (s *StaticServer) Done() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+	return s.done
+}
+
+// Err returns the error which caused the server to shut down, if any.
+func (s *StaticServer) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Addrs returns the addresses the server is listening on, for instance to
+// find the actual port when listening on port 0.
+func (s *StaticServer) Addrs() []net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var addrs []net.Addr
+	for _, b := range s.servers {
+		if b.server == s.server {
+			addrs = append(addrs, b.listener.Addr())
+		}
+	}
+	return addrs
+}
+
+// setup sets up the main server and starts background tasks, if not done
+// yet. It must be called with the mutex held.
+func (s *StaticServer) setup() error {
+	if s.server != nil {
+		return nil
+	}
+	server, err := s.getServer()
+	if err != nil {
+		return err
+	}
+	s.server = server
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	if s.certificates != nil {
+		go s.certificates.Watch(ctx)
+	}
+	return nil
+}
+
+// addBoundServer tracks a server and its listener. It must be called with
+// the mutex held.
+func (s *StaticServer) addBoundServer(b boundServer) {
+	s.servers = append(s.servers, b)
+	if !slices.Contains(s.httpServers, b.server) {
+		s.httpServers = append(s.httpServers, b.server)
+	}
+}
+
+// fail records an error from a listener and shuts down the server.
+func (s *StaticServer) fail(err error) {
+	s.mutex.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mutex.Unlock()
+	go s.shutdownWithTimeout()
+}
+
+// shutdownWithTimeout shuts down the server, waiting for active connections
+// up to the configured timeout.
+func (s *StaticServer) shutdownWithTimeout() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.shutdownTimeout())
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		s.mutex.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// getRedirectHandler returns the handler redirecting plain HTTP requests to
+// HTTPS.
+func (s *StaticServer) getRedirectHandler() http.Handler {
+	var handler http.Handler = HTTPSRedirectHandler{
+		Port:       s.Config.Port(),
+		StatusCode: s.Config.HTTPRedirectCode,
+		PathPrefix: s.Config.RequestPathPrefix,
+	}
+	// serve ACME HTTP-01 challenges, redirecting other requests
+	if s.acmeManager != nil {
+		handler = s.acmeManager.HTTPHandler(handler)
+	}
+	// optionally, enable logging
+	if s.Config.Log {
+		handler = &LoggingHandler{Handler: handler}
+	}
+	// always add server version to headers
+	return AddHeadersHandler(
+		map[string]string{"Server": version.App.Identifier()},
+		handler,
+	)
 }
 
 func (s *StaticServer) afterShutdown() error {
@@ -248,6 +1243,15 @@ func (s *StaticServer) afterShutdown() error {
 	return nil
 }
 
+// checkRoot raises an error if the path is not a directory or an archive to
+// serve files from.
+func checkRoot(path string) error {
+	if IsArchive(path) {
+		return checkFile(path, false)
+	}
+	return checkFile(path, true)
+}
+
 func checkFile(path string, asDir bool) error {
 	info, err := os.Stat(path)
 	if err != nil {
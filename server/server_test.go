@@ -1,13 +1,25 @@
 package server_test
 
 import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/quic-go/quic-go/http3"
 	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/http2"
 
 	"github.com/albertodonato/h2static/server"
 	"github.com/albertodonato/h2static/testhelpers"
@@ -45,7 +57,48 @@ func (s *StaticServerConfigTestSuite) TestConfigValidateDirNotDir() {
 	config := server.StaticServerConfig{Dir: path}
 	err := config.Validate()
 	s.NotNil(err)
-	s.Equal(fmt.Sprintf("not a directory: %s", path), err.Error())
+	s.Equal(fmt.Sprintf("dir: not a directory: %s", path), err.Error())
+}
+
+// Dir can be an archive file.
+func (s *StaticServerConfigTestSuite) TestConfigValidateDirArchive() {
+	path := s.WriteTar("site.tar.gz", map[string]string{"index.html": ""})
+	config := server.StaticServerConfig{Dir: path}
+	s.Nil(config.Validate())
+}
+
+// If the specified archive doesn't exist, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateDirArchiveNotExists() {
+	path := filepath.Join(s.TempDir, "site.zip")
+	config := server.StaticServerConfig{Dir: path}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Contains(err.Error(), path)
+}
+
+// If a fallback directory doesn't exist, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateFallbackDirNotExists() {
+	config := server.StaticServerConfig{
+		Dir:          s.TempDir,
+		FallbackDirs: []string{s.TempDir, nonExistentPath},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.True(strings.HasPrefix(err.Error(), "fallback-dirs[1]: "))
+	s.Contains(err.Error(), nonExistentPath)
+}
+
+// If a fallback directory for a mount is a file, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateMountFallbackDirNotDir() {
+	path := s.WriteFile("foo", "bar")
+	config := server.StaticServerConfig{
+		Dir: s.TempDir,
+		Mounts: []server.MountConfig{
+			{Prefix: "/docs", Dir: s.TempDir, FallbackDirs: []string{path}},
+		},
+	}
+	err := config.Validate()
+	s.Equal(fmt.Sprintf("mounts[0].fallback-dirs[0]: not a directory: %s", path), err.Error())
 }
 
 // If the CSS file doesn't exist, an error is returned.
@@ -96,6 +149,96 @@ func (s *StaticServerConfigTestSuite) TestConfigValidatePasswordFileNotExists()
 	s.Contains(err.Error(), nonExistentPath)
 }
 
+// If a virtual host has no names, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateHostNoNames() {
+	config := server.StaticServerConfig{
+		Dir:   s.TempDir,
+		Hosts: []server.HostConfig{{Dir: s.TempDir}},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("hosts[0].names: no host names specified", err.Error())
+}
+
+// If a virtual host dir doesn't exist, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateHostDirNotExists() {
+	config := server.StaticServerConfig{
+		Dir: s.TempDir,
+		Hosts: []server.HostConfig{
+			{Names: []string{"example.com"}, Dir: s.TempDir},
+			{Names: []string{"example.org"}, Dir: nonExistentPath},
+		},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Contains(err.Error(), "hosts[1].dir: ")
+	s.Contains(err.Error(), nonExistentPath)
+}
+
+// If a host name is used for multiple virtual hosts, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateHostDuplicatedName() {
+	config := server.StaticServerConfig{
+		Dir: s.TempDir,
+		Hosts: []server.HostConfig{
+			{Names: []string{"example.com"}, Dir: s.TempDir},
+			{Names: []string{"Example.com"}, Dir: s.TempDir},
+		},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("hosts[1].names: duplicated host name: example.com", err.Error())
+}
+
+// If a mount prefix is not valid, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateMountInvalidPrefix() {
+	for _, prefix := range []string{"", "/", "docs"} {
+		config := server.StaticServerConfig{
+			Dir:    s.TempDir,
+			Mounts: []server.MountConfig{{Prefix: prefix, Dir: s.TempDir}},
+		}
+		err := config.Validate()
+		s.NotNil(err)
+		s.Contains(err.Error(), "mounts[0].prefix: must be an absolute path other than /")
+	}
+}
+
+// If a mount prefix conflicts with builtin assets, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateMountAssetsPrefix() {
+	config := server.StaticServerConfig{
+		Dir:    s.TempDir,
+		Mounts: []server.MountConfig{{Prefix: server.AssetsPrefix, Dir: s.TempDir}},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Contains(err.Error(), "mounts[0].prefix: conflicts with builtin assets prefix")
+}
+
+// If a mount prefix is duplicated, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateMountDuplicatedPrefix() {
+	config := server.StaticServerConfig{
+		Dir: s.TempDir,
+		Mounts: []server.MountConfig{
+			{Prefix: "/docs", Dir: s.TempDir},
+			{Prefix: "/docs/", Dir: s.TempDir},
+		},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("mounts[1].prefix: duplicated mount prefix: /docs", err.Error())
+}
+
+// If a mount dir doesn't exist, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateMountDirNotExists() {
+	config := server.StaticServerConfig{
+		Dir:    s.TempDir,
+		Mounts: []server.MountConfig{{Prefix: "/docs", Dir: nonExistentPath}},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Contains(err.Error(), "mounts[0].dir: ")
+	s.Contains(err.Error(), nonExistentPath)
+}
+
 // If no invalid file is passed, ValidateConfig returns nil.
 func (s *StaticServerConfigTestSuite) TestConfigValidateNoError() {
 	config := server.StaticServerConfig{Dir: s.TempDir}
@@ -120,7 +263,7 @@ func (s *StaticServerConfigTestSuite) TestIsHTTPSTrue() {
 
 // Port returns the service port from the config.
 func (s *StaticServerConfigTestSuite) TestPort() {
-	config := server.StaticServerConfig{Addr: "localhost:1234"}
+	config := server.StaticServerConfig{Addr: server.AddrList{"localhost:1234"}}
 	s.Equal(config.Port(), uint16(1234))
 }
 
@@ -130,6 +273,203 @@ func (s *StaticServerConfigTestSuite) TestPortUnset() {
 	s.Equal(config.Port(), uint16(0))
 }
 
+// If a redirect address is set without HTTPS, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateHTTPRedirectAddrNoHTTPS() {
+	config := server.StaticServerConfig{
+		Dir:              s.TempDir,
+		HTTPRedirectAddr: ":80",
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("http-redirect-addr: requires HTTPS", err.Error())
+}
+
+// If the redirect status code is invalid, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateHTTPRedirectCodeInvalid() {
+	config := server.StaticServerConfig{
+		Dir:              s.TempDir,
+		HTTPRedirectCode: 302,
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("http-redirect-code: invalid status code (must be 301 or 308): 302", err.Error())
+}
+
+// If HSTS is set without HTTPS, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateHSTSNoHTTPS() {
+	config := server.StaticServerConfig{
+		Dir:        s.TempDir,
+		HSTSMaxAge: 3600,
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("hsts-max-age: requires HTTPS", err.Error())
+}
+
+// HSTS options must be consistent.
+func (s *StaticServerConfigTestSuite) TestConfigValidateHSTSOptions() {
+	config := server.StaticServerConfig{
+		Dir:     s.TempDir,
+		TLSCert: s.WriteFile("cert.pem", "cert"),
+		TLSKey:  s.WriteFile("key.pem", "key"),
+	}
+	for _, test := range []struct {
+		maxAge            int
+		includeSubDomains bool
+		preload           bool
+		err               string
+	}{
+		{-1, false, false, "hsts-max-age: must not be negative: -1"},
+		{0, true, false, "hsts-include-subdomains: requires hsts-max-age"},
+		{0, false, true, "hsts-preload: requires hsts-max-age"},
+		{3600, true, true, "hsts-preload: requires hsts-max-age of at least 31536000"},
+		{31536000, false, true, "hsts-preload: requires hsts-include-subdomains"},
+		{31536000, true, true, ""},
+	} {
+		config.HSTSMaxAge = test.maxAge
+		config.HSTSIncludeSubDomains = test.includeSubDomains
+		config.HSTSPreload = test.preload
+		err := config.Validate()
+		if test.err == "" {
+			s.Nil(err)
+		} else {
+			s.Equal(test.err, err.Error())
+		}
+	}
+}
+
+// If HTTP/3 is enabled without HTTPS, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateH3NoHTTPS() {
+	config := server.StaticServerConfig{
+		Dir:      s.TempDir,
+		EnableH3: true,
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("enable-h3: requires HTTPS", err.Error())
+}
+
+// If h2c is enabled with HTTPS, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateH2CWithHTTPS() {
+	config := server.StaticServerConfig{
+		Dir:       s.TempDir,
+		TLSCert:   s.WriteFile("cert.pem", "cert"),
+		TLSKey:    s.WriteFile("key.pem", "key"),
+		EnableH2C: true,
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("enable-h2c: not supported with HTTPS", err.Error())
+}
+
+// If h2c is enabled with HTTP/2 disabled, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateH2CDisableH2() {
+	config := server.StaticServerConfig{
+		Dir:       s.TempDir,
+		DisableH2: true,
+		EnableH2C: true,
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("enable-h2c: conflicts with disable-h2", err.Error())
+}
+
+// If a header rule is invalid, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateHeaderRules() {
+	config := server.StaticServerConfig{
+		Dir: s.TempDir,
+		HeaderRules: []server.HeaderRuleConfig{
+			{Path: "/", Remove: []string{"Server"}},
+			{PathRegexp: "(", Remove: []string{"Server"}},
+		},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.True(strings.HasPrefix(err.Error(), "header-rules[1].path-regexp: "))
+}
+
+// If a header rule for a host is invalid, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateHostHeaderRules() {
+	config := server.StaticServerConfig{
+		Dir: s.TempDir,
+		Hosts: []server.HostConfig{
+			{
+				Names:       []string{"example.com"},
+				Dir:         s.TempDir,
+				HeaderRules: []server.HeaderRuleConfig{{Path: "/"}},
+			},
+		},
+	}
+	err := config.Validate()
+	s.Equal("hosts[0].header-rules[0].set: no headers to set or remove", err.Error())
+}
+
+// If the mode for Netlify-style files is invalid, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateNetlifyFiles() {
+	config := server.StaticServerConfig{
+		Dir:          s.TempDir,
+		NetlifyFiles: "all",
+	}
+	err := config.Validate()
+	s.Equal(`netlify-files: invalid mode (must be "root" or "dirs"): all`, err.Error())
+}
+
+// If the mode for Netlify-style files for a mount is invalid, an error is
+// returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateMountNetlifyFiles() {
+	config := server.StaticServerConfig{
+		Dir: s.TempDir,
+		Mounts: []server.MountConfig{
+			{Prefix: "/docs", Dir: s.TempDir, NetlifyFiles: "all"},
+		},
+	}
+	err := config.Validate()
+	s.Equal(`mounts[0].netlify-files: invalid mode (must be "root" or "dirs"): all`, err.Error())
+}
+
+// If a rewrite rule is invalid, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateRewriteRules() {
+	config := server.StaticServerConfig{
+		Dir: s.TempDir,
+		RewriteRules: []server.RewriteRuleConfig{
+			{Action: "permanent", Path: server.MatchConfig{Exact: "/"}},
+		},
+	}
+	err := config.Validate()
+	s.Equal("rewrite-rules[0].target: no target specified", err.Error())
+}
+
+// If the compression cache size is negative, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateCompressCacheSizeNegative() {
+	config := server.StaticServerConfig{
+		Dir:               s.TempDir,
+		CompressCacheSize: -1,
+	}
+	err := config.Validate()
+	s.Equal("compress-cache-size: must not be negative: -1", err.Error())
+}
+
+// If the compression minimum size is negative, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateCompressMinSizeNegative() {
+	config := server.StaticServerConfig{
+		Dir:             s.TempDir,
+		CompressMinSize: -1,
+	}
+	err := config.Validate()
+	s.Equal("compress-min-size: must not be negative: -1", err.Error())
+}
+
+// If the shutdown timeout is negative, an error is returned.
+func (s *StaticServerConfigTestSuite) TestConfigValidateShutdownTimeoutNegative() {
+	config := server.StaticServerConfig{
+		Dir:             s.TempDir,
+		ShutdownTimeout: server.Duration(-time.Second),
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("shutdown-timeout: must not be negative: -1s", err.Error())
+}
+
 func TestStaticServer(t *testing.T) {
 	suite.Run(t, new(StaticServerTestSuite))
 }
@@ -203,6 +543,416 @@ func (s *StaticServerTestSuite) TestSetupServerSpecifyAllowOutsideSymlinks() {
 	s.Equal(content, w.Body.String())
 }
 
+// GetServer returns a configured http.Server with the TLS certificate
+func (s *StaticServerTestSuite) TestSetupServerTLSCertificate() {
+	cert, key := testhelpers.GenerateKeyPair(time.Now().Add(time.Hour), "example.com")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:     s.TempDir,
+		TLSCert: s.WriteFile("cert.pem", string(cert)),
+		TLSKey:  s.WriteFile("key.pem", string(key)),
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	tlsCert, err := httpServer.TLSConfig.GetCertificate(&tls.ClientHelloInfo{})
+	s.Nil(err)
+	s.Equal([]string{"example.com"}, tlsCert.Leaf.DNSNames)
+}
+
+// GetServer returns an error if the TLS certificate is invalid
+func (s *StaticServerTestSuite) TestSetupServerTLSCertificateInvalid() {
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:     s.TempDir,
+		TLSCert: s.WriteFile("cert.pem", "cert"),
+		TLSKey:  s.WriteFile("key.pem", "key"),
+	})
+	s.Nil(err)
+	_, err = server.GetServer(serv)
+	s.Contains(err.Error(), "invalid TLS certificate or key")
+}
+
+// GetServer returns a configured http.Server adding the HSTS header
+func (s *StaticServerTestSuite) TestSetupServerHSTS() {
+	cert, key := testhelpers.GenerateKeyPair(time.Now().Add(time.Hour), "example.com")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:                   s.TempDir,
+		TLSCert:               s.WriteFile("cert.pem", string(cert)),
+		TLSKey:                s.WriteFile("key.pem", string(key)),
+		HSTSMaxAge:            3600,
+		HSTSIncludeSubDomains: true,
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	httpServer.Handler.ServeHTTP(w, r)
+	s.Equal("max-age=3600; includeSubDomains", w.Result().Header.Get("Strict-Transport-Security"))
+}
+
+// The redirect handler redirects to the HTTPS server address
+func (s *StaticServerTestSuite) TestRedirectHandler() {
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Addr:              server.AddrList{":8443"},
+		Dir:               s.TempDir,
+		TLSCert:           s.WriteFile("cert.pem", "cert"),
+		TLSKey:            s.WriteFile("key.pem", "key"),
+		HTTPRedirectAddr:  ":8080",
+		HTTPRedirectCode:  http.StatusPermanentRedirect,
+		RequestPathPrefix: "/prefix",
+	})
+	s.Nil(err)
+	handler := server.GetRedirectHandler(serv)
+
+	r := httptest.NewRequest("GET", "http://example.com:8080/prefix/foo?bar=baz", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal(http.StatusPermanentRedirect, response.StatusCode)
+	s.Equal("https://example.com:8443/prefix/foo?bar=baz", response.Header.Get("Location"))
+	s.NotEqual("", response.Header.Get("Server"))
+}
+
+// GetServer sets up an HTTP/3 server with the same handler, advertised in
+// responses via Alt-Svc
+func (s *StaticServerTestSuite) TestSetupServerH3() {
+	s.WriteFile("test.txt", "some content")
+	cert, key := testhelpers.GenerateKeyPair(time.Now().Add(time.Hour), "localhost")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:      s.TempDir,
+		TLSCert:  s.WriteFile("cert.pem", string(cert)),
+		TLSKey:   s.WriteFile("key.pem", string(key)),
+		EnableH3: true,
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	h3Server := server.GetH3Server(serv)
+	s.NotNil(h3Server)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	s.Nil(err)
+	defer conn.Close()
+	go h3Server.Serve(conn)
+	defer h3Server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(cert)
+	transport := &http3.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"},
+	}
+	defer transport.Close()
+	client := &http.Client{Transport: transport}
+	response, err := client.Get(fmt.Sprintf("https://%s/test.txt", conn.LocalAddr()))
+	s.Nil(err)
+	defer response.Body.Close()
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal("HTTP/3.0", response.Proto)
+	content, err := io.ReadAll(response.Body)
+	s.Nil(err)
+	s.Equal("some content", string(content))
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	r := httptest.NewRequest("GET", "/test.txt", nil)
+	w := httptest.NewRecorder()
+	httpServer.Handler.ServeHTTP(w, r)
+	s.Equal(fmt.Sprintf(`h3=":%d"; ma=2592000`, port), w.Result().Header.Get("Alt-Svc"))
+}
+
+// GetServer returns a configured http.Server serving HTTP/2 without TLS, with
+// prior knowledge
+func (s *StaticServerTestSuite) TestSetupServerH2CPriorKnowledge() {
+	s.WriteFile("test.txt", "some content")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:       s.TempDir,
+		EnableH2C: true,
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	testServer := httptest.NewServer(httpServer.Handler)
+	defer testServer.Close()
+
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	response, err := client.Get(testServer.URL + "/test.txt")
+	s.Nil(err)
+	defer response.Body.Close()
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal("HTTP/2.0", response.Proto)
+	content, err := io.ReadAll(response.Body)
+	s.Nil(err)
+	s.Equal("some content", string(content))
+}
+
+// GetServer returns a configured http.Server switching to HTTP/2 without TLS
+// via Upgrade
+func (s *StaticServerTestSuite) TestSetupServerH2CUpgrade() {
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:       s.TempDir,
+		EnableH2C: true,
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	testServer := httptest.NewServer(httpServer.Handler)
+	defer testServer.Close()
+
+	conn, err := net.Dial("tcp", testServer.Listener.Addr().String())
+	s.Nil(err)
+	defer conn.Close()
+	fmt.Fprint(
+		conn,
+		"GET / HTTP/1.1\r\n"+
+			"Host: localhost\r\n"+
+			"Connection: Upgrade, HTTP2-Settings\r\n"+
+			"Upgrade: h2c\r\n"+
+			"HTTP2-Settings: AAMAAABkAARAAAAAAAIAAAAA\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	s.Nil(err)
+	s.Equal(http.StatusSwitchingProtocols, response.StatusCode)
+	s.Equal("h2c", response.Header.Get("Upgrade"))
+	// the server then speaks HTTP/2, starting with a SETTINGS frame
+	frame, err := http2.NewFramer(nil, reader).ReadFrame()
+	s.Nil(err)
+	s.IsType(&http2.SettingsFrame{}, frame)
+}
+
+// Without h2c, HTTP/2 is not served over cleartext connections
+func (s *StaticServerTestSuite) TestSetupServerNoH2C() {
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir: s.TempDir,
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	testServer := httptest.NewServer(httpServer.Handler)
+	defer testServer.Close()
+
+	// an Upgrade request is answered with HTTP/1.1
+	conn, err := net.Dial("tcp", testServer.Listener.Addr().String())
+	s.Nil(err)
+	defer conn.Close()
+	fmt.Fprint(
+		conn,
+		"GET / HTTP/1.1\r\n"+
+			"Host: localhost\r\n"+
+			"Connection: Upgrade, HTTP2-Settings\r\n"+
+			"Upgrade: h2c\r\n"+
+			"HTTP2-Settings: AAMAAABkAARAAAAAAAIAAAAA\r\n\r\n")
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	s.Nil(err)
+	defer response.Body.Close()
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal("HTTP/1.1", response.Proto)
+	s.Equal("", response.Header.Get("Upgrade"))
+
+	// the HTTP/2 connection preface with prior knowledge is rejected
+	conn, err = net.Dial("tcp", testServer.Listener.Addr().String())
+	s.Nil(err)
+	defer conn.Close()
+	fmt.Fprint(conn, http2.ClientPreface)
+	response, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	s.Nil(err)
+	defer response.Body.Close()
+	s.Equal(1, response.ProtoMajor)
+	s.NotEqual(http.StatusOK, response.StatusCode)
+	s.NotEqual(http.StatusSwitchingProtocols, response.StatusCode)
+}
+
+// Handler returns the handler for the server, with middlewares.
+func (s *StaticServerTestSuite) TestHandler() {
+	s.WriteFile("test.txt", "some content")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(err)
+
+	r := httptest.NewRequest("GET", "/test.txt", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	response := w.Result()
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal("some content", w.Body.String())
+	s.NotEqual("", response.Header.Get("Server"))
+}
+
+// Start serves requests in background, until the server is shut down.
+func (s *StaticServerTestSuite) TestStartShutdown() {
+	s.WriteFile("test.txt", "some content")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:  s.TempDir,
+		Addr: server.AddrList{"127.0.0.1:0"},
+	})
+	s.Nil(err)
+	s.Nil(serv.Start(context.Background()))
+	addrs := serv.Addrs()
+	s.Len(addrs, 1)
+	s.NotEqual(0, addrs[0].(*net.TCPAddr).Port)
+
+	response, err := http.Get(fmt.Sprintf("http://%s/test.txt", addrs[0]))
+	s.Nil(err)
+	content, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	s.Nil(err)
+	s.Equal("some content", string(content))
+
+	s.Nil(serv.Shutdown(context.Background()))
+	s.Nil(serv.Err())
+	select {
+	case <-serv.Done():
+	default:
+		s.Fail("server not shut down")
+	}
+	_, err = http.Get(fmt.Sprintf("http://%s/test.txt", addrs[0]))
+	s.NotNil(err)
+}
+
+// The server started with Start is shut down when the context is canceled.
+func (s *StaticServerTestSuite) TestStartContextCanceled() {
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:  s.TempDir,
+		Addr: server.AddrList{"127.0.0.1:0"},
+	})
+	s.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Nil(serv.Start(ctx))
+	cancel()
+	select {
+	case <-serv.Done():
+	case <-time.After(5 * time.Second):
+		s.Fail("server not shut down")
+	}
+	s.Nil(serv.Err())
+}
+
+// Start returns an error if the server is already started.
+func (s *StaticServerTestSuite) TestStartTwice() {
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:  s.TempDir,
+		Addr: server.AddrList{"127.0.0.1:0"},
+	})
+	s.Nil(err)
+	s.Nil(serv.Start(context.Background()))
+	defer serv.Shutdown(context.Background())
+	err = serv.Start(context.Background())
+	s.NotNil(err)
+	s.Equal("server already started", err.Error())
+	s.Len(serv.Addrs(), 1)
+}
+
+// Start returns an error if the server has been shut down.
+func (s *StaticServerTestSuite) TestStartAfterShutdown() {
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:  s.TempDir,
+		Addr: server.AddrList{"127.0.0.1:0"},
+	})
+	s.Nil(err)
+	s.Nil(serv.Start(context.Background()))
+	s.Nil(serv.Shutdown(context.Background()))
+	err = serv.Start(context.Background())
+	s.NotNil(err)
+	s.Equal("server already shut down", err.Error())
+	s.Len(serv.Addrs(), 1)
+}
+
+// Start returns an error if the server has been shut down without being
+// started.
+func (s *StaticServerTestSuite) TestStartAfterShutdownNotStarted() {
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:  s.TempDir,
+		Addr: server.AddrList{"127.0.0.1:0"},
+	})
+	s.Nil(err)
+	s.Nil(serv.Shutdown(context.Background()))
+	select {
+	case <-serv.Done():
+	default:
+		s.Fail("server not shut down")
+	}
+	err = serv.Start(context.Background())
+	s.NotNil(err)
+	s.Equal("server already shut down", err.Error())
+}
+
+// Start returns an error if any of the addresses can't be bound, and no
+// listener is left open.
+func (s *StaticServerTestSuite) TestStartBindError() {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Nil(err)
+	defer busy.Close()
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:  s.TempDir,
+		Addr: server.AddrList{"127.0.0.1:0", busy.Addr().String()},
+	})
+	s.Nil(err)
+	err = serv.Start(context.Background())
+	s.NotNil(err)
+	s.Contains(err.Error(), busy.Addr().String())
+	s.Empty(serv.Addrs())
+}
+
+// Serve serves requests on the listener until the server is shut down.
+func (s *StaticServerTestSuite) TestServe() {
+	s.WriteFile("test.txt", "some content")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: s.TempDir})
+	s.Nil(err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Nil(err)
+	result := make(chan error, 1)
+	go func() {
+		result <- serv.Serve(listener)
+	}()
+
+	response, err := http.Get(fmt.Sprintf("http://%s/test.txt", listener.Addr()))
+	s.Nil(err)
+	response.Body.Close()
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal([]net.Addr{listener.Addr()}, serv.Addrs())
+
+	s.Nil(serv.Shutdown(context.Background()))
+	s.Nil(<-result)
+}
+
+// Serve accepts TLS connections on the listener if HTTPS is enabled.
+func (s *StaticServerTestSuite) TestServeTLS() {
+	s.WriteFile("test.txt", "some content")
+	cert, key := testhelpers.GenerateKeyPair(time.Now().Add(time.Hour), "localhost")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:     s.TempDir,
+		TLSCert: s.WriteFile("cert.pem", string(cert)),
+		TLSKey:  s.WriteFile("key.pem", string(key)),
+	})
+	s.Nil(err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Nil(err)
+	go serv.Serve(listener)
+	defer serv.Shutdown(context.Background())
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(cert)
+	transport := &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, ServerName: "localhost"},
+		ForceAttemptHTTP2: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	response, err := client.Get(fmt.Sprintf("https://%s/test.txt", listener.Addr()))
+	s.Nil(err)
+	response.Body.Close()
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal("HTTP/2.0", response.Proto)
+}
+
 // GetServer returns a configured http.Server without HTTP/2
 func (s *StaticServerTestSuite) TestSetupServerNoH2() {
 	serv, err := server.NewStaticServer(server.StaticServerConfig{
@@ -235,6 +985,360 @@ func (s *StaticServerTestSuite) TestSetupServerBasicAuth() {
 		response.Header.Get("WWW-Authenticate"))
 }
 
+// GetServer returns a configured http.Server with virtual hosts
+func (s *StaticServerTestSuite) TestSetupServerVirtualHosts() {
+	s.WriteFile("test.txt", "default")
+	s.Mkdir("example")
+	s.WriteFile("example/test.txt", "example")
+	s.Mkdir("private")
+	s.WriteFile("private/test.txt", "private")
+	passwdPath := s.WriteFile("passwords", "")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir: s.TempDir,
+		Hosts: []server.HostConfig{
+			{
+				Names: []string{"example.com", "www.example.com"},
+				Dir:   filepath.Join(s.TempDir, "example"),
+			},
+			{
+				Names:        []string{"private.example.com"},
+				Dir:          filepath.Join(s.TempDir, "private"),
+				PasswordFile: passwdPath,
+			},
+		},
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+
+	for host, content := range map[string]string{
+		"example.com":     "example",
+		"www.example.com": "example",
+		"example.org":     "default",
+	} {
+		r := httptest.NewRequest("GET", "/test.txt", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		httpServer.Handler.ServeHTTP(w, r)
+		s.Equal(http.StatusOK, w.Result().StatusCode)
+		s.Equal(content, w.Body.String())
+	}
+
+	r := httptest.NewRequest("GET", "/test.txt", nil)
+	r.Host = "private.example.com"
+	w := httptest.NewRecorder()
+	httpServer.Handler.ServeHTTP(w, r)
+	s.Equal(http.StatusUnauthorized, w.Result().StatusCode)
+}
+
+// Files are served from archives, both as root and as mounts.
+func (s *StaticServerTestSuite) TestServeArchives() {
+	site := s.WriteTar("site.tar", map[string]string{"index.html": "site index"})
+	docs := s.WriteZip("docs.zip", map[string]string{"guide/intro.html": "intro"}, zip.Deflate)
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:    site,
+		Mounts: []server.MountConfig{{Prefix: "/docs", Dir: docs}},
+	})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(err)
+	defer serv.Shutdown(context.Background())
+
+	for path, content := range map[string]string{
+		"/":                 "site index",
+		"/docs/guide/intro": "intro",
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		s.Equal(http.StatusOK, w.Result().StatusCode)
+		s.Equal(content, w.Body.String())
+	}
+}
+
+// Responses are compressed for the main directory and mounts, if enabled.
+func (s *StaticServerTestSuite) TestServeCompressed() {
+	content := strings.Repeat("some content\n", 200)
+	s.WriteFile("index.html", content)
+	docs := s.Mkdir("docs")
+	s.WriteFile("docs/guide.html", content)
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:      s.TempDir,
+		Compress: true,
+		Mounts:   []server.MountConfig{{Prefix: "/docs", Dir: docs}},
+	})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(err)
+
+	for _, path := range []string{"/", "/docs/guide.html"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		s.Equal(http.StatusOK, w.Code, path)
+		s.Equal("gzip", w.Header().Get("Content-Encoding"), path)
+		s.Less(w.Body.Len(), len(content), path)
+	}
+}
+
+// Header rules apply to files, listings and builtin assets.
+func (s *StaticServerTestSuite) TestServeHeaderRules() {
+	s.Mkdir("assets")
+	s.WriteFile("assets/app.0123abcd.js", "app")
+	s.WriteFile("index.html", "index")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir: s.TempDir,
+		HeaderRules: []server.HeaderRuleConfig{
+			{
+				PathRegexp: `\.[0-9a-f]{8}\.js$`,
+				Set:        map[string]string{"Cache-Control": "public, max-age=31536000, immutable"},
+			},
+			{
+				ContentType: "text/html",
+				Set:         map[string]string{"Cache-Control": "no-cache"},
+			},
+			{
+				Path:   server.AssetsPrefix + "**",
+				Set:    map[string]string{"Cache-Control": "public, max-age=60"},
+				Remove: []string{"Server"},
+			},
+		},
+	})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(err)
+
+	for path, cacheControl := range map[string]string{
+		"/assets/app.0123abcd.js": "public, max-age=31536000, immutable",
+		"/":                       "no-cache",
+		"/assets/":                "no-cache",
+		server.CSSAsset:           "public, max-age=60",
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		s.Equal(http.StatusOK, w.Code, path)
+		s.Equal(cacheControl, w.Header().Get("Cache-Control"), path)
+		if path == server.CSSAsset {
+			s.Equal("", w.Header().Get("Server"), path)
+		} else {
+			s.NotEqual("", w.Header().Get("Server"), path)
+		}
+	}
+}
+
+// Netlify-style files are read for the host and mounts if enabled.
+func (s *StaticServerTestSuite) TestServeNetlifyFiles() {
+	s.WriteFile("_redirects", "/old /new\n")
+	docsDir := s.Mkdir("docs")
+	s.WriteFile("docs/_redirects", "/old /new 302\n")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:          s.TempDir,
+		NetlifyFiles: server.NetlifyFilesRoot,
+		Mounts: []server.MountConfig{
+			{Prefix: "/docs", Dir: docsDir, NetlifyFiles: server.NetlifyFilesRoot},
+		},
+	})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(err)
+
+	for path, expected := range map[string]struct {
+		code     int
+		location string
+	}{
+		"/old":        {http.StatusMovedPermanently, "/new"},
+		"/docs/old":   {http.StatusFound, "/docs/new"},
+		"/_redirects": {http.StatusNotFound, ""},
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		s.Equal(expected.code, w.Code, path)
+		s.Equal(expected.location, w.Header().Get("Location"), path)
+	}
+}
+
+// Requests are redirected or rewritten based on rules, for all hosts.
+func (s *StaticServerTestSuite) TestServeRewriteRules() {
+	s.WriteFile("index.html", "index")
+	s.Mkdir("docs")
+	s.WriteFile("docs/intro.html", "intro")
+	otherDir := s.Mkdir("other")
+	s.WriteFile("other/index.html", "other index")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir: s.TempDir,
+		Hosts: []server.HostConfig{
+			{Names: []string{"other.example.com"}, Dir: otherDir},
+		},
+		RewriteRules: []server.RewriteRuleConfig{
+			{
+				Action: server.RewriteActionPermanent,
+				Path:   server.MatchConfig{Prefix: "/old-docs/"},
+				Target: "/docs/$1",
+			},
+			{
+				Action: server.RewriteActionRewrite,
+				Path:   server.MatchConfig{Prefix: "/app/"},
+				Target: "/",
+			},
+		},
+	})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(err)
+
+	r := httptest.NewRequest("GET", "http://example.com/old-docs/intro", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("/docs/intro", w.Header().Get("Location"))
+
+	for host, body := range map[string]string{
+		"example.com":       "index",
+		"other.example.com": "other index",
+	} {
+		r = httptest.NewRequest("GET", "http://"+host+"/app/settings", nil)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		s.Equal(http.StatusOK, w.Code, host)
+		s.Equal(body, w.Body.String(), host)
+	}
+}
+
+// Rewritten requests are served without redirects, as for the single-page
+// application example in the README.
+func (s *StaticServerTestSuite) TestServeRewriteRulesSinglePageApp() {
+	s.Mkdir("app")
+	s.WriteFile("app/index.html", "app")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir: s.TempDir,
+		RewriteRules: []server.RewriteRuleConfig{
+			{
+				Action: server.RewriteActionRewrite,
+				Path:   server.MatchConfig{Regexp: `^/app/(?P<route>[^.]*)$`},
+				Target: "/app/index.html?route=${route}",
+			},
+		},
+	})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(err)
+
+	for _, path := range []string{"/app/foo/bar", "/app/foo/", "/app/"} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		s.Equal(http.StatusOK, w.Code, path)
+		s.Equal("", w.Header().Get("Location"), path)
+		s.Equal("app", w.Body.String(), path)
+	}
+}
+
+// Rules apply to paths without the request path prefix, which is added to
+// local redirect targets, and only after authentication.
+func (s *StaticServerTestSuite) TestServeRewriteRulesPathPrefixAndAuth() {
+	passwdPath := s.WriteFile("passwords", "")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:               s.TempDir,
+		RequestPathPrefix: "/prefix",
+		RewriteRules: []server.RewriteRuleConfig{
+			{
+				Action: server.RewriteActionPermanent,
+				Path:   server.MatchConfig{Prefix: "/old/"},
+				Target: "/new/$1",
+			},
+		},
+	})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(err)
+	r := httptest.NewRequest("GET", "/prefix/old/page", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("/prefix/new/page", w.Header().Get("Location"))
+
+	serv, err = server.NewStaticServer(server.StaticServerConfig{
+		Dir:          s.TempDir,
+		PasswordFile: passwdPath,
+		RewriteRules: serv.Config.RewriteRules,
+	})
+	s.Nil(err)
+	handler, err = serv.Handler()
+	s.Nil(err)
+	r = httptest.NewRequest("GET", "/old/page", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	s.Equal(http.StatusUnauthorized, w.Code)
+	s.Equal("", w.Header().Get("Location"))
+}
+
+// An error is returned if an archive is invalid.
+func (s *StaticServerTestSuite) TestServeInvalidArchive() {
+	path := s.WriteFile("site.zip", "not a zip")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{Dir: path})
+	s.Nil(err)
+	handler, err := serv.Handler()
+	s.Nil(handler)
+	s.ErrorContains(err, "invalid archive "+path)
+}
+
+// GetServer returns a configured http.Server with multiple mounts
+func (s *StaticServerTestSuite) TestSetupServerMounts() {
+	s.WriteFile("test.txt", "root")
+	s.Mkdir("docs")
+	s.Mkdir("docs/sub")
+	s.WriteFile("docs/test.txt", "docs")
+	s.Mkdir("artifacts")
+	s.WriteFile("artifacts/test.txt", "artifacts")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir: s.TempDir,
+		Mounts: []server.MountConfig{
+			{Prefix: "/docs/", Dir: filepath.Join(s.TempDir, "docs")},
+			{Prefix: "/artifacts", Dir: filepath.Join(s.TempDir, "artifacts")},
+		},
+		RequestPathPrefix: "/prefix",
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+
+	for path, content := range map[string]string{
+		"/prefix/test.txt":           "root",
+		"/prefix/docs/test.txt":      "docs",
+		"/prefix/artifacts/test.txt": "artifacts",
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		httpServer.Handler.ServeHTTP(w, r)
+		s.Equal(http.StatusOK, w.Result().StatusCode)
+		s.Equal(content, w.Body.String())
+	}
+
+	// redirects include both the request and the mount prefix
+	for path, location := range map[string]string{
+		"/prefix/docs":     "/prefix/docs/",
+		"/prefix/docs/sub": "/prefix/docs/sub/",
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		httpServer.Handler.ServeHTTP(w, r)
+		response := w.Result()
+		s.Equal(http.StatusMovedPermanently, response.StatusCode)
+		s.Equal(location, response.Header.Get("Location"))
+	}
+
+	// listing reports the full path
+	r := httptest.NewRequest("GET", "/prefix/docs/sub/", nil)
+	w := httptest.NewRecorder()
+	httpServer.Handler.ServeHTTP(w, r)
+	s.Equal(http.StatusOK, w.Result().StatusCode)
+	s.Contains(w.Body.String(), `Index of <span class="path">/docs/sub</span>`)
+	s.Contains(w.Body.String(), `href="/prefix/.h2static-assets/style.css"`)
+}
+
 func TestServeResources(t *testing.T) {
 	suite.Run(t, new(ServeResourcesTestSuite))
 }
@@ -0,0 +1,127 @@
+//go:build linux || darwin
+
+package server_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/albertodonato/h2static/server"
+)
+
+// runServer runs the server in background, returning a channel for the
+// result and a client connecting to the specified socket.
+func (s *StaticServerTestSuite) runServer(config server.StaticServerConfig, socketPath string) (<-chan error, *http.Client) {
+	log.SetOutput(io.Discard)
+	s.T().Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	serv, err := server.NewStaticServer(config)
+	s.Nil(err)
+	done := make(chan error, 1)
+	go func() {
+		done <- serv.Run()
+	}()
+	s.Eventually(
+		func() bool {
+			_, err := os.Stat(socketPath)
+			return err == nil
+		},
+		5*time.Second, 10*time.Millisecond)
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	s.T().Cleanup(transport.CloseIdleConnections)
+	return done, &http.Client{Transport: transport}
+}
+
+// Run shuts down all servers on SIGTERM.
+func (s *StaticServerTestSuite) TestRunShutdownOnSIGTERM() {
+	socketPath := filepath.Join(s.TempDir, "h2static.sock")
+	debugSocketPath := filepath.Join(s.TempDir, "debug.sock")
+	done, client := s.runServer(
+		server.StaticServerConfig{
+			Dir:       s.TempDir,
+			Addr:      server.AddrList{"unix:" + socketPath},
+			DebugAddr: "unix:" + debugSocketPath,
+		},
+		socketPath)
+	response, err := client.Get("http://localhost/")
+	s.Nil(err)
+	response.Body.Close()
+	s.Equal(http.StatusOK, response.StatusCode)
+
+	s.Nil(syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case err := <-done:
+		s.Nil(err)
+	case <-time.After(5 * time.Second):
+		s.Fail("server not shut down")
+	}
+	// listeners for both servers are closed
+	s.NoFileExists(socketPath)
+	s.NoFileExists(debugSocketPath)
+}
+
+// Run waits for active requests to complete on shutdown.
+func (s *StaticServerTestSuite) TestRunShutdownWaitsForActiveRequests() {
+	content := strings.Repeat("x", 4*1024*1024)
+	s.WriteFile("big.txt", content)
+	socketPath := filepath.Join(s.TempDir, "h2static.sock")
+	done, client := s.runServer(
+		server.StaticServerConfig{
+			Dir:  s.TempDir,
+			Addr: server.AddrList{"unix:" + socketPath},
+		},
+		socketPath)
+	response, err := client.Get("http://localhost/big.txt")
+	s.Nil(err)
+	defer response.Body.Close()
+
+	s.Nil(syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case <-done:
+		s.Fail("server shut down with active requests")
+	case <-time.After(100 * time.Millisecond):
+	}
+	body, err := io.ReadAll(response.Body)
+	s.Nil(err)
+	s.Equal(len(content), len(body))
+	s.Nil(<-done)
+}
+
+// Run closes active connections after the shutdown timeout.
+func (s *StaticServerTestSuite) TestRunShutdownTimeout() {
+	s.WriteFile("big.txt", strings.Repeat("x", 4*1024*1024))
+	socketPath := filepath.Join(s.TempDir, "h2static.sock")
+	done, client := s.runServer(
+		server.StaticServerConfig{
+			Dir:             s.TempDir,
+			Addr:            server.AddrList{"unix:" + socketPath},
+			ShutdownTimeout: server.Duration(100 * time.Millisecond),
+		},
+		socketPath)
+	response, err := client.Get("http://localhost/big.txt")
+	s.Nil(err)
+	defer response.Body.Close()
+
+	s.Nil(syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case err := <-done:
+		s.NotNil(err)
+		s.Equal("active connections closed before completing: context deadline exceeded", err.Error())
+	case <-time.After(5 * time.Second):
+		s.Fail("server not shut down")
+	}
+}
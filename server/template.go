@@ -34,9 +34,11 @@ type DirInfo struct {
 
 // DirEntryInfo holds details for a directory entry.
 type DirEntryInfo struct {
-	Name      string
-	IsDir     bool
-	Size      int64
+	Name  string
+	IsDir bool
+	Size  int64
+	// Whether the entry is an archive whose content can be browsed
+	IsArchive bool          `json:",omitempty"`
 	HumanSize humanSizeInfo `json:"-"`
 }
 
@@ -76,6 +78,8 @@ type templateContext struct {
 // DirectoryListingTemplateConfig holds configuration for a DirectoryListingTemplate
 type DirectoryListingTemplateConfig struct {
 	PathPrefix string
+	// URL path prefix where the listed filesystem is mounted
+	MountPrefix string
 }
 
 // DirectoryListingTemplate is a template rendered for a directory.
@@ -139,9 +143,10 @@ func (t *DirectoryListingTemplate) getTemplateContext(path string, dir *File, so
 		name := string(template.URL(f.Info.Name()))
 		size := f.Info.Size()
 		entry := DirEntryInfo{
-			Name:  name,
-			IsDir: f.Info.IsDir(),
-			Size:  size,
+			Name:      name,
+			IsDir:     f.Info.IsDir(),
+			Size:      size,
+			IsArchive: f.IsArchive(),
 		}
 		if !f.Info.IsDir() {
 			entry.HumanSize = getHumanByteSize(size)
@@ -156,8 +161,9 @@ func (t *DirectoryListingTemplate) getTemplateContext(path string, dir *File, so
 			Arch: runtime.GOARCH,
 		},
 		Dir: DirInfo{
-			Name:    path,
-			IsRoot:  path == "/",
+			Name: t.Config.MountPrefix + path,
+			// only the site root has no parent, not the root of a mount
+			IsRoot:  t.Config.MountPrefix+path == "/",
 			Entries: entries,
 		},
 		BasePath:  t.Config.PathPrefix,
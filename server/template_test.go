@@ -61,6 +61,19 @@ func (s *DirectoryListingTemplateTestSuite) TestRenderHTMLWithPathPrefix() {
 	s.Contains(content, `<link rel="stylesheet" type="text/css" href="/prefix/.h2static-assets/style.css">`)
 }
 
+// RenderHTML renders the HTML template with the mount prefix in the directory
+// name, and a link to the parent at the mount root.
+func (s *DirectoryListingTemplateTestSuite) TestRenderHTMLWithMountPrefix() {
+	template := server.NewDirectoryListingTemplate(
+		server.DirectoryListingTemplateConfig{PathPrefix: "/prefix", MountPrefix: "/docs"})
+	w := httptest.NewRecorder()
+	template.RenderHTML(w, "/", s.dir, "", true)
+	content := w.Body.String()
+	s.Contains(content, `<title>h2static - Index of /docs/</title>`)
+	s.Contains(content, `<link rel="stylesheet" type="text/css" href="/prefix/.h2static-assets/style.css">`)
+	s.Contains(content, `href=".."`)
+}
+
 // RenderHTML renders controls for descending sorting.
 func (s *DirectoryListingTemplateTestSuite) TestRenderHTMLSortControlsDesc() {
 	template := server.NewDirectoryListingTemplate(server.DirectoryListingTemplateConfig{})
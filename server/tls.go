@@ -0,0 +1,316 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig holds configuration options for automatic TLS certificates via
+// ACME.
+type ACMEConfig struct {
+	// CA certificates to trust when connecting to the ACME directory (e.g.
+	// for a local test CA)
+	CACert string `json:"ca-cert"`
+	// Directory where certificates and account keys are cached
+	CacheDir string `json:"cache-dir"`
+	// ACME directory URL. If not set, Let's Encrypt is used.
+	DirectoryURL string `json:"directory-url"`
+	// Domains to request certificates for
+	Domains []string `json:"domains"`
+	// Contact email for the ACME account
+	Email string `json:"email"`
+	// Address and port to listen on for HTTP-01 challenges
+	HTTPAddr string `json:"http-addr"`
+}
+
+// Enabled returns whether ACME is enabled in the config.
+func (c ACMEConfig) Enabled() bool {
+	return len(c.Domains) > 0
+}
+
+// Validate raises an error if ACMEConfig is invalid.
+func (c ACMEConfig) Validate() error {
+	if c.CacheDir == "" {
+		return &ConfigError{Key: "cache-dir", Err: errors.New("cache directory not set")}
+	}
+	if info, err := os.Stat(c.CacheDir); err == nil && !info.IsDir() {
+		// the directory is created if it doesn't exist
+		return &ConfigError{Key: "cache-dir", Err: fmt.Errorf("not a directory: %s", c.CacheDir)}
+	}
+	if c.CACert != "" {
+		if err := checkFile(c.CACert, false); err != nil {
+			return &ConfigError{Key: "ca-cert", Err: err}
+		}
+	}
+	for _, domain := range c.Domains {
+		if domain == "" || strings.ContainsAny(domain, "*/:") {
+			return &ConfigError{Key: "domains", Err: fmt.Errorf("invalid domain: %q", domain)}
+		}
+	}
+	return nil
+}
+
+// ClientAccessConfig holds path prefixes allowed for a TLS client
+// certificate subject.
+type ClientAccessConfig struct {
+	// Allowed path prefixes
+	Paths []string `json:"paths"`
+	// The certificate subject, either as distinguished name (e.g.
+	// "CN=client,O=Example") or common name
+	Subject string `json:"subject"`
+}
+
+// tlsClientAuthModes maps names for client certificate verification modes.
+var tlsClientAuthModes = map[string]tls.ClientAuthType{
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+// validateClientAuth raises an error if options for TLS client certificates
+// are invalid.
+func (c StaticServerConfig) validateClientAuth() error {
+	if c.TLSClientCA == "" {
+		if c.TLSClientAuth != "" {
+			return &ConfigError{Key: "tls-client-auth", Err: errors.New("requires tls-client-ca")}
+		}
+		if len(c.TLSClientAccess) > 0 {
+			return &ConfigError{Key: "tls-client-access", Err: errors.New("requires tls-client-ca")}
+		}
+		return nil
+	}
+	if !c.IsHTTPS() {
+		return &ConfigError{Key: "tls-client-ca", Err: errors.New("requires HTTPS")}
+	}
+	if err := checkFile(c.TLSClientCA, false); err != nil {
+		return &ConfigError{Key: "tls-client-ca", Err: err}
+	}
+	if _, ok := tlsClientAuthModes[c.TLSClientAuth]; c.TLSClientAuth != "" && !ok {
+		return &ConfigError{
+			Key: "tls-client-auth",
+			Err: fmt.Errorf(`invalid mode (must be "request" or "require"): %s`, c.TLSClientAuth),
+		}
+	}
+	if c.ACME.Enabled() && c.clientAuthType() == tls.RequireAndVerifyClientCert {
+		// TLS-ALPN-01 challenges are validated without client certificates
+		return &ConfigError{
+			Key: "tls-client-auth",
+			Err: errors.New(`"require" mode not supported with ACME, use "request"`),
+		}
+	}
+	for i, access := range c.TLSClientAccess {
+		if access.Subject == "" {
+			return &ConfigError{
+				Key: fmt.Sprintf("tls-client-access[%d].subject", i),
+				Err: errors.New("subject not set"),
+			}
+		}
+		for _, prefix := range access.Paths {
+			if !strings.HasPrefix(prefix, "/") {
+				return &ConfigError{
+					Key: fmt.Sprintf("tls-client-access[%d].paths", i),
+					Err: fmt.Errorf("not an absolute path: %s", prefix),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateHTTPSRedirect raises an error if options for HTTP to HTTPS
+// redirects and HSTS are invalid.
+func (c StaticServerConfig) validateHTTPSRedirect() error {
+	if c.HTTPRedirectAddr != "" && !c.IsHTTPS() {
+		return &ConfigError{Key: "http-redirect-addr", Err: errors.New("requires HTTPS")}
+	}
+	switch c.HTTPRedirectCode {
+	case 0, http.StatusMovedPermanently, http.StatusPermanentRedirect:
+	default:
+		return &ConfigError{
+			Key: "http-redirect-code",
+			Err: fmt.Errorf("invalid status code (must be 301 or 308): %d", c.HTTPRedirectCode),
+		}
+	}
+	if c.HSTSMaxAge < 0 {
+		return &ConfigError{Key: "hsts-max-age", Err: fmt.Errorf("must not be negative: %d", c.HSTSMaxAge)}
+	}
+	if c.HSTSMaxAge > 0 && !c.IsHTTPS() {
+		return &ConfigError{Key: "hsts-max-age", Err: errors.New("requires HTTPS")}
+	}
+	if c.HSTSMaxAge == 0 {
+		if c.HSTSIncludeSubDomains {
+			return &ConfigError{Key: "hsts-include-subdomains", Err: errors.New("requires hsts-max-age")}
+		}
+		if c.HSTSPreload {
+			return &ConfigError{Key: "hsts-preload", Err: errors.New("requires hsts-max-age")}
+		}
+	}
+	if c.HSTSPreload {
+		// requirements for inclusion in browsers' preload lists
+		if c.HSTSMaxAge < hstsPreloadMinMaxAge {
+			return &ConfigError{
+				Key: "hsts-preload",
+				Err: fmt.Errorf("requires hsts-max-age of at least %d", hstsPreloadMinMaxAge),
+			}
+		}
+		if !c.HSTSIncludeSubDomains {
+			return &ConfigError{Key: "hsts-preload", Err: errors.New("requires hsts-include-subdomains")}
+		}
+	}
+	return nil
+}
+
+// hstsPreloadMinMaxAge is the minimum HSTS max age (one year) for preloading.
+const hstsPreloadMinMaxAge = 365 * 24 * 60 * 60
+
+// clientAuthType returns the tls.ClientAuthType for the config.
+func (c StaticServerConfig) clientAuthType() tls.ClientAuthType {
+	if c.TLSClientCA == "" {
+		return tls.NoClientCert
+	}
+	if mode, ok := tlsClientAuthModes[c.TLSClientAuth]; ok {
+		return mode
+	}
+	// verification is required by default when a CA is set
+	return tls.RequireAndVerifyClientCert
+}
+
+// clientAccess returns allowed path prefixes by certificate subject.
+func (c StaticServerConfig) clientAccess() map[string][]string {
+	access := make(map[string][]string)
+	for _, config := range c.TLSClientAccess {
+		access[config.Subject] = append(access[config.Subject], config.Paths...)
+	}
+	return access
+}
+
+// newACMEManager returns an autocert.Manager for the config.
+func newACMEManager(config ACMEConfig) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: config.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if config.CACert != "" {
+		pool, err := loadCertPool(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(config.CacheDir),
+		HostPolicy: autocert.HostWhitelist(config.Domains...),
+		Client:     client,
+		Email:      config.Email,
+	}, nil
+}
+
+// getTLSConfig returns the TLS configuration for the server.
+func (s *StaticServer) getTLSConfig() (*tls.Config, error) {
+	params, err := s.Config.tlsParameters()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{ClientAuth: s.Config.clientAuthType()}
+	params.apply(tlsConfig)
+	if s.Config.TLSClientCA != "" {
+		pool, err := loadCertPool(s.Config.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	certificates, err := s.getCertificateStore()
+	if err != nil {
+		return nil, err
+	}
+	s.certificates = certificates
+
+	var acmeDomains map[string]bool
+	if s.Config.ACME.Enabled() {
+		manager, err := newACMEManager(s.Config.ACME)
+		if err != nil {
+			return nil, err
+		}
+		s.acmeManager = manager
+		acmeDomains = make(map[string]bool)
+		for _, domain := range s.Config.ACME.Domains {
+			acmeDomains[strings.ToLower(domain)] = true
+		}
+		tlsConfig.NextProtos = []string{acme.ALPNProto}
+	}
+
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if s.acmeManager != nil && (acmeDomains[strings.ToLower(hello.ServerName)] || isACMEChallenge(hello)) {
+			return s.acmeManager.GetCertificate(hello)
+		}
+		if s.certificates != nil {
+			return s.certificates.GetCertificate(hello)
+		}
+		return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+	}
+	return tlsConfig, nil
+}
+
+// getCertificateStore returns a CertificateStore with configured key pairs,
+// or nil if none is configured.
+//
+// The key pair from the TLSCert and TLSKey options is used as default
+// certificate if set, otherwise the first one is used.
+func (s *StaticServer) getCertificateStore() (*CertificateStore, error) {
+	var keyPairs []*KeyPairLoader
+	if s.Config.hasTLSKeyPair() {
+		keyPair, err := NewKeyPairLoader(s.Config.TLSCert, s.Config.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		keyPairs = append(keyPairs, keyPair)
+	}
+	for _, config := range s.Config.TLSCertificates {
+		keyPair, err := NewKeyPairLoader(config.Cert, config.Key)
+		if err != nil {
+			return nil, err
+		}
+		keyPairs = append(keyPairs, keyPair)
+	}
+	if s.Config.TLSCertDir != "" {
+		dirKeyPairs, err := loadKeyPairsFromDir(s.Config.TLSCertDir)
+		if err != nil {
+			return nil, err
+		}
+		keyPairs = append(keyPairs, dirKeyPairs...)
+	}
+	if len(keyPairs) == 0 {
+		return nil, nil
+	}
+	return &CertificateStore{KeyPairs: keyPairs, Default: keyPairs[0]}, nil
+}
+
+// isACMEChallenge returns whether the TLS connection is for a TLS-ALPN-01
+// challenge.
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// loadCertPool returns a pool with certificates from a PEM file.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no valid certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,476 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/acme"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestACMEConfig(t *testing.T) {
+	suite.Run(t, new(ACMEConfigTestSuite))
+}
+
+type ACMEConfigTestSuite struct {
+	testhelpers.TempDirTestSuite
+}
+
+// ACME is enabled if domains are specified.
+func (s *ACMEConfigTestSuite) TestEnabled() {
+	s.False(server.ACMEConfig{}.Enabled())
+	s.True(server.ACMEConfig{Domains: []string{"example.com"}}.Enabled())
+}
+
+// An error is returned if the cache dir is not set.
+func (s *ACMEConfigTestSuite) TestValidateNoCacheDir() {
+	config := server.StaticServerConfig{
+		Dir:  s.TempDir,
+		ACME: server.ACMEConfig{Domains: []string{"example.com"}},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("acme.cache-dir: cache directory not set", err.Error())
+}
+
+// An error is returned if the cache dir is not a directory.
+func (s *ACMEConfigTestSuite) TestValidateCacheDirNotDir() {
+	path := s.WriteFile("cache", "")
+	config := server.ACMEConfig{
+		Domains:  []string{"example.com"},
+		CacheDir: path,
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("cache-dir: not a directory: "+path, err.Error())
+}
+
+// The cache dir doesn't need to exist.
+func (s *ACMEConfigTestSuite) TestValidateCacheDirNotExists() {
+	config := server.ACMEConfig{
+		Domains:  []string{"example.com"},
+		CacheDir: filepath.Join(s.TempDir, "cache"),
+	}
+	s.Nil(config.Validate())
+}
+
+// An error is returned if the CA certificate file doesn't exist.
+func (s *ACMEConfigTestSuite) TestValidateCACertNotExists() {
+	config := server.ACMEConfig{
+		Domains:  []string{"example.com"},
+		CacheDir: s.TempDir,
+		CACert:   nonExistentPath,
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Contains(err.Error(), "ca-cert: ")
+}
+
+// An error is returned if a domain is not valid.
+func (s *ACMEConfigTestSuite) TestValidateInvalidDomain() {
+	config := server.ACMEConfig{
+		Domains:  []string{"*.example.com"},
+		CacheDir: s.TempDir,
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal(`domains: invalid domain: "*.example.com"`, err.Error())
+}
+
+func TestACME(t *testing.T) {
+	suite.Run(t, new(ACMETestSuite))
+}
+
+type ACMETestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	directory *httptest.Server
+}
+
+func (s *ACMETestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	// a minimal stand-in for an ACME directory, using a certificate from a
+	// local test CA
+	s.directory = httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{
+				"newNonce":   "https://" + r.Host + "/nonce",
+				"newAccount": "https://" + r.Host + "/account",
+				"newOrder":   "https://" + r.Host + "/order",
+			})
+		}))
+}
+
+func (s *ACMETestSuite) TearDownTest() {
+	s.directory.Close()
+	s.TempDirTestSuite.TearDownTest()
+}
+
+func (s *ACMETestSuite) writeCACert() string {
+	content := pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: s.directory.Certificate().Raw})
+	return s.WriteFile("ca.pem", string(content))
+}
+
+// The manager uses the configured directory URL and CA certificate.
+func (s *ACMETestSuite) TestManagerDirectoryWithCACert() {
+	manager, err := server.NewACMEManager(server.ACMEConfig{
+		Domains:      []string{"example.com"},
+		CacheDir:     s.TempDir,
+		DirectoryURL: s.directory.URL,
+		CACert:       s.writeCACert(),
+	})
+	s.Nil(err)
+	directory, err := manager.Client.Discover(context.Background())
+	s.Nil(err)
+	s.Equal(s.directory.URL+"/order", directory.OrderURL)
+}
+
+// Without the CA certificate, the directory is not trusted.
+func (s *ACMETestSuite) TestManagerDirectoryWithoutCACert() {
+	manager, err := server.NewACMEManager(server.ACMEConfig{
+		Domains:      []string{"example.com"},
+		CacheDir:     s.TempDir,
+		DirectoryURL: s.directory.URL,
+	})
+	s.Nil(err)
+	_, err = manager.Client.Discover(context.Background())
+	s.NotNil(err)
+	s.Contains(err.Error(), "certificate")
+}
+
+// An error is returned if the CA certificate file is invalid.
+func (s *ACMETestSuite) TestManagerInvalidCACert() {
+	path := s.WriteFile("ca.pem", "invalid")
+	_, err := server.NewACMEManager(server.ACMEConfig{
+		Domains:  []string{"example.com"},
+		CacheDir: s.TempDir,
+		CACert:   path,
+	})
+	s.Equal("no valid certificates found in "+path, err.Error())
+}
+
+// The server is configured to get certificates via ACME.
+func (s *ACMETestSuite) TestServerTLSConfig() {
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir: s.TempDir,
+		ACME: server.ACMEConfig{
+			Domains:      []string{"example.com"},
+			CacheDir:     s.TempDir,
+			DirectoryURL: s.directory.URL,
+		},
+	})
+	s.Nil(err)
+	s.Equal("https", serv.Scheme())
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	tlsConfig := httpServer.TLSConfig
+	s.Contains(tlsConfig.NextProtos, acme.ALPNProto)
+	// certificates for other domains are not requested
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.org"})
+	s.Nil(cert)
+	s.Equal(`no certificate for server name "example.org"`, err.Error())
+}
+
+func TestSNICertificates(t *testing.T) {
+	suite.Run(t, new(SNICertificatesTestSuite))
+}
+
+type SNICertificatesTestSuite struct {
+	testhelpers.TempDirTestSuite
+}
+
+func (s *SNICertificatesTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	log.SetOutput(io.Discard)
+}
+
+func (s *SNICertificatesTestSuite) TearDownTest() {
+	log.SetOutput(os.Stderr)
+	s.TempDirTestSuite.TearDownTest()
+}
+
+func (s *SNICertificatesTestSuite) writeKeyPair(certName, keyName string, names ...string) server.TLSKeyPairConfig {
+	cert, key := testhelpers.GenerateKeyPair(time.Now().Add(time.Hour), names...)
+	return server.TLSKeyPairConfig{
+		Cert: s.WriteFile(certName, string(cert)),
+		Key:  s.WriteFile(keyName, string(key)),
+	}
+}
+
+func (s *SNICertificatesTestSuite) certName(tlsConfig *tls.Config, serverName string) string {
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	s.Nil(err)
+	return cert.Leaf.DNSNames[0]
+}
+
+// HTTPS is enabled if additional certificates are configured.
+func (s *SNICertificatesTestSuite) TestIsHTTPS() {
+	s.True(server.StaticServerConfig{TLSCertDir: s.TempDir}.IsHTTPS())
+	s.True(
+		server.StaticServerConfig{
+			TLSCertificates: []server.TLSKeyPairConfig{{Cert: "cert.pem", Key: "key.pem"}},
+		}.IsHTTPS())
+}
+
+// An error is returned if a certificate file doesn't exist.
+func (s *SNICertificatesTestSuite) TestValidateCertificateNotExists() {
+	config := server.StaticServerConfig{
+		Dir: s.TempDir,
+		TLSCertificates: []server.TLSKeyPairConfig{
+			{Cert: nonExistentPath, Key: s.WriteFile("key.pem", "")},
+		},
+	}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Contains(err.Error(), "tls-certificates[0].cert: ")
+}
+
+// An error is returned if the certificates dir is not a directory.
+func (s *SNICertificatesTestSuite) TestValidateCertDirNotDir() {
+	path := s.WriteFile("certs", "")
+	config := server.StaticServerConfig{Dir: s.TempDir, TLSCertDir: path}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("tls-cert-dir: not a directory: "+path, err.Error())
+}
+
+// Certificates are selected by server name, with the one from the TLSCert
+// option as default.
+func (s *SNICertificatesTestSuite) TestServerCertificates() {
+	defaultPair := s.writeKeyPair("default.pem", "default.key", "default.example.com")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:     s.TempDir,
+		TLSCert: defaultPair.Cert,
+		TLSKey:  defaultPair.Key,
+		TLSCertificates: []server.TLSKeyPairConfig{
+			s.writeKeyPair("org.pem", "org.key", "example.org"),
+		},
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	s.Equal("example.org", s.certName(httpServer.TLSConfig, "example.org"))
+	s.Equal("default.example.com", s.certName(httpServer.TLSConfig, "example.net"))
+}
+
+// Certificates are loaded from a directory, with the first as default.
+func (s *SNICertificatesTestSuite) TestServerCertificatesFromDir() {
+	certDir := s.Mkdir("certs")
+	s.writeKeyPair("certs/a.crt", "certs/a.key", "a.example.com")
+	s.writeKeyPair("certs/b.pem", "certs/b.key", "*.example.org")
+	// certificates without key are ignored
+	s.WriteFile("certs/c.pem", "")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:        s.TempDir,
+		TLSCertDir: certDir,
+	})
+	s.Nil(err)
+	s.True(serv.Config.IsHTTPS())
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	s.Equal("*.example.org", s.certName(httpServer.TLSConfig, "www.example.org"))
+	s.Equal("a.example.com", s.certName(httpServer.TLSConfig, "a.example.com"))
+	s.Equal("a.example.com", s.certName(httpServer.TLSConfig, "example.net"))
+}
+
+// An error is returned if no certificates are found in the directory.
+func (s *SNICertificatesTestSuite) TestServerCertificatesFromDirEmpty() {
+	certDir := s.Mkdir("certs")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:        s.TempDir,
+		TLSCertDir: certDir,
+	})
+	s.Nil(err)
+	_, err = server.GetServer(serv)
+	s.Equal("no TLS certificates found in "+certDir, err.Error())
+}
+
+func TestClientCertificates(t *testing.T) {
+	suite.Run(t, new(ClientCertificatesTestSuite))
+}
+
+type ClientCertificatesTestSuite struct {
+	testhelpers.TempDirTestSuite
+
+	ca      *testhelpers.CertificateAuthority
+	caFile  string
+	tlsCert string
+	tlsKey  string
+}
+
+func (s *ClientCertificatesTestSuite) SetupTest() {
+	s.TempDirTestSuite.SetupTest()
+	log.SetOutput(io.Discard)
+	s.ca = testhelpers.NewCertificateAuthority("test CA")
+	s.caFile = s.WriteFile("ca.pem", string(s.ca.CertPEM()))
+	cert, key := testhelpers.GenerateKeyPair(time.Now().Add(time.Hour), "localhost")
+	s.tlsCert = s.WriteFile("cert.pem", string(cert))
+	s.tlsKey = s.WriteFile("key.pem", string(key))
+	s.Mkdir("docs")
+	s.WriteFile("docs/test.txt", "docs")
+	s.Mkdir("private")
+	s.WriteFile("private/test.txt", "private")
+}
+
+func (s *ClientCertificatesTestSuite) TearDownTest() {
+	log.SetOutput(os.Stderr)
+	s.TempDirTestSuite.TearDownTest()
+}
+
+func (s *ClientCertificatesTestSuite) config() server.StaticServerConfig {
+	return server.StaticServerConfig{
+		Dir:         s.TempDir,
+		TLSCert:     s.tlsCert,
+		TLSKey:      s.tlsKey,
+		TLSClientCA: s.caFile,
+	}
+}
+
+// startServer starts a test server with the config, returning a client
+// configured to trust it.
+func (s *ClientCertificatesTestSuite) startServer(config server.StaticServerConfig) (*httptest.Server, *http.Client) {
+	serv, err := server.NewStaticServer(config)
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	testServer := httptest.NewUnstartedServer(httpServer.Handler)
+	testServer.TLS = httpServer.TLSConfig
+	testServer.StartTLS()
+	return testServer, testServer.Client()
+}
+
+func (s *ClientCertificatesTestSuite) withClientCert(client *http.Client, commonName string) {
+	cert := s.ca.IssueClientCertificate(pkix.Name{CommonName: commonName})
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+}
+
+// An error is returned if the client auth mode is not valid.
+func (s *ClientCertificatesTestSuite) TestValidateInvalidMode() {
+	config := s.config()
+	config.TLSClientAuth = "maybe"
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal(`tls-client-auth: invalid mode (must be "request" or "require"): maybe`, err.Error())
+}
+
+// An error is returned if client auth options are set without CA.
+func (s *ClientCertificatesTestSuite) TestValidateNoCA() {
+	config := s.config()
+	config.TLSClientCA = ""
+	config.TLSClientAuth = "require"
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("tls-client-auth: requires tls-client-ca", err.Error())
+}
+
+// An error is returned if client certificates are required with ACME.
+func (s *ClientCertificatesTestSuite) TestValidateRequireWithACME() {
+	config := s.config()
+	config.ACME = server.ACMEConfig{Domains: []string{"example.com"}, CacheDir: s.TempDir}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal(`tls-client-auth: "require" mode not supported with ACME, use "request"`, err.Error())
+
+	config.TLSClientAuth = "request"
+	s.Nil(config.Validate())
+}
+
+// An error is returned if client auth is set without HTTPS.
+func (s *ClientCertificatesTestSuite) TestValidateNoHTTPS() {
+	config := s.config()
+	config.TLSCert = ""
+	config.TLSKey = ""
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("tls-client-ca: requires HTTPS", err.Error())
+}
+
+// An error is returned if the subject for access rules is not set.
+func (s *ClientCertificatesTestSuite) TestValidateAccessNoSubject() {
+	config := s.config()
+	config.TLSClientAccess = []server.ClientAccessConfig{{Paths: []string{"/"}}}
+	err := config.Validate()
+	s.NotNil(err)
+	s.Equal("tls-client-access[0].subject: subject not set", err.Error())
+}
+
+// A client certificate is required by default.
+func (s *ClientCertificatesTestSuite) TestRequireClientCert() {
+	testServer, client := s.startServer(s.config())
+	defer testServer.Close()
+	_, err := client.Get(testServer.URL + "/docs/test.txt")
+	s.NotNil(err)
+
+	s.withClientCert(client, "client")
+	response, err := client.Get(testServer.URL + "/docs/test.txt")
+	s.Nil(err)
+	s.Equal(http.StatusOK, response.StatusCode)
+}
+
+// A client certificate from another CA is rejected.
+func (s *ClientCertificatesTestSuite) TestRequireClientCertUnknownCA() {
+	testServer, client := s.startServer(s.config())
+	defer testServer.Close()
+	s.ca = testhelpers.NewCertificateAuthority("other CA")
+	s.withClientCert(client, "client")
+	_, err := client.Get(testServer.URL + "/docs/test.txt")
+	s.NotNil(err)
+}
+
+// In "request" mode, clients without certificate are allowed.
+func (s *ClientCertificatesTestSuite) TestRequestClientCert() {
+	config := s.config()
+	config.TLSClientAuth = "request"
+	testServer, client := s.startServer(config)
+	defer testServer.Close()
+	response, err := client.Get(testServer.URL + "/docs/test.txt")
+	s.Nil(err)
+	s.Equal(http.StatusOK, response.StatusCode)
+}
+
+// Access to paths is restricted based on the certificate subject.
+func (s *ClientCertificatesTestSuite) TestClientAccess() {
+	config := s.config()
+	config.TLSClientAuth = "request"
+	config.TLSClientAccess = []server.ClientAccessConfig{
+		{Subject: "reader", Paths: []string{"/docs"}},
+		{Subject: "CN=admin", Paths: []string{"/"}},
+	}
+	testServer, client := s.startServer(config)
+	defer testServer.Close()
+
+	response, err := client.Get(testServer.URL + "/docs/test.txt")
+	s.Nil(err)
+	s.Equal(http.StatusForbidden, response.StatusCode)
+
+	s.withClientCert(client, "reader")
+	response, err = client.Get(testServer.URL + "/docs/test.txt")
+	s.Nil(err)
+	s.Equal(http.StatusOK, response.StatusCode)
+	response, err = client.Get(testServer.URL + "/private/test.txt")
+	s.Nil(err)
+	s.Equal(http.StatusForbidden, response.StatusCode)
+
+	client.CloseIdleConnections()
+	s.withClientCert(client, "admin")
+	response, err = client.Get(testServer.URL + "/private/test.txt")
+	s.Nil(err)
+	s.Equal(http.StatusOK, response.StatusCode)
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TLSParameters holds TLS protocol parameters.
+type TLSParameters struct {
+	// Name of the profile the parameters are based on, if any
+	Profile string
+	// Minimum TLS version. If zero, the Go default is used.
+	MinVersion uint16
+	// Cipher suites for TLS up to 1.2. If nil, the Go default is used.
+	CipherSuites []uint16
+	// Elliptic curves for key exchange. If nil, the Go default is used.
+	Curves []tls.CurveID
+}
+
+// String returns a short description of the parameters.
+func (p TLSParameters) String() string {
+	profile := p.Profile
+	if profile == "" {
+		profile = "default"
+	}
+	minVersion := "default"
+	if p.MinVersion != 0 {
+		minVersion = tlsVersionName(p.MinVersion)
+	}
+	return fmt.Sprintf("%s profile, min version %s", profile, minVersion)
+}
+
+// apply sets the parameters in a tls.Config.
+func (p TLSParameters) apply(config *tls.Config) {
+	config.MinVersion = p.MinVersion
+	config.CipherSuites = p.CipherSuites
+	config.CurvePreferences = p.Curves
+}
+
+// TLS profiles, based on Mozilla's recommended server configurations.
+var tlsProfiles = map[string]TLSParameters{
+	"modern": {
+		MinVersion: tls.VersionTLS13,
+		Curves:     []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+	"intermediate": {
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		Curves: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+	"legacy": {
+		MinVersion: tls.VersionTLS10,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		},
+		Curves: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P-256":  tls.CurveP256,
+	"P-384":  tls.CurveP384,
+	"P-521":  tls.CurveP521,
+}
+
+func tlsVersionName(version uint16) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// tlsParameters returns TLS parameters for the config, based on the
+// profile and explicit options.
+func (c StaticServerConfig) tlsParameters() (TLSParameters, error) {
+	var params TLSParameters
+	if c.TLSProfile != "" {
+		profile, ok := tlsProfiles[c.TLSProfile]
+		if !ok {
+			return params, &ConfigError{
+				Key: "tls-profile",
+				Err: fmt.Errorf("unknown profile (must be one of %s): %s", sortedKeys(tlsProfiles), c.TLSProfile),
+			}
+		}
+		params = profile
+		params.Profile = c.TLSProfile
+	}
+
+	if c.TLSMinVersion != "" {
+		version, ok := tlsVersions[c.TLSMinVersion]
+		if !ok {
+			return params, &ConfigError{
+				Key: "tls-min-version",
+				Err: fmt.Errorf("unknown version (must be one of %s): %s", sortedKeys(tlsVersions), c.TLSMinVersion),
+			}
+		}
+		params.MinVersion = version
+	}
+
+	if len(c.TLSCipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[suite.Name] = suite.ID
+		}
+		params.CipherSuites = nil
+		for _, name := range c.TLSCipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return params, &ConfigError{
+					Key: "tls-cipher-suites",
+					Err: fmt.Errorf("unknown cipher suite: %s", name),
+				}
+			}
+			params.CipherSuites = append(params.CipherSuites, id)
+		}
+		if params.MinVersion == tls.VersionTLS13 {
+			return params, &ConfigError{
+				Key: "tls-cipher-suites",
+				Err: errors.New("cipher suites can't be configured for TLS 1.3"),
+			}
+		}
+	}
+
+	if len(c.TLSCurves) > 0 {
+		params.Curves = nil
+		for _, name := range c.TLSCurves {
+			curve, ok := tlsCurves[name]
+			if !ok {
+				return params, &ConfigError{
+					Key: "tls-curves",
+					Err: fmt.Errorf("unknown curve (must be one of %s): %s", sortedKeys(tlsCurves), name),
+				}
+			}
+			params.Curves = append(params.Curves, curve)
+		}
+	}
+	return params, nil
+}
+
+// sortedKeys returns a comma-separated list of sorted keys for a map.
+func sortedKeys[V any](m map[string]V) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/albertodonato/h2static/server"
+	"github.com/albertodonato/h2static/testhelpers"
+)
+
+func TestTLSParameters(t *testing.T) {
+	suite.Run(t, new(TLSParametersTestSuite))
+}
+
+type TLSParametersTestSuite struct {
+	testhelpers.TempDirTestSuite
+}
+
+// Without options, Go defaults are used.
+func (s *TLSParametersTestSuite) TestDefault() {
+	params, err := server.GetTLSParameters(server.StaticServerConfig{})
+	s.Nil(err)
+	s.Equal(server.TLSParameters{}, params)
+	s.Equal("default profile, min version default", params.String())
+}
+
+// Parameters are set from the profile.
+func (s *TLSParametersTestSuite) TestProfile() {
+	params, err := server.GetTLSParameters(server.StaticServerConfig{TLSProfile: "modern"})
+	s.Nil(err)
+	s.Equal("modern", params.Profile)
+	s.Equal(uint16(tls.VersionTLS13), params.MinVersion)
+	s.Nil(params.CipherSuites)
+	s.Equal([]tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}, params.Curves)
+	s.Equal("modern profile, min version 1.3", params.String())
+
+	params, err = server.GetTLSParameters(server.StaticServerConfig{TLSProfile: "intermediate"})
+	s.Nil(err)
+	s.Equal(uint16(tls.VersionTLS12), params.MinVersion)
+	s.Contains(params.CipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+	s.NotContains(params.CipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA)
+}
+
+// Explicit options override the profile.
+func (s *TLSParametersTestSuite) TestOverrides() {
+	params, err := server.GetTLSParameters(
+		server.StaticServerConfig{
+			TLSProfile:      "intermediate",
+			TLSMinVersion:   "1.1",
+			TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+			TLSCurves:       []string{"P-384", "P-521"},
+		})
+	s.Nil(err)
+	s.Equal(
+		server.TLSParameters{
+			Profile:      "intermediate",
+			MinVersion:   tls.VersionTLS11,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			Curves:       []tls.CurveID{tls.CurveP384, tls.CurveP521},
+		},
+		params)
+	s.Equal("intermediate profile, min version 1.1", params.String())
+}
+
+// An error is returned for an unknown profile.
+func (s *TLSParametersTestSuite) TestUnknownProfile() {
+	_, err := server.GetTLSParameters(server.StaticServerConfig{TLSProfile: "foo"})
+	s.Equal(
+		"tls-profile: unknown profile (must be one of intermediate, legacy, modern): foo",
+		err.Error())
+}
+
+// An error is returned for an unknown TLS version.
+func (s *TLSParametersTestSuite) TestUnknownMinVersion() {
+	_, err := server.GetTLSParameters(server.StaticServerConfig{TLSMinVersion: "2.0"})
+	s.Equal(
+		"tls-min-version: unknown version (must be one of 1.0, 1.1, 1.2, 1.3): 2.0",
+		err.Error())
+}
+
+// An error is returned for an unknown cipher suite.
+func (s *TLSParametersTestSuite) TestUnknownCipherSuite() {
+	_, err := server.GetTLSParameters(server.StaticServerConfig{TLSCipherSuites: []string{"FOO"}})
+	s.Equal("tls-cipher-suites: unknown cipher suite: FOO", err.Error())
+}
+
+// An error is returned if cipher suites are set with TLS 1.3 only.
+func (s *TLSParametersTestSuite) TestCipherSuitesTLS13() {
+	_, err := server.GetTLSParameters(
+		server.StaticServerConfig{
+			TLSProfile:      "modern",
+			TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+		})
+	s.Equal("tls-cipher-suites: cipher suites can't be configured for TLS 1.3", err.Error())
+}
+
+// An error is returned for an unknown curve.
+func (s *TLSParametersTestSuite) TestUnknownCurve() {
+	_, err := server.GetTLSParameters(server.StaticServerConfig{TLSCurves: []string{"P-1"}})
+	s.Equal(
+		"tls-curves: unknown curve (must be one of P-256, P-384, P-521, X25519): P-1",
+		err.Error())
+}
+
+// Invalid parameters are reported by config validation.
+func (s *TLSParametersTestSuite) TestValidate() {
+	config := server.StaticServerConfig{Dir: s.TempDir, TLSProfile: "foo"}
+	s.NotNil(config.Validate())
+}
+
+// The server TLS config uses the parameters.
+func (s *TLSParametersTestSuite) TestServerTLSConfig() {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	cert, key := testhelpers.GenerateKeyPair(time.Now().Add(time.Hour), "example.com")
+	serv, err := server.NewStaticServer(server.StaticServerConfig{
+		Dir:        s.TempDir,
+		TLSCert:    s.WriteFile("cert.pem", string(cert)),
+		TLSKey:     s.WriteFile("key.pem", string(key)),
+		TLSProfile: "modern",
+		TLSCurves:  []string{"X25519"},
+	})
+	s.Nil(err)
+	httpServer, err := server.GetServer(serv)
+	s.Nil(err)
+	s.Equal(uint16(tls.VersionTLS13), httpServer.TLSConfig.MinVersion)
+	s.Equal([]tls.CurveID{tls.X25519}, httpServer.TLSConfig.CurvePreferences)
+}
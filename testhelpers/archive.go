@@ -0,0 +1,86 @@
+package testhelpers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WriteZip creates a zip archive with the specified files, returning the
+// absolute path. Names ending with a slash are added as directories. Files
+// are stored with the specified compression method.
+func (s *TempDirTestSuite) WriteZip(name string, files map[string]string, method uint16) string {
+	path := s.absPath(name)
+	file, err := os.Create(path)
+	s.Nil(err)
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for _, name := range sortedNames(files) {
+		header := &zip.FileHeader{
+			Name:     name,
+			Method:   method,
+			Modified: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		if strings.HasSuffix(name, "/") {
+			header.SetMode(os.ModeDir | 0755)
+		} else {
+			header.SetMode(0644)
+		}
+		w, err := writer.CreateHeader(header)
+		s.Nil(err)
+		_, err = io.WriteString(w, files[name])
+		s.Nil(err)
+	}
+	s.Nil(writer.Close())
+	return path
+}
+
+// WriteTar creates a tar archive with the specified files, returning the
+// absolute path. Names ending with a slash are added as directories. The
+// archive is compressed with gzip if the name has a .gz or .tgz suffix.
+func (s *TempDirTestSuite) WriteTar(name string, files map[string]string) string {
+	path := s.absPath(name)
+	file, err := os.Create(path)
+	s.Nil(err)
+	defer file.Close()
+	var out io.Writer = file
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gzipWriter := gzip.NewWriter(file)
+		defer func() { s.Nil(gzipWriter.Close()) }()
+		out = gzipWriter
+	}
+	writer := tar.NewWriter(out)
+	defer func() { s.Nil(writer.Close()) }()
+	for _, name := range sortedNames(files) {
+		header := &tar.Header{
+			Name:    name,
+			ModTime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		if strings.HasSuffix(name, "/") {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		} else {
+			header.Typeflag = tar.TypeReg
+			header.Mode = 0644
+			header.Size = int64(len(files[name]))
+		}
+		s.Nil(writer.WriteHeader(header))
+		_, err := io.WriteString(writer, files[name])
+		s.Nil(err)
+	}
+	return path
+}
+
+func sortedNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}